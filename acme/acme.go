@@ -15,12 +15,14 @@ import (
 	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"9fans.net/go/draw"
 	"9fans.net/go/plan9"
@@ -31,46 +33,204 @@ import (
 // Obtain one with Mount; simple tools use the package-level functions
 // which delegate to an internal default Fsys initialised via sync.Once.
 type Fsys struct {
-	fs *client.Fsys
+	mu        sync.Mutex
+	fs        *client.Fsys                 // nil after a connection error, until re-dialed
+	redial    func() (*client.Fsys, error) // set by MountReconnect and SetReconnect
+	redialNow bool                         // re-dial as soon as the connection fails
+}
+
+// ErrReconnected is returned by operations on a window whose
+// connection to acme failed and was dropped so that the Fsys
+// could re-dial. The window's files belong to the old connection;
+// open the window again through the Fsys to go on using it.
+var ErrReconnected = errors.New("acme: window opened before reconnect")
+
+var errNotConnected = errors.New("acme: not connected")
+
+// client returns the connection to use for the next call,
+// re-dialing if the last one failed.
+func (f *Fsys) client() (*client.Fsys, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fs == nil {
+		if f.redial == nil {
+			return nil, errNotConnected
+		}
+		fs, err := f.redial()
+		if err != nil {
+			return nil, err
+		}
+		f.fs = fs
+	}
+	return f.fs, nil
+}
+
+// check returns err, an error from a call on fs, which client returned.
+// If f can re-dial and err shows the connection to have failed,
+// check drops fs, so that the next call dials a new one,
+// or, for the default Fsys after SetReconnect, dials one now.
+// A 9P error means the connection works; for any other, check
+// asks the server for a stat of the root to be sure.
+func (f *Fsys) check(fs *client.Fsys, err error) error {
+	f.mu.Lock()
+	canRedial, now := f.redial != nil, f.redialNow
+	f.mu.Unlock()
+	if err == nil || !canRedial {
+		return err
+	}
+	var e client.Error
+	if errors.As(err, &e) {
+		return err
+	}
+	if _, serr := fs.Stat(""); serr == nil || errors.As(serr, &e) {
+		return err
+	}
+	f.mu.Lock()
+	if f.fs == fs {
+		f.fs = nil
+		fs.Close()
+	}
+	f.mu.Unlock()
+	if now {
+		// A failed dial leaves f.fs nil for the next call to retry.
+		f.client()
+	}
+	return err
+}
+
+// setReconnect sets how f re-dials as soon as its connection fails,
+// or, if redial is nil, stops it re-dialing.
+func (f *Fsys) setReconnect(redial func() (*client.Fsys, error)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.redial = redial
+	f.redialNow = redial != nil
+}
+
+// connected reports whether fs is f's current connection.
+func (f *Fsys) connected(fs *client.Fsys) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fs == fs
+}
+
+// canRedial reports whether f re-dials a failed connection.
+func (f *Fsys) canRedial() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.redial != nil
 }
 
 // New creates a new acme window on this connection.
 func (f *Fsys) New() (*Win, error) {
-	fid, err := f.fs.Open("new/ctl", plan9.ORDWR)
+	fs, err := f.client()
 	if err != nil {
 		return nil, err
 	}
-	buf := make([]byte, 100)
-	n, err := fid.Read(buf)
+	fid, err := fs.Open("new/ctl", plan9.ORDWR)
+	if err != nil {
+		return nil, f.check(fs, err)
+	}
+	buf := make([]byte, 8192)
+	n, err := fid.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		fid.Close()
+		return nil, f.check(fs, err)
+	}
+	id, err := ctlID(string(buf[:n]))
 	if err != nil {
 		fid.Close()
 		return nil, err
 	}
-	a := strings.Fields(string(buf[0:n]))
+	return f.open(fs, id, fid), nil
+}
+
+// ctlID returns the window id from the contents of a window's ctl file.
+// The id is the first field, normally formatted like the other
+// numeric fields as 11 characters followed by a space.
+func ctlID(line string) (int, error) {
+	var id int
+	if _, err := splitFields(line, &id); err == nil && id > 0 {
+		return id, nil
+	}
+	// Not in the fixed-width layout; accept any leading number.
+	a := strings.Fields(line)
 	if len(a) == 0 {
-		fid.Close()
-		return nil, errors.New("short read from acme/new/ctl")
+		return 0, errors.New("short read from acme/new/ctl")
 	}
 	id, err := strconv.Atoi(a[0])
+	if err != nil || id <= 0 {
+		return 0, errors.New("invalid window id in acme/new/ctl: " + a[0])
+	}
+	return id, nil
+}
+
+// Edit creates a new window editing the named file on this connection,
+// as if the file had been opened from within acme: the window is named
+// for the file's absolute path and its body is loaded by acme itself,
+// so Put writes the body back to the file.
+// If the file does not exist, the window starts empty and Put creates it.
+func (f *Fsys) Edit(file string) (*Win, error) {
+	file, err := filepath.Abs(file)
 	if err != nil {
-		fid.Close()
-		return nil, errors.New("invalid window id in acme/new/ctl: " + a[0])
+		return nil, err
+	}
+	_, err = os.Stat(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
 	}
-	return f.Open(id, fid)
+	exists := err == nil
+
+	w, err := f.New()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Name("%s", file); err != nil {
+		w.abandon()
+		return nil, err
+	}
+	if exists {
+		if err := w.Ctl("get"); err != nil {
+			w.abandon()
+			return nil, err
+		}
+	}
+	if err := w.Ctl("clean"); err != nil {
+		w.abandon()
+		return nil, err
+	}
+	return w, nil
+}
+
+// abandon deletes a window that could not be set up
+// and releases its resources.
+func (w *Win) abandon() {
+	w.Del(true)
+	w.CloseFiles()
+	w.drop()
 }
 
 // Open connects to the existing window with the given id on this connection.
 // If ctl is non-nil it is used as the window's control file (ownership transferred).
 func (f *Fsys) Open(id int, ctl *client.Fid) (*Win, error) {
+	fs, err := f.client()
+	if err != nil {
+		return nil, err
+	}
 	if ctl == nil {
-		var err error
-		ctl, err = f.fs.Open(fmt.Sprintf("%d/ctl", id), plan9.ORDWR)
+		ctl, err = fs.Open(fmt.Sprintf("%d/ctl", id), plan9.ORDWR)
 		if err != nil {
-			return nil, err
+			return nil, f.check(fs, err)
 		}
 	}
+	return f.open(fs, id, ctl), nil
+}
+
+// open returns the window with the given id and control file ctl on fs.
+func (f *Fsys) open(fs *client.Fsys, id int, ctl *client.Fid) *Win {
 	w := new(Win)
-	w.fs = f.fs
+	w.f = f
+	w.fs = fs
 	w.id = id
 	w.ctl = ctl
 	windowsMu.Lock()
@@ -82,19 +242,23 @@ func (f *Fsys) Open(id int, ctl *client.Fid) (*Win, error) {
 	}
 	last = w
 	windowsMu.Unlock()
-	return w, nil
+	return w
 }
 
 // Windows returns a list of the existing acme windows on this connection.
 func (f *Fsys) Windows() ([]WinInfo, error) {
-	index, err := f.fs.Open("index", plan9.OREAD)
+	fs, err := f.client()
 	if err != nil {
 		return nil, err
 	}
+	index, err := fs.Open("index", plan9.OREAD)
+	if err != nil {
+		return nil, f.check(fs, err)
+	}
 	defer index.Close()
 	data, err := ioutil.ReadAll(index)
 	if err != nil {
-		return nil, err
+		return nil, f.check(fs, err)
 	}
 	var infos []WinInfo
 	for _, line := range strings.Split(string(data), "\n") {
@@ -127,15 +291,20 @@ func (f *Fsys) Windows() ([]WinInfo, error) {
 
 // Log returns a reader for the acme log file on this connection.
 func (f *Fsys) Log() (*LogReader, error) {
-	fid, err := f.fs.Open("log", plan9.OREAD)
+	fs, err := f.client()
 	if err != nil {
 		return nil, err
 	}
-	return &LogReader{f: fid}, nil
+	fid, err := fs.Open("log", plan9.OREAD)
+	if err != nil {
+		return nil, f.check(fs, err)
+	}
+	return &LogReader{fs: f, f: fid}, nil
 }
 
 // A Win represents a single acme window and its control files.
 type Win struct {
+	f          *Fsys
 	fs         *client.Fsys
 	id         int
 	ctl        *client.Fid
@@ -155,8 +324,15 @@ type Win struct {
 	buf        []byte
 	e2, e3, e4 Event
 	name       string
+	info       *WinInfo // result of last successful Info call
 
 	errorPrefix string
+
+	addrmu sync.Mutex // held around each use of the addr file; see Addr
+
+	romu     sync.Mutex
+	readonly bool   // see SetReadOnly
+	robody   []byte // body to restore while readonly
 }
 
 var windowsMu sync.Mutex
@@ -169,9 +345,13 @@ var defaultOnce sync.Once
 
 // defaultFS returns the lazily-initialised default Fsys, calling
 // mountAcme exactly once.  Mirrors the upstream fsysOnce.Do(mountAcme) pattern.
+// After SetReconnect, a failed first dial is retried by the Fsys itself.
 func defaultFS() (*Fsys, error) {
 	defaultOnce.Do(mountAcme)
-	return defaultFsys, defaultErr
+	if defaultErr != nil && !defaultFsys.canRedial() {
+		return nil, defaultErr
+	}
+	return defaultFsys, nil
 }
 
 // AutoExit sets whether to call os.Exit the next time the last managed acme window is deleted.
@@ -192,6 +372,38 @@ func New() (*Win, error) {
 	return f.New()
 }
 
+// NewInColumn creates a new window named name holding body, marks it
+// clean and moves it to column col, counting from 0 at the left.
+// If there is no such column, acme adds one at the right, or uses the
+// rightmost column if there is no room for another.
+//
+// Moving the window needs an acme that understands the "col" ctl
+// message, such as 9fans.net/go/cmd/acme. If the move fails, the window
+// stays where acme first put it and NewInColumn returns it along with
+// the error.
+func NewInColumn(name string, col int, body []byte) (*Win, error) {
+	w, err := New()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Name("%s", name); err != nil {
+		w.CloseFiles()
+		return nil, err
+	}
+	if _, err := w.Write("body", body); err != nil {
+		w.CloseFiles()
+		return nil, err
+	}
+	if err := w.Ctl("clean"); err != nil {
+		w.CloseFiles()
+		return nil, err
+	}
+	if err := w.Ctl("col %d", col); err != nil {
+		return w, fmt.Errorf("acme: moving window to column %d: %v", col, err)
+	}
+	return w, nil
+}
+
 type WinInfo struct {
 	ID int
 	// TagLen holds the length of the tag in runes.
@@ -225,6 +437,7 @@ type WinSizeInfo struct {
 
 // A LogReader provides read access to the acme log file.
 type LogReader struct {
+	fs  *Fsys
 	f   *client.Fid
 	buf [8192]byte
 }
@@ -233,28 +446,94 @@ func (r *LogReader) Close() error {
 	return r.f.Close()
 }
 
+// A LogOp is the kind of a LogEvent.
+type LogOp int
+
+const (
+	LogUnknown LogOp = iota // an operation this package does not know
+	LogNew                  // window created
+	LogZerox                // window created by Zerox
+	LogGet                  // file loaded by Get
+	LogPut                  // file written by Put
+	LogDel                  // window deleted
+	LogFocus                // window given the keyboard focus
+)
+
+var logOps = [...]string{
+	LogUnknown: "unknown",
+	LogNew:     "new",
+	LogZerox:   "zerox",
+	LogGet:     "get",
+	LogPut:     "put",
+	LogDel:     "del",
+	LogFocus:   "focus",
+}
+
+// String returns the name of op as it appears in the acme log file.
+func (op LogOp) String() string {
+	if op < 0 || int(op) >= len(logOps) {
+		return fmt.Sprintf("LogOp(%d)", int(op))
+	}
+	return logOps[op]
+}
+
+func parseLogOp(s string) LogOp {
+	for op, name := range logOps {
+		if name == s && LogOp(op) != LogUnknown {
+			return LogOp(op)
+		}
+	}
+	return LogUnknown
+}
+
 // A LogEvent is a single event in the acme log file.
+// Events with operations added in later versions of acme have
+// Op set to LogUnknown and RawOp set to the operation's name.
 type LogEvent struct {
-	ID   int
-	Op   string
-	Name string
+	ID    int
+	Op    LogOp
+	RawOp string
+	Name  string
+
+	fs *Fsys
+}
+
+// Open opens the window the event is about, using the connection
+// from which the event was read. It fails if the window has since
+// been deleted, as it always has for a LogDel event.
+func (e LogEvent) Open() (*Win, error) {
+	if e.fs == nil {
+		return nil, errors.New("acme: log event not read from a LogReader")
+	}
+	return e.fs.Open(e.ID, nil)
 }
 
+var errMalformedLog = errors.New("malformed log event")
+
 // Read reads an event from the acme log file.
 func (r *LogReader) Read() (LogEvent, error) {
 	n, err := r.f.Read(r.buf[:])
 	if err != nil {
 		return LogEvent{}, err
 	}
-	f := strings.SplitN(string(r.buf[:n]), " ", 3)
+	e, err := parseLogEvent(string(r.buf[:n]))
+	e.fs = r.fs
+	return e, err
+}
+
+// parseLogEvent parses a line of the acme log file: "id op name\n".
+func parseLogEvent(line string) (LogEvent, error) {
+	f := strings.SplitN(line, " ", 3)
 	if len(f) != 3 {
-		return LogEvent{}, fmt.Errorf("malformed log event")
+		return LogEvent{}, errMalformedLog
 	}
 	id, _ := strconv.Atoi(f[0])
-	op := f[1]
-	name := f[2]
-	name = strings.TrimSpace(name)
-	return LogEvent{id, op, name}, nil
+	return LogEvent{
+		ID:    id,
+		Op:    parseLogOp(f[1]),
+		RawOp: f[1],
+		Name:  strings.TrimSpace(f[2]),
+	}, nil
 }
 
 // Log returns a reader for the acme log file using the default connection.
@@ -296,6 +575,16 @@ func Show(name string) *Win {
 	return nil
 }
 
+// Edit creates a new window editing the named file using the default connection.
+// See Fsys.Edit for details.
+func Edit(file string) (*Win, error) {
+	f, err := defaultFS()
+	if err != nil {
+		return nil, err
+	}
+	return f.Edit(file)
+}
+
 // Open connects to the existing window with the given id using the default connection.
 // If ctl is non-nil it is used as the window's control file (ownership transferred).
 func Open(id int, ctl *client.Fid) (*Win, error) {
@@ -307,20 +596,277 @@ func Open(id int, ctl *client.Fid) (*Win, error) {
 }
 
 // Addr writes format, ... to the window's addr file.
+//
+// The addr file is shared by everything that edits the window through
+// w, including ApplyDiff, InsertAt and the reverting of user edits to
+// a read-only window, which run their address and data writes as one
+// sequence that Addr, ReadAddr and writes to the addr, data and xdata
+// files wait for. Sequences the program calls, such as ApplyDiff,
+// InsertAt, DeleteRange, Extract and SelectLine, leave the address
+// where their last write put it, so call Addr again after them.
+// Those run on the goroutine reading events, which revert user edits
+// and read the text acme omits from events, may run between a call
+// to Addr and a later write of data, but put back the address they
+// found.
 func (w *Win) Addr(format string, args ...interface{}) error {
-	return w.Fprintf("addr", format, args...)
+	w.addrmu.Lock()
+	defer w.addrmu.Unlock()
+	return w.setAddr(format, args...)
+}
+
+// setAddr is Addr for callers holding w.addrmu.
+func (w *Win) setAddr(format string, args ...interface{}) error {
+	_, err := w.write("addr", []byte(fmt.Sprintf(format, args...)))
+	return err
+}
+
+// InsertAt inserts text into the window body before the rune at
+// offset q, which must be between 0 and the length of the body.
+func (w *Win) InsertAt(q int, text string) error {
+	if q < 0 {
+		return fmt.Errorf("acme: negative insert offset %d", q)
+	}
+	w.addrmu.Lock()
+	defer w.addrmu.Unlock()
+	if err := w.setAddr("#%d,#%d", q, q); err != nil {
+		return fmt.Errorf("acme: invalid insert offset %d: %v", q, err)
+	}
+	_, err := w.write("data", []byte(text))
+	return err
+}
+
+// DeleteRange deletes the runes from offset q0 up to (but not including)
+// offset q1 in the window body. It is an error if q0 > q1;
+// if q0 == q1 there is nothing to delete and DeleteRange returns nil.
+func (w *Win) DeleteRange(q0, q1 int) error {
+	if q0 < 0 || q0 > q1 {
+		return fmt.Errorf("acme: invalid delete range #%d,#%d", q0, q1)
+	}
+	if q0 == q1 {
+		return nil
+	}
+	w.addrmu.Lock()
+	defer w.addrmu.Unlock()
+	if err := w.setAddr("#%d,#%d", q0, q1); err != nil {
+		return fmt.Errorf("acme: invalid delete range #%d,#%d: %v", q0, q1, err)
+	}
+	_, err := w.write("data", nil)
+	return err
+}
+
+// Extract returns the text of the window body selected by the address
+// addr, such as "#10,#20" or "/func/". It writes addr to the addr file
+// and then reads the xdata file, which, unlike data, stops at the end
+// of the address rather than the end of the body. Concurrent calls to
+// Extract on w are serialized, but any other use of w's addr file,
+// in this program or another, can still change the address in between.
+func Extract(w *Win, addr string) ([]byte, error) {
+	w.addrmu.Lock()
+	defer w.addrmu.Unlock()
+	if err := w.setAddr("%s", addr); err != nil {
+		return nil, fmt.Errorf("acme: invalid address %q: %v", addr, err)
+	}
+	f, err := w.fid("xdata")
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	buf := make([]byte, 8192)
+	for {
+		n, err := f.Read(buf)
+		out = append(out, buf[:n]...)
+		if err == io.EOF || n == 0 && err == nil {
+			return out, nil
+		}
+		if err != nil {
+			return out, w.fail(err)
+		}
+	}
+}
+
+// Tag returns the text of the window's tag,
+// which begins with the window's name.
+func (w *Win) Tag() (string, error) {
+	tag, err := w.ReadAll("tag")
+	return string(tag), err
+}
+
+// ClearTag removes the text to the right of the bar in the window's
+// tag, such as commands added by other tools, leaving the name and
+// the commands acme itself puts there. A tag with no bar has nothing
+// to clear, and ClearTag returns nil without changing it.
+func (w *Win) ClearTag() error {
+	tag, err := w.Tag()
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(tag, "|") {
+		return nil
+	}
+	return w.Ctl("cleartag")
+}
+
+// tagName returns the file name at the start of the window's tag.
+func (w *Win) tagName() (string, error) {
+	tag, err := w.ReadAll("tag")
+	if err != nil {
+		return "", err
+	}
+	if f := strings.Fields(string(tag)); len(f) > 0 {
+		return f[0], nil
+	}
+	return "", nil
+}
+
+// ErrDirty is returned with a result that assumes a window holds
+// the contents of its file when the window has unsaved changes.
+var ErrDirty = errors.New("acme: window has unsaved changes")
+
+// DiskOffsetToAddr converts off, a byte offset in the file on disk
+// that w is editing, such as the position of a compiler error, to an
+// address for the window, "#q" where q is the corresponding rune offset.
+// The conversion reads the file up to off and so is exact only while
+// the window holds what is on disk; if the window has unsaved changes,
+// DiskOffsetToAddr returns the address together with ErrDirty.
+func DiskOffsetToAddr(w *Win, off int64) (string, error) {
+	if off < 0 {
+		return "", fmt.Errorf("acme: negative byte offset %d", off)
+	}
+	name, err := w.tagName()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	prefix, err := io.ReadAll(io.LimitReader(f, off))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(prefix)) < off {
+		return "", fmt.Errorf("acme: byte offset %d past end of %s", off, name)
+	}
+	addr := fmt.Sprintf("#%d", utf8.RuneCount(prefix))
+	info, err := w.Info()
+	if err != nil {
+		return "", err
+	}
+	if info.IsModified {
+		return addr, ErrDirty
+	}
+	return addr, nil
+}
+
+// ReadDataBytes reads up to n bytes of the window body starting at
+// byte offset off in the body's UTF-8 encoding, rather than at a rune
+// offset as the addr file requires. It returns fewer than n bytes only
+// at the end of the body.
+//
+// Acme stores text as runes, so the body holds valid UTF-8 even if the
+// bytes written to it did not; ReadDataBytes and WriteDataBytes avoid
+// rune arithmetic but cannot preserve invalid encodings. Mixing byte
+// offsets with the rune offsets used by Addr and the other methods is
+// the caller's responsibility.
+func (w *Win) ReadDataBytes(off int64, n int) ([]byte, error) {
+	if off < 0 || n < 0 {
+		return nil, fmt.Errorf("acme: invalid byte range %d+%d", off, n)
+	}
+	f, err := w.fid("body")
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	n, err = f.ReadAt(buf, off)
+	if err == io.EOF {
+		err = nil
+	}
+	return buf[:n], w.fail(err)
+}
+
+// WriteDataBytes replaces the len(b) bytes of the window body starting
+// at byte offset off with b, extending the body if b runs past its end.
+// Both off and off+len(b), if inside the body, must fall on rune
+// boundaries. See ReadDataBytes for the caveats of byte addressing.
+func (w *Win) WriteDataBytes(off int64, b []byte) error {
+	if off < 0 {
+		return fmt.Errorf("acme: negative byte offset %d", off)
+	}
+	end := off + int64(len(b))
+	w.addrmu.Lock()
+	defer w.addrmu.Unlock()
+	body, err := w.ReadDataBytes(0, int(end))
+	if err != nil {
+		return err
+	}
+	if off > int64(len(body)) {
+		return fmt.Errorf("acme: byte offset %d past end of body", off)
+	}
+	if end > int64(len(body)) {
+		end = int64(len(body))
+	}
+	for _, o := range []int64{off, end} {
+		if o < int64(len(body)) && !utf8.RuneStart(body[o]) {
+			return fmt.Errorf("acme: byte offset %d not on a rune boundary", o)
+		}
+	}
+	q0 := utf8.RuneCount(body[:off])
+	q1 := q0 + utf8.RuneCount(body[off:end])
+	if err := w.setAddr("#%d,#%d", q0, q1); err != nil {
+		return err
+	}
+	_, err = w.write("data", b)
+	return err
+}
+
+// ByteOffsetToChar converts byteOffset, an offset in the UTF-8
+// encoding of the window body such as a Unix tool reports, to the
+// rune offset that acme addresses use. It reads the body up to
+// byteOffset, which must not be past the end of the body.
+func (w *Win) ByteOffsetToChar(byteOffset int) (charOffset int, err error) {
+	if byteOffset < 0 {
+		return 0, fmt.Errorf("acme: negative byte offset %d", byteOffset)
+	}
+	prefix, err := w.ReadDataBytes(0, byteOffset)
+	if err != nil {
+		return 0, err
+	}
+	if len(prefix) < byteOffset {
+		return 0, fmt.Errorf("acme: byte offset %d past end of body", byteOffset)
+	}
+	return utf8.RuneCount(prefix), nil
+}
+
+// CharOffsetToBytes is the inverse of ByteOffsetToChar: it converts
+// charOffset, a rune offset in the window body, to the corresponding
+// offset in the body's UTF-8 encoding. It uses Extract, and so the
+// window's addr file, to read the first charOffset runes.
+func (w *Win) CharOffsetToBytes(charOffset int) (byteOffset int, err error) {
+	if charOffset < 0 {
+		return 0, fmt.Errorf("acme: negative rune offset %d", charOffset)
+	}
+	prefix, err := Extract(w, fmt.Sprintf("#0,#%d", charOffset))
+	if err != nil {
+		return 0, err
+	}
+	return len(prefix), nil
 }
 
 // ReadBody reads the complete body of the window.
 // A fresh fid is opened on each call so reading always starts at offset zero,
 // regardless of how much was read by any previous call.
 func (w *Win) ReadBody() ([]byte, error) {
+	if w.f != nil && !w.f.connected(w.fs) {
+		return nil, ErrReconnected
+	}
 	fid, err := w.fs.Open(fmt.Sprintf("%d/body", w.id), plan9.OREAD)
 	if err != nil {
-		return nil, err
+		return nil, w.fail(err)
 	}
 	defer fid.Close()
-	return io.ReadAll(fid)
+	data, err := io.ReadAll(fid)
+	return data, w.fail(err)
 }
 
 // Style writes data to the window's style file and closes it, triggering
@@ -328,16 +874,19 @@ func (w *Win) ReadBody() ([]byte, error) {
 // Unlike other file accessors, a fresh fid is opened on each call because
 // acme flushes the style only at close (clunk).
 func (w *Win) Style(data []byte) error {
+	if w.f != nil && !w.f.connected(w.fs) {
+		return ErrReconnected
+	}
 	fid, err := w.fs.Open(fmt.Sprintf("%d/style", w.id), plan9.OWRITE)
 	if err != nil {
-		return err
+		return w.fail(err)
 	}
 	defer fid.Close()
 	if len(data) == 0 {
 		return nil
 	}
 	_, err = fid.Write(data)
-	return err
+	return w.fail(err)
 }
 
 // A WinLogEvent is a single body-edit event read from a window's log file.
@@ -359,7 +908,10 @@ func (w *Win) ReadLog() (*WinLogEvent, error) {
 	}
 	line, err := w.elbuf.ReadString('\n')
 	if err != nil {
-		return nil, err
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, w.fail(err)
 	}
 	line = strings.TrimSuffix(line, "\n")
 	if len(line) < 5 {
@@ -444,6 +996,49 @@ func (w *Win) Del(sure bool) error {
 	return w.Ctl(cmd)
 }
 
+// OnDelete arranges for fn to be called, once, in a new goroutine,
+// when the window is deleted, so that resources tied to the window
+// can be released without polling. It watches the acme log for the
+// window's del event; if the window is already gone, or the log can
+// no longer be read because acme has exited, fn is called at once.
+func (w *Win) OnDelete(fn func()) error {
+	_, err := w.watchDelete(fn)
+	return err
+}
+
+// watchDelete is like OnDelete but also returns a function that stops
+// the watch, after which fn may be called at any time or not at all.
+func (w *Win) watchDelete(fn func()) (stop func(), err error) {
+	fid, err := w.fs.Open("log", plan9.OREAD)
+	if err != nil {
+		return nil, err
+	}
+	r := &LogReader{f: fid}
+	// Check for the window only once the log is open,
+	// so that a deletion in between is not missed.
+	if _, err := w.fs.Stat(fmt.Sprint(w.id)); err != nil {
+		r.Close()
+		go fn()
+		return func() {}, nil
+	}
+	var once sync.Once
+	stop = func() { once.Do(func() { r.Close() }) }
+	go func() {
+		defer stop()
+		for {
+			e, err := r.Read()
+			if err == errMalformedLog {
+				continue
+			}
+			if err != nil || e.ID == w.id && e.Op == LogDel {
+				fn()
+				return
+			}
+		}
+	}()
+	return stop, nil
+}
+
 // DeleteAll deletes all windows.
 func DeleteAll() {
 	for w := windows; w != nil; w = w.next {
@@ -483,16 +1078,33 @@ func (w *Win) fid(name string) (*client.Fid, error) {
 	default:
 		return nil, errors.New("unknown acme file: " + name)
 	}
+	if w.f != nil && !w.f.connected(w.fs) {
+		return nil, ErrReconnected
+	}
 	if *f == nil {
 		var err error
 		*f, err = w.fs.Open(fmt.Sprintf("%d/%s", w.id, name), mode)
 		if err != nil {
-			return nil, err
+			return nil, w.fail(err)
 		}
 	}
 	return *f, nil
 }
 
+// fail returns err, an error from an operation on one of w's files.
+// If err shows w's connection to have failed, and w's Fsys has
+// dropped it to re-dial, fail returns ErrReconnected instead.
+func (w *Win) fail(err error) error {
+	if err == nil || w.f == nil {
+		return err
+	}
+	w.f.check(w.fs, err)
+	if !w.f.connected(w.fs) {
+		return fmt.Errorf("%w: %v", ErrReconnected, err)
+	}
+	return err
+}
+
 // ReadAll
 func (w *Win) ReadAll(file string) ([]byte, error) {
 	f, err := w.fid(file)
@@ -500,7 +1112,8 @@ func (w *Win) ReadAll(file string) ([]byte, error) {
 		return nil, err
 	}
 	f.Seek(0, 0)
-	return ioutil.ReadAll(f)
+	data, err := ioutil.ReadAll(f)
+	return data, w.fail(err)
 }
 
 func (w *Win) ID() int {
@@ -516,14 +1129,22 @@ func (w *Win) Name(format string, args ...interface{}) error {
 	return nil
 }
 
-func (w *Win) Fprintf(file, format string, args ...interface{}) error {
-	f, err := w.fid(file)
-	if err != nil {
-		return err
+// RenameWindow changes the name of the window, shown at the start of
+// its tag, as for a scratch window whose contents have come to deserve
+// a better one. Acme treats the name as a file name, so it should be
+// a path, such as "/home/glenda/grep-results"; it cannot contain
+// a newline.
+func (w *Win) RenameWindow(name string) error {
+	if strings.Contains(name, "\n") {
+		return fmt.Errorf("acme: invalid window name %q", name)
 	}
+	return w.Name("%s", name)
+}
+
+func (w *Win) Fprintf(file, format string, args ...interface{}) error {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, format, args...)
-	_, err = f.Write(buf.Bytes())
+	_, err := w.Write(file, buf.Bytes())
 	return err
 }
 
@@ -532,10 +1153,21 @@ func (w *Win) Read(file string, b []byte) (n int, err error) {
 	if err != nil {
 		return 0, err
 	}
-	return f.Read(b)
+	n, err = f.Read(b)
+	if err == io.EOF {
+		return n, err
+	}
+	return n, w.fail(err)
 }
 
 func (w *Win) ReadAddr() (q0, q1 int, err error) {
+	w.addrmu.Lock()
+	defer w.addrmu.Unlock()
+	return w.readAddr()
+}
+
+// readAddr is ReadAddr for callers holding w.addrmu.
+func (w *Win) readAddr() (q0, q1 int, err error) {
 	f, err := w.fid("addr")
 	if err != nil {
 		return 0, 0, err
@@ -543,7 +1175,7 @@ func (w *Win) ReadAddr() (q0, q1 int, err error) {
 	buf := make([]byte, 40)
 	n, err := f.ReadAt(buf, 0)
 	if err != nil && err != io.EOF {
-		return 0, 0, err
+		return 0, 0, w.fail(err)
 	}
 	a := strings.Fields(string(buf[0:n]))
 	if len(a) < 2 {
@@ -557,6 +1189,33 @@ func (w *Win) ReadAddr() (q0, q1 int, err error) {
 	return q0, q1, nil
 }
 
+// SelectLine sets dot to line n of the window body, counting from 1.
+// It uses acme's line address "n", which covers the whole line from
+// just after the preceding newline through the newline that ends it,
+// or to the end of the body for a last line with no newline.
+// SelectLine returns an error if the body has fewer than n lines.
+func (w *Win) SelectLine(n int) error {
+	if n < 1 {
+		return fmt.Errorf("acme: invalid line number %d", n)
+	}
+	w.addrmu.Lock()
+	defer w.addrmu.Unlock()
+	if err := w.setAddr("%d", n); err != nil {
+		return fmt.Errorf("acme: no line %d: %v", n, err)
+	}
+	if err := w.Ctl("dot=addr"); err != nil {
+		return err
+	}
+	q0, q1, err := w.readAddr()
+	if err != nil {
+		return err
+	}
+	if q0 > q1 {
+		return fmt.Errorf("acme: invalid selection #%d,#%d for line %d", q0, q1, n)
+	}
+	return nil
+}
+
 func (w *Win) Info() (WinInfo, error) {
 	f, err := w.fid("ctl")
 	if err != nil {
@@ -565,7 +1224,7 @@ func (w *Win) Info() (WinInfo, error) {
 	buf := make([]byte, 8192)
 	n, err := f.ReadAt(buf, 0)
 	if err != nil && err != io.EOF {
-		return WinInfo{}, err
+		return WinInfo{}, w.fail(err)
 	}
 	line := string(buf[:n])
 	info := WinInfo{
@@ -583,23 +1242,60 @@ func (w *Win) Info() (WinInfo, error) {
 	); err != nil {
 		return WinInfo{}, fmt.Errorf("invalid ctl contents %q: %v", line, err)
 	}
+	w.info = &info
 	return info, nil
 }
 
+// String returns a short description of w for debugging.
+// It does not contact acme: the name and dirty state are those
+// recorded by the most recent calls to Name and Info,
+// and are omitted if those have not been called.
+func (w *Win) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Win{id=%d", w.id)
+	if w.name != "" {
+		fmt.Fprintf(&buf, ", name=%q", w.name)
+	}
+	if w.info != nil {
+		fmt.Fprintf(&buf, ", dirty=%v", w.info.IsModified)
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
 func (w *Win) Seek(file string, offset int64, whence int) (int64, error) {
 	f, err := w.fid(file)
 	if err != nil {
 		return 0, err
 	}
-	return f.Seek(offset, whence)
+	off, err := f.Seek(offset, whence)
+	return off, w.fail(err)
 }
 
 func (w *Win) Write(file string, b []byte) (n int, err error) {
+	switch file {
+	case "addr", "data", "xdata":
+		// See Addr.
+		w.addrmu.Lock()
+		defer w.addrmu.Unlock()
+	}
+	return w.write(file, b)
+}
+
+// write is Write for callers holding w.addrmu.
+func (w *Win) write(file string, b []byte) (n int, err error) {
 	f, err := w.fid(file)
 	if err != nil {
 		return 0, err
 	}
-	return f.Write(b)
+	n, err = f.Write(b)
+	if err != nil {
+		return n, w.fail(err)
+	}
+	if file == "data" || file == "body" {
+		w.syncReadOnly()
+	}
+	return n, nil
 }
 
 const eventSize = 256
@@ -644,7 +1340,13 @@ type Event struct {
 // ReadEvent reads the next event from the window's event file.
 func (w *Win) ReadEvent() (e *Event, err error) {
 	defer func() {
-		if v := recover(); v != nil {
+		switch v := recover().(type) {
+		case nil:
+		case error:
+			// A read error from getec.
+			e = nil
+			err = w.fail(fmt.Errorf("malformed acme event: %w", v))
+		default:
 			e = nil
 			err = errors.New("malformed acme event: " + v.(string))
 		}
@@ -681,9 +1383,84 @@ func (w *Win) ReadEvent() (e *Event, err error) {
 		e.Loc = e4.Text
 	}
 
+	if (e.C1 == 'K' || e.C1 == 'M') && (e.C2 == 'I' || e.C2 == 'D') {
+		w.revertUserEdit()
+	}
+
 	return e, nil
 }
 
+// SetReadOnly sets whether the window body is protected from the user,
+// so that a tool can stop the user editing a window while it works on it.
+//
+// Acme has no read-only mode, so SetReadOnly emulates one. It records
+// the body, and whenever ReadEvent reports the user typing into or
+// cutting from the body, it restores the recorded text using ApplyDiff.
+// Changes written through w itself update the recorded text.
+// Because ReadEvent does the restoring, the program must be reading the
+// window's events, with ReadEvent, EventChan or EventLoop, for the
+// protection to work; the user's edits are still reported as events.
+// An edit the user makes just as w writes to the body may be kept.
+func (w *Win) SetReadOnly(readonly bool) error {
+	var body []byte
+	if readonly {
+		var err error
+		if body, err = w.ReadBody(); err != nil {
+			return err
+		}
+	}
+	w.romu.Lock()
+	defer w.romu.Unlock()
+	w.readonly = readonly
+	w.robody = body
+	return nil
+}
+
+// syncReadOnly updates the recorded body of a read-only window
+// after w has written to it.
+func (w *Win) syncReadOnly() {
+	w.romu.Lock()
+	readonly := w.readonly
+	w.romu.Unlock()
+	if !readonly {
+		return
+	}
+	body, err := w.ReadBody()
+	if err != nil {
+		return
+	}
+	w.romu.Lock()
+	if w.readonly {
+		w.robody = body
+	}
+	w.romu.Unlock()
+}
+
+// revertUserEdit restores the recorded body of a read-only window.
+// It runs on the goroutine reading events, so it holds w.addrmu
+// to keep its edits from interleaving with the program's own,
+// and puts back the address it found.
+func (w *Win) revertUserEdit() {
+	w.romu.Lock()
+	readonly, body := w.readonly, w.robody
+	w.romu.Unlock()
+	if !readonly {
+		return
+	}
+	w.addrmu.Lock()
+	defer w.addrmu.Unlock()
+	q0, q1, err := w.readAddr()
+	if err == nil {
+		err = applyDiff(w, body)
+	}
+	if err == nil {
+		err = w.setAddr("#%d,#%d", q0, q1)
+	}
+	if err != nil {
+		log.Printf("acme: restoring read-only window %d: %v", w.id, err)
+	}
+}
+
 func (w *Win) gete(e *Event) {
 	if w.ebuf == nil {
 		w.ebuf = bufio.NewReader(w.event)
@@ -710,7 +1487,7 @@ func (w *Win) gete(e *Event) {
 func (w *Win) getec() rune {
 	c, _, err := w.ebuf.ReadRune()
 	if err != nil {
-		panic(err.Error())
+		panic(err)
 	}
 	return c
 }
@@ -735,10 +1512,73 @@ func (w *Win) geten() int {
 
 // WriteEvent writes an event back to the window's event file,
 // indicating to acme that the event should be handled internally.
+// If w's event file is not yet open, WriteEvent opens it for this one
+// write and closes it again, since acme sends the window's events to
+// whoever holds the file open rather than handling them itself.
 func (w *Win) WriteEvent(e *Event) error {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "%c%c%d %d \n", e.C1, e.C2, e.OrigQ0, e.OrigQ1)
-	_, err := w.Write("event", buf.Bytes())
+	if w.event != nil {
+		_, err := w.Write("event", buf.Bytes())
+		return err
+	}
+	if w.f != nil && !w.f.connected(w.fs) {
+		return ErrReconnected
+	}
+	fid, err := w.fs.Open(fmt.Sprintf("%d/event", w.id), plan9.OWRITE)
+	if err != nil {
+		return w.fail(err)
+	}
+	defer fid.Close()
+	_, err = fid.Write(buf.Bytes())
+	return w.fail(err)
+}
+
+// MiddleClick executes text as though the user had middle-clicked it
+// in the window's tag. A built-in command such as Newcol or Kill is run
+// by acme itself; other text is run as an external command, as acme
+// runs any text executed in the window.
+//
+// Acme executes only text it can find in the window, so MiddleClick
+// appends text to the tag, writes an execute event for it to the event
+// file, and then restores the part of the tag to the right of the bar.
+// If the tag has no bar, MiddleClick adds one first, as acme does when
+// it next updates the tag. The text cannot contain a newline.
+func (w *Win) MiddleClick(text string) error {
+	if text == "" || strings.Contains(text, "\n") {
+		return fmt.Errorf("acme: invalid command %q", text)
+	}
+	tag, err := w.Tag()
+	if err != nil {
+		return err
+	}
+	add := " " + text
+	if !strings.Contains(tag, "|") {
+		add = " |" + add
+	}
+	if _, err := w.Write("tag", []byte(add)); err != nil {
+		return err
+	}
+
+	// Acme may have rewritten the left of the tag meanwhile,
+	// so find the text from the end of the tag as it is now.
+	tag, err = w.Tag()
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(tag, add) {
+		return fmt.Errorf("acme: tag changed while executing %q", text)
+	}
+	q1 := utf8.RuneCountInString(tag)
+	q0 := q1 - utf8.RuneCountInString(text)
+	err = w.WriteEvent(&Event{C1: 'M', C2: 'x', OrigQ0: q0, OrigQ1: q1})
+
+	// The command may have deleted the window, as Del does,
+	// in which case there is no tag left to restore.
+	_, user, _ := strings.Cut(strings.TrimSuffix(tag, add), "|")
+	if w.Ctl("cleartag") == nil && user != "" {
+		w.Write("tag", []byte(user))
+	}
 	return err
 }
 
@@ -880,7 +1720,9 @@ func (w *Win) Blink() (stop func()) {
 // Sort sorts the lines in the current address range
 // according to the comparison function.
 func (w *Win) Sort(less func(x, y string) bool) error {
-	q0, q1, err := w.ReadAddr()
+	w.addrmu.Lock()
+	defer w.addrmu.Unlock()
+	q0, q1, err := w.readAddr()
 	if err != nil {
 		return err
 	}
@@ -895,8 +1737,8 @@ func (w *Win) Sort(less func(x, y string) bool) error {
 		lines = lines[:len(lines)-1]
 	}
 	sort.SliceStable(lines, func(i, j int) bool { return less(lines[i], lines[j]) })
-	w.Addr("#%d,#%d", q0, q1)
-	w.Write("data", []byte(strings.Join(lines, "\n")+suffix))
+	w.setAddr("#%d,#%d", q0, q1)
+	w.write("data", []byte(strings.Join(lines, "\n")+suffix))
 	return nil
 }
 
@@ -974,8 +1816,10 @@ func (w *Win) PrintTabbed(text string) {
 
 // Clear clears the window body.
 func (w *Win) Clear() {
-	w.Addr(",")
-	w.Write("data", nil)
+	w.addrmu.Lock()
+	defer w.addrmu.Unlock()
+	w.setAddr(",")
+	w.write("data", nil)
 }
 
 type EventHandler interface {
@@ -983,10 +1827,40 @@ type EventHandler interface {
 	Look(arg string) bool
 }
 
+// An EditHandler is an EventHandler that also wants to know about
+// changes to the window body. Acme reports every insertion and
+// deletion, whether typed by the user or made through the window's
+// files, while the event file is open; EventLoop passes those
+// reports to Insert and Delete only if the handler is an EditHandler.
+type EditHandler interface {
+	EventHandler
+
+	// Insert reports that text was inserted at q0, occupying
+	// the rune offsets q0 through q1.
+	Insert(q0, q1 int, text []byte)
+
+	// Delete reports that the runes from q0 to q1 were deleted.
+	Delete(q0, q1 int)
+}
+
+// loadText reads the text of e from the window body if acme omitted it.
+// Like revertUserEdit, it runs on the goroutine reading events,
+// so it puts back the address it found.
 func (w *Win) loadText(e *Event, h EventHandler) {
 	if len(e.Text) == 0 && e.Q0 < e.Q1 {
-		w.Addr("#%d,#%d", e.Q0, e.Q1)
-		data, err := w.ReadAll("xdata")
+		w.addrmu.Lock()
+		var data []byte
+		q0, q1, err := w.readAddr()
+		if err == nil {
+			err = w.setAddr("#%d,#%d", e.Q0, e.Q1)
+		}
+		if err == nil {
+			data, err = w.ReadAll("xdata")
+		}
+		if err == nil {
+			err = w.setAddr("#%d,#%d", q0, q1)
+		}
+		w.addrmu.Unlock()
 		if err != nil {
 			w.Err(err.Error())
 		}
@@ -1008,8 +1882,50 @@ func (w *Win) EventLoop(h EventHandler) {
 			if !h.Look(string(e.Text)) {
 				w.WriteEvent(e)
 			}
+		case 'I': // body insert
+			if eh, ok := h.(EditHandler); ok {
+				// Acme omits the text of long insertions.
+				w.loadText(e, h)
+				eh.Insert(e.Q0, e.Q1, e.Text)
+			}
+		case 'D': // body delete
+			if eh, ok := h.(EditHandler); ok {
+				eh.Delete(e.Q0, e.Q1)
+			}
+		}
+	}
+}
+
+// Menu adds items to the tag of w as words the user can execute
+// with the middle button, and calls onSelect with the item each time
+// one of them is executed. Each item must be a single word (containing
+// no spaces) and must not repeat an earlier item, so that an execute
+// event can be matched to its item by its first word.
+//
+// Menu is a CommandTable with a function for each item that calls
+// onSelect, made all at once so that an error writing the tag can be
+// returned. Like Commands, it reads the window's events itself, in a
+// new goroutine, until the window is deleted; events other than menu
+// selections are handed back to acme. It must not be combined with
+// EventLoop, EventChan, ReadEvent or Commands.
+func Menu(w *Win, items []string, onSelect func(string)) error {
+	t := newCommandTable(w)
+	for _, item := range items {
+		if item == "" || strings.ContainsAny(item, " \t\n") {
+			return fmt.Errorf("acme: invalid menu item %q", item)
+		}
+		if t.cmds[item] != nil {
+			return fmt.Errorf("acme: duplicate menu item %q", item)
 		}
+		item := item
+		t.names = append(t.names, item)
+		t.cmds[item] = func(string) { onSelect(item) }
 	}
+	if err := t.setTag(); err != nil {
+		return err
+	}
+	go t.serve(w.EventChan())
+	return nil
 }
 
 func (w *Win) execute(h EventHandler, cmd string) bool {
@@ -1077,8 +1993,10 @@ func (w *Win) execute(h EventHandler, cmd string) bool {
 }
 
 func (w *Win) Selection() string {
+	w.addrmu.Lock()
 	w.Ctl("addr=dot")
 	data, err := w.ReadAll("xdata")
+	w.addrmu.Unlock()
 	if err != nil {
 		w.Err(err.Error())
 	}
@@ -2,22 +2,46 @@
 
 package acme
 
-import "9fans.net/go/plan9/client"
+import (
+	"time"
+
+	"9fans.net/go/plan9/client"
+)
 
 // mountAcme is called once by defaultOnce to set up the default Fsys.
+// If the dial fails, the Fsys is left without a connection,
+// for SetReconnect to dial again.
 func mountAcme() {
 	fs, err := client.MountService("acme")
-	if err != nil {
-		defaultErr = err
-		return
-	}
 	defaultFsys = &Fsys{fs: fs}
+	defaultErr = err
+}
+
+// SetReconnect sets whether the default connection to acme, used by
+// the package-level functions such as New and Windows, re-dials acme
+// when it fails, as it does when acme is restarted. It dials the
+// default connection if that has not been done yet.
+//
+// With reconnection on, the call that finds the connection broken
+// re-dials the "acme" service, retrying once after a short wait,
+// before returning its error; if that fails too, the next call
+// tries again. A window opened before the connection failed cannot
+// be recovered: its operations return errors matching ErrReconnected,
+// and a program should open it again or create a new one.
+// A failed first dial is retried the same way.
+func SetReconnect(on bool) {
+	defaultOnce.Do(mountAcme)
+	var redial func() (*client.Fsys, error)
+	if on {
+		redial = Reconnect{MaxRetries: 1, Backoff: 100 * time.Millisecond}.dialer()
+	}
+	defaultFsys.setReconnect(redial)
 }
 
 // Mount opens a fresh connection to acme and returns it as an Fsys.
-// Each call dials independently; retry with your own backoff on failure.
-// Use the package-level functions for simple tools that do not need
-// reconnect semantics.
+// Each call dials independently; retry with your own backoff on failure,
+// or use MountReconnect. The package-level functions use a default
+// connection that re-dials only after SetReconnect(true).
 func Mount() (*Fsys, error) {
 	fs, err := client.MountService("acme")
 	if err != nil {
@@ -25,3 +49,53 @@ func Mount() (*Fsys, error) {
 	}
 	return &Fsys{fs: fs}, nil
 }
+
+// A Reconnect says how an Fsys returned by MountReconnect
+// dials acme, first and after the connection fails.
+type Reconnect struct {
+	// Dial connects to acme. If nil, MountReconnect dials
+	// the "acme" service, as Mount does.
+	Dial func() (*client.Fsys, error)
+
+	// MaxRetries is how many more times to call Dial
+	// after a failed call, before giving up.
+	MaxRetries int
+
+	// Backoff is how long to wait before the first retry.
+	// The wait doubles before each retry after that.
+	Backoff time.Duration
+}
+
+// MountReconnect is like Mount but returns an Fsys that re-dials acme,
+// as r says, when its connection fails, as it does when acme or the
+// 9P proxy in front of it is restarted. The call that finds the
+// connection broken returns the error; the next call re-dials.
+// Windows opened on the old connection stay broken: their fids belong
+// to it, so a program should open them again through the Fsys.
+func MountReconnect(r Reconnect) (*Fsys, error) {
+	redial := r.dialer()
+	fs, err := redial()
+	if err != nil {
+		return nil, err
+	}
+	return &Fsys{fs: fs, redial: redial}, nil
+}
+
+// dialer returns a function that dials acme as r says.
+func (r Reconnect) dialer() func() (*client.Fsys, error) {
+	dial := r.Dial
+	if dial == nil {
+		dial = func() (*client.Fsys, error) { return client.MountService("acme") }
+	}
+	return func() (*client.Fsys, error) {
+		wait := r.Backoff
+		for i := 0; ; i++ {
+			fs, err := dial()
+			if err == nil || i >= r.MaxRetries {
+				return fs, err
+			}
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+}
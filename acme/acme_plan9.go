@@ -15,3 +15,7 @@ func Mount() (*Fsys, error) {
 	defaultOnce.Do(mountAcme)
 	return defaultFsys, nil
 }
+
+// SetReconnect has no effect on Plan 9,
+// where the connection to acme never breaks.
+func SetReconnect(on bool) {}
@@ -0,0 +1,30 @@
+package acme
+
+import "testing"
+
+func TestParseLogEvent(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want LogEvent
+	}{
+		{"1 new /tmp/x.go\n", LogEvent{ID: 1, Op: LogNew, RawOp: "new", Name: "/tmp/x.go"}},
+		{"2 zerox /tmp/x.go\n", LogEvent{ID: 2, Op: LogZerox, RawOp: "zerox", Name: "/tmp/x.go"}},
+		{"3 get /tmp/y.c\n", LogEvent{ID: 3, Op: LogGet, RawOp: "get", Name: "/tmp/y.c"}},
+		{"4 put /tmp/a file.txt\n", LogEvent{ID: 4, Op: LogPut, RawOp: "put", Name: "/tmp/a file.txt"}},
+		{"5 del /tmp/x.go\n", LogEvent{ID: 5, Op: LogDel, RawOp: "del", Name: "/tmp/x.go"}},
+		{"6 focus \n", LogEvent{ID: 6, Op: LogFocus, RawOp: "focus", Name: ""}},
+		{"7 frob /tmp/x.go\n", LogEvent{ID: 7, Op: LogUnknown, RawOp: "frob", Name: "/tmp/x.go"}},
+		{"8 unknown x\n", LogEvent{ID: 8, Op: LogUnknown, RawOp: "unknown", Name: "x"}},
+	} {
+		got, err := parseLogEvent(tt.line)
+		if err != nil || got != tt.want {
+			t.Errorf("parseLogEvent(%q) = %+v, %v, want %+v", tt.line, got, err, tt.want)
+		}
+	}
+	if _, err := parseLogEvent("garbage"); err == nil {
+		t.Errorf("parseLogEvent(garbage) succeeded")
+	}
+	if s := LogPut.String(); s != "put" {
+		t.Errorf("LogPut.String() = %q, want put", s)
+	}
+}
@@ -69,7 +69,7 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
-		if event.Name == "" || event.Op != "put" {
+		if event.Name == "" || event.Op != acme.LogPut {
 			continue
 		}
 		for suffix, formatter := range formatters {
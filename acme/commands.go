@@ -0,0 +1,157 @@
+package acme
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// A CommandTable maps words in a window's tag to functions,
+// so that a tool can offer its own commands to be executed with the
+// middle button. Create one with Commands.
+type CommandTable struct {
+	w *Win
+
+	mu     sync.Mutex
+	names  []string // in the order registered, as shown in the tag
+	cmds   map[string]func(arg string)
+	user   string // the tag to the right of the bar when the table was made
+	closed bool
+}
+
+// Commands returns a new, empty command table for w and starts
+// reading w's events in a new goroutine, until the window is deleted.
+// Executing a registered word, with or without arguments, calls its
+// function with the rest of the text as arg; all other executes
+// and looks are handed back to acme. Commands must not be combined
+// with EventLoop, EventChan, ReadEvent or Menu.
+//
+// The table rewrites the part of the tag to the right of the bar each
+// time a command is added or removed, so any text the user has typed
+// there since the table was made is lost.
+func Commands(w *Win) *CommandTable {
+	t := newCommandTable(w)
+	go t.serve(w.EventChan())
+	return t
+}
+
+// newCommandTable returns an empty table for w without reading events.
+func newCommandTable(w *Win) *CommandTable {
+	t := &CommandTable{w: w, cmds: make(map[string]func(string))}
+	if tag, err := w.ReadAll("tag"); err == nil {
+		if _, user, ok := strings.Cut(string(tag), "|"); ok {
+			t.user = strings.TrimSpace(user)
+		}
+	}
+	return t
+}
+
+// serve calls the functions for the commands executed in events from c,
+// handing the other executes and looks back to acme.
+func (t *CommandTable) serve(c <-chan *Event) {
+	for e := range c {
+		switch e.C2 {
+		case 'x', 'X':
+			if fn, arg := t.lookup(strings.TrimSpace(string(e.Text))); fn != nil {
+				fn(arg)
+				continue
+			}
+			t.w.WriteEvent(e)
+		case 'l', 'L':
+			t.w.WriteEvent(e)
+		}
+	}
+}
+
+// Register adds name to the tag and arranges for fn to be called when
+// it is executed, replacing any function already registered for name.
+// The name must be a single word. Register returns t, so that calls
+// can be chained.
+func (t *CommandTable) Register(name string, fn func(arg string)) *CommandTable {
+	if name == "" || strings.ContainsAny(name, " \t\n") {
+		panic(fmt.Sprintf("acme: invalid command name %q", name))
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return t
+	}
+	if _, ok := t.cmds[name]; !ok {
+		t.names = append(t.names, name)
+	}
+	t.cmds[name] = fn
+	t.writeTag()
+	return t
+}
+
+// Remove removes name from the tag and from t, if it is there.
+// It returns t, so that calls can be chained.
+func (t *CommandTable) Remove(name string) *CommandTable {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.cmds[name]; !ok || t.closed {
+		return t
+	}
+	delete(t.cmds, name)
+	for i, n := range t.names {
+		if n == name {
+			t.names = append(t.names[:i], t.names[i+1:]...)
+			break
+		}
+	}
+	t.writeTag()
+	return t
+}
+
+// Close removes the table's commands, restoring the tag to what it
+// was when the table was made. From then on all events are handed
+// back to acme.
+func (t *CommandTable) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	t.names = nil
+	t.cmds = nil
+	return t.setTag()
+}
+
+// lookup returns the function registered for the first word of cmd
+// and the rest of cmd, or nil if there is none.
+func (t *CommandTable) lookup(cmd string) (fn func(string), arg string) {
+	verb, arg := cmd, ""
+	if i := strings.IndexAny(verb, " \t"); i >= 0 {
+		verb, arg = verb[:i], strings.TrimSpace(verb[i+1:])
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cmds[verb], arg
+}
+
+// writeTag is setTag for Register and Remove, which have no error
+// result to report a failure with. t.mu must be held.
+func (t *CommandTable) writeTag() {
+	if err := t.setTag(); err != nil {
+		log.Printf("acme: updating tag: %v", err)
+	}
+}
+
+// setTag replaces the tag to the right of the bar with the original
+// text and the registered names. t.mu must be held.
+func (t *CommandTable) setTag() error {
+	if err := t.w.Ctl("cleartag"); err != nil {
+		return err
+	}
+	words := t.names
+	if t.user != "" {
+		words = append([]string{t.user}, words...)
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	_, err := t.w.Write("tag", []byte(" "+strings.Join(words, " ")))
+	return err
+}
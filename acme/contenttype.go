@@ -0,0 +1,63 @@
+package acme
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+var contentTypes = struct {
+	sync.RWMutex
+	m map[string]string
+}{
+	m: map[string]string{
+		".c":    "text/x-c",
+		".h":    "text/x-c",
+		".cc":   "text/x-c++",
+		".cpp":  "text/x-c++",
+		".hh":   "text/x-c++",
+		".go":   "text/x-go",
+		".html": "text/html",
+		".js":   "text/javascript",
+		".json": "application/json",
+		".md":   "text/markdown",
+		".py":   "text/x-python",
+		".rc":   "text/x-rc",
+		".rs":   "text/x-rust",
+		".s":    "text/x-asm",
+		".sh":   "text/x-shellscript",
+		".txt":  "text/plain",
+		".xml":  "application/xml",
+		".y":    "text/x-yacc",
+	},
+}
+
+// RegisterContentType arranges for Win.ContentType to report mime
+// for windows whose file name has the extension ext, such as ".go".
+// The leading dot may be omitted, and extensions match regardless of case.
+// A later registration for the same extension replaces an earlier one.
+func RegisterContentType(ext, mime string) {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	contentTypes.Lock()
+	defer contentTypes.Unlock()
+	contentTypes.m[ext] = mime
+}
+
+// ContentType guesses the MIME type of the window's contents from the
+// extension of the file name at the start of its tag, returning
+// "text/plain" for extensions not registered with RegisterContentType.
+func (w *Win) ContentType() (string, error) {
+	name, err := w.tagName()
+	if err != nil {
+		return "", err
+	}
+	contentTypes.RLock()
+	defer contentTypes.RUnlock()
+	if mime, ok := contentTypes.m[strings.ToLower(path.Ext(name))]; ok {
+		return mime, nil
+	}
+	return "text/plain", nil
+}
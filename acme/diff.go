@@ -0,0 +1,160 @@
+package acme
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// ApplyDiff replaces the body of w with newBody, rewriting only the
+// lines that differ from the current body.
+// Unchanged regions are left alone, so acme preserves the scroll
+// position and dot in them, which makes incremental updates
+// much smoother than rewriting the whole body.
+//
+// The edits are computed against the body as read at the start of the call.
+// If the body is modified concurrently, the result is undefined;
+// other edits through w wait for ApplyDiff, as described at Addr.
+func ApplyDiff(w *Win, newBody []byte) error {
+	w.addrmu.Lock()
+	defer w.addrmu.Unlock()
+	return applyDiff(w, newBody)
+}
+
+// applyDiff is ApplyDiff for callers holding w.addrmu.
+func applyDiff(w *Win, newBody []byte) error {
+	old, err := w.ReadBody()
+	if err != nil {
+		return err
+	}
+	a := splitLines(string(old))
+	b := splitLines(string(newBody))
+
+	// q[i] is the rune offset of the start of line i in the old body.
+	q := make([]int, len(a)+1)
+	for i, line := range a {
+		q[i+1] = q[i] + utf8.RuneCountInString(line)
+	}
+
+	// Apply the edits last to first, so that the rune offsets
+	// of the earlier ones remain valid.
+	edits := diffLines(a, b)
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		if err := w.setAddr("#%d,#%d", q[e.a0], q[e.a1]); err != nil {
+			return err
+		}
+		if _, err := w.write("data", []byte(strings.Join(b[e.b0:e.b1], ""))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitLines splits s into lines, each including its trailing newline.
+// The final line has no newline if s does not end in one.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// A lineEdit says that the lines a[a0:a1] should be replaced by b[b0:b1].
+type lineEdit struct {
+	a0, a1 int
+	b0, b1 int
+}
+
+// diffLines returns the edits, in increasing order, that turn a into b.
+// It uses Myers' O(ND) algorithm after trimming any common prefix and suffix.
+func diffLines(a, b []string) []lineEdit {
+	p := 0
+	for p < len(a) && p < len(b) && a[p] == b[p] {
+		p++
+	}
+	s := 0
+	for s < len(a)-p && s < len(b)-p && a[len(a)-1-s] == b[len(b)-1-s] {
+		s++
+	}
+
+	var edits []lineEdit
+	x0, y0 := 0, 0
+	add := func(x, y int) {
+		if x > x0 || y > y0 {
+			edits = append(edits, lineEdit{p + x0, p + x, p + y0, p + y})
+		}
+	}
+	ma, mb := a[p:len(a)-s], b[p:len(b)-s]
+	for _, m := range myers(ma, mb) {
+		add(m[0], m[1])
+		x0, y0 = m[0]+1, m[1]+1
+	}
+	add(len(ma), len(mb))
+	return edits
+}
+
+// myers returns the matching line pairs (i, j), with a[i] == b[j],
+// in a shortest edit script turning a into b.
+func myers(a, b []string) [][2]int {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return nil
+	}
+
+	// v[off+k] is the furthest x reached on diagonal k = x-y.
+	// trace[d] holds v[off-d-1 : off+d+2] as it was before step d,
+	// which is all that the backtracking needs.
+	dmax := n + m
+	off := dmax + 1
+	v := make([]int, 2*dmax+3)
+	var trace [][]int
+	for d := 0; d <= dmax; d++ {
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[off+k-1] < v[off+k+1] {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, n, m)
+			}
+		}
+	}
+	panic("unreachable")
+}
+
+func backtrack(trace [][]int, x, y int) [][2]int {
+	var match [][2]int
+	for d := len(trace) - 1; d >= 0; d-- {
+		// trace[d][k+d+1] holds v[k] (see myers).
+		v := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		var pk int
+		if k == -d || k != d && v(k-1) < v(k+1) {
+			pk = k + 1
+		} else {
+			pk = k - 1
+		}
+		px := v(pk)
+		py := px - pk
+		for x > px && y > py {
+			x--
+			y--
+			match = append(match, [2]int{x, y})
+		}
+		x, y = px, py
+	}
+	for i, j := 0, len(match)-1; i < j; i, j = i+1, j-1 {
+		match[i], match[j] = match[j], match[i]
+	}
+	return match
+}
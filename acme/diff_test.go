@@ -0,0 +1,48 @@
+//go:build !plan9
+// +build !plan9
+
+package acme
+
+import (
+	"reflect"
+	"testing"
+)
+
+var diffTests = []struct {
+	name  string
+	a, b  string
+	edits []lineEdit
+}{
+	{"same", "a\nb\n", "a\nb\n", nil},
+	{"empty to non-empty", "", "a\nb\n", []lineEdit{{0, 0, 0, 2}}},
+	{"non-empty to empty", "a\nb\n", "", []lineEdit{{0, 2, 0, 0}}},
+	{"insert", "a\nc\n", "a\nb\nc\n", []lineEdit{{1, 1, 1, 2}}},
+	{"delete", "a\nb\nc\n", "a\nc\n", []lineEdit{{1, 2, 1, 1}}},
+	{"interleaved", "a\nb\nc\nd\ne\n", "a\nB\nc\ne\nf\n", []lineEdit{{1, 2, 1, 2}, {3, 4, 3, 3}, {5, 5, 4, 5}}},
+	{"add final newline", "a\nb", "a\nb\n", []lineEdit{{1, 2, 1, 2}}},
+	{"remove final newline", "a\nb\n", "a\nc", []lineEdit{{1, 2, 1, 2}}},
+	{"runes", "α\nβ\nδ", "α\nγ\nβ\nε", []lineEdit{{1, 1, 1, 2}, {2, 3, 3, 4}}},
+}
+
+func TestDiffLines(t *testing.T) {
+	for _, tt := range diffTests {
+		edits := diffLines(splitLines(tt.a), splitLines(tt.b))
+		if !reflect.DeepEqual(edits, tt.edits) {
+			t.Errorf("%s: diffLines(%q, %q) = %v, want %v", tt.name, tt.a, tt.b, edits, tt.edits)
+		}
+	}
+}
+
+func TestApplyDiff(t *testing.T) {
+	for _, tt := range diffTests {
+		fw := newFakeWin(tt.a, "")
+		w := openFakeWin(t, fw)
+		if err := ApplyDiff(w, []byte(tt.b)); err != nil {
+			t.Errorf("%s: ApplyDiff: %v", tt.name, err)
+			continue
+		}
+		if body := fw.Body(); body != tt.b {
+			t.Errorf("%s: ApplyDiff from %q made %q, want %q", tt.name, tt.a, body, tt.b)
+		}
+	}
+}
@@ -60,7 +60,7 @@ func main() {
 		if err != nil {
 			log.Fatalf("reading acme log: %v", err)
 		}
-		if ev.Op == "del" && ev.Name == file {
+		if ev.Op == acme.LogDel && ev.Name == file {
 			break
 		}
 	}
@@ -0,0 +1,319 @@
+//go:build !plan9
+// +build !plan9
+
+package acme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+	"9fans.net/go/plan9/srv9p"
+)
+
+// indexLine is the fake acme's index file: one window, with id 1.
+const indexLine = "          1          30           0           0           0 /tmp/x Del Snarf | Look \n"
+
+// A fakeWin is the state of window 1 in a fake acme.
+// Its files act enough like acme's for the tests:
+// addr takes #q0,#q1 and #q0 addresses and the whole body ",";
+// data and xdata replace and read the addressed text;
+// body and tag append on write; ctl records each write, reads
+// as the index does and takes cleartag; reads of event return
+// the strings sent on events, one per read; and writes of
+// Mx events to event record the text of the tag they execute.
+type fakeWin struct {
+	mu     sync.Mutex
+	body   []rune
+	q0, q1 int
+	tag    string
+	ctl    []string
+	events chan string
+
+	executed []string // text of the tag executed by writes to event
+}
+
+func newFakeWin(body, tag string) *fakeWin {
+	return &fakeWin{body: []rune(body), tag: tag, events: make(chan string, 10)}
+}
+
+// Body returns the window body.
+func (fw *fakeWin) Body() string {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return string(fw.body)
+}
+
+// Ctl returns the messages written to the ctl file.
+func (fw *fakeWin) Ctl() []string {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return append([]string(nil), fw.ctl...)
+}
+
+// Tag returns the window tag.
+func (fw *fakeWin) Tag() string {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return fw.tag
+}
+
+// Executed returns the text of the tag executed through the event file.
+func (fw *fakeWin) Executed() []string {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return append([]string(nil), fw.executed...)
+}
+
+// Type inserts text at q as though the user had typed it,
+// leaving the address alone, as acme does, and queues the event.
+func (fw *fakeWin) Type(q int, text string) {
+	fw.mu.Lock()
+	r := []rune(text)
+	fw.body = append(fw.body[:q], append(r, fw.body[q:]...)...)
+	fw.mu.Unlock()
+	fw.events <- fmt.Sprintf("KI%d %d 0 %d %s\n", q, q+len(r), len(r), text)
+}
+
+func (fw *fakeWin) read(ctx context.Context, fid *srv9p.Fid, data []byte, offset int64) (int, error) {
+	name := fid.File().Stat.Name
+	if name == "event" {
+		select {
+		case e := <-fw.events:
+			return copy(data, e), nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	var s string
+	switch name {
+	case "index", "ctl":
+		s = indexLine
+	case "addr":
+		s = fmt.Sprintf("%11d %11d ", fw.q0, fw.q1)
+	case "body":
+		s = string(fw.body)
+	case "data":
+		s = string(fw.body[fw.q0:])
+	case "xdata":
+		s = string(fw.body[fw.q0:fw.q1])
+	case "tag":
+		s = fw.tag
+	}
+	return fid.ReadString(data, offset, s)
+}
+
+func (fw *fakeWin) write(ctx context.Context, fid *srv9p.Fid, data []byte, offset int64) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	s := string(data)
+	switch fid.File().Stat.Name {
+	case "ctl":
+		fw.ctl = append(fw.ctl, s)
+		if s == "cleartag\n" {
+			if i := strings.Index(fw.tag, "|"); i >= 0 {
+				fw.tag = fw.tag[:i+1]
+			}
+		}
+	case "event":
+		var q0, q1 int
+		if _, err := fmt.Sscanf(s, "Mx%d %d", &q0, &q1); err != nil {
+			return 0, errors.New("bad event syntax")
+		}
+		tag := []rune(fw.tag)
+		if q0 < 0 || q0 > q1 || q1 > len(tag) {
+			return 0, errors.New("event address out of range")
+		}
+		fw.executed = append(fw.executed, string(tag[q0:q1]))
+	case "addr":
+		q0, q1 := 0, len(fw.body)
+		if s != "," {
+			if n, _ := fmt.Sscanf(s, "#%d,#%d", &q0, &q1); n == 1 {
+				q1 = q0
+			} else if n != 2 {
+				return 0, errors.New("bad address syntax")
+			}
+		}
+		if q0 < 0 || q0 > q1 || q1 > len(fw.body) {
+			return 0, errors.New("address out of range")
+		}
+		fw.q0, fw.q1 = q0, q1
+	case "data", "xdata":
+		r := []rune(s)
+		fw.body = append(fw.body[:fw.q0], append(r, fw.body[fw.q1:]...)...)
+		fw.q0 += len(r)
+		fw.q1 = fw.q0
+	case "body":
+		fw.body = append(fw.body, []rune(s)...)
+	case "tag":
+		fw.tag += s
+	}
+	return len(data), nil
+}
+
+// fakeAcme returns a dial function for MountReconnect that fails
+// the first fails times and then connects to a new fake acme serving
+// an index file and the files of window 1, whose state is win, or
+// an empty window if win is nil. It returns a function that breaks
+// the most recent connection, and the number of calls to dial.
+func fakeAcme(t *testing.T, fails int, win *fakeWin) (dial func() (*client.Fsys, error), hangup func(), ndial *int) {
+	if win == nil {
+		win = newFakeWin("", "")
+	}
+	var last net.Conn
+	ndial = new(int)
+	dial = func() (*client.Fsys, error) {
+		*ndial++
+		if *ndial <= fails {
+			return nil, errors.New("connection refused")
+		}
+		tree := srv9p.NewTree("acme", "acme", plan9.DMDIR|0555, nil)
+		if _, err := tree.Root.Create("index", "acme", 0444, nil); err != nil {
+			return nil, err
+		}
+		dir, err := tree.Root.Create("1", "acme", plan9.DMDIR|0555, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range []string{"addr", "body", "ctl", "data", "event", "tag", "xdata"} {
+			if _, err := dir.Create(name, "acme", 0666, nil); err != nil {
+				return nil, err
+			}
+		}
+		srv := &srv9p.Server{
+			Tree:  tree,
+			Read:  win.read,
+			Write: win.write,
+		}
+		c1, c2 := net.Pipe()
+		t.Cleanup(func() { c1.Close(); c2.Close() })
+		go srv.Serve(c2, c2)
+		conn, err := client.NewConn(c1)
+		if err != nil {
+			return nil, err
+		}
+		last = c2
+		return conn.Attach(nil, "glenda", "")
+	}
+	return dial, func() { last.Close() }, ndial
+}
+
+// openFakeWin opens window 1 of a fake acme with state fw.
+func openFakeWin(t *testing.T, fw *fakeWin) *Win {
+	dial, _, _ := fakeAcme(t, 0, fw)
+	f := new(Fsys)
+	f.setReconnect(dial)
+	w, err := f.Open(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.drop)
+	return w
+}
+
+func TestMountReconnect(t *testing.T) {
+	dial, hangup, ndial := fakeAcme(t, 2, nil)
+	fs, err := MountReconnect(Reconnect{Dial: dial, MaxRetries: 2, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *ndial != 3 {
+		t.Errorf("MountReconnect dialed %d times, want 3", *ndial)
+	}
+	if w, err := fs.Windows(); err != nil || len(w) != 1 || w[0].ID != 1 {
+		t.Fatalf("Windows = %+v, %v, want window 1", w, err)
+	}
+
+	// The call that finds the connection gone fails;
+	// the next one re-dials.
+	hangup()
+	if _, err := fs.Windows(); err == nil {
+		t.Fatal("Windows succeeded after hangup")
+	}
+	if w, err := fs.Windows(); err != nil || len(w) != 1 {
+		t.Fatalf("Windows after re-dial = %+v, %v, want window 1", w, err)
+	}
+	if *ndial != 4 {
+		t.Errorf("dialed %d times in all, want 4", *ndial)
+	}
+
+	// A 9P error leaves the connection alone.
+	if _, err := fs.Open(99, nil); err == nil {
+		t.Fatal("Open(99) succeeded")
+	}
+	if _, err := fs.Windows(); err != nil || *ndial != 4 {
+		t.Errorf("after a 9P error: Windows = %v, dialed %d times, want nil, 4", err, *ndial)
+	}
+}
+
+func TestMountReconnectGivesUp(t *testing.T) {
+	dial, _, ndial := fakeAcme(t, 5, nil)
+	if _, err := MountReconnect(Reconnect{Dial: dial, MaxRetries: 2}); err == nil {
+		t.Fatal("MountReconnect succeeded")
+	}
+	if *ndial != 3 {
+		t.Errorf("MountReconnect dialed %d times, want 3", *ndial)
+	}
+}
+
+func TestSetReconnect(t *testing.T) {
+	dial, hangup, ndial := fakeAcme(t, 0, nil)
+	f := new(Fsys)
+	f.setReconnect(dial)
+	w, err := f.Open(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.drop()
+	if _, err := w.ReadAll("ctl"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The call that finds the connection gone re-dials before failing,
+	// and the window opened on the old connection stays unusable.
+	hangup()
+	if _, err := w.ReadAll("ctl"); !errors.Is(err, ErrReconnected) {
+		t.Errorf("ReadAll after hangup: %v, want ErrReconnected", err)
+	}
+	if *ndial != 2 {
+		t.Errorf("dialed %d times, want 2", *ndial)
+	}
+	if _, _, err := w.ReadAddr(); !errors.Is(err, ErrReconnected) {
+		t.Errorf("ReadAddr after reconnect: %v, want ErrReconnected", err)
+	}
+
+	// New calls use the new connection.
+	if infos, err := f.Windows(); err != nil || len(infos) != 1 {
+		t.Fatalf("Windows after reconnect = %+v, %v, want window 1", infos, err)
+	}
+	w2, err := f.Open(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.drop()
+	if data, err := w2.ReadAll("ctl"); string(data) != indexLine || err != nil {
+		t.Errorf("ReadAll on reopened window = %q, %v, want %q", data, err, indexLine)
+	}
+	if *ndial != 2 {
+		t.Errorf("dialed %d times in all, want 2", *ndial)
+	}
+
+	// Once turned off, a failed connection stays failed.
+	f.setReconnect(nil)
+	hangup()
+	if _, err := w2.ReadAll("ctl"); err == nil || errors.Is(err, ErrReconnected) {
+		t.Errorf("ReadAll after hangup without reconnect: %v, want a plain error", err)
+	}
+	if *ndial != 2 {
+		t.Errorf("dialed %d times after reconnect was turned off, want 2", *ndial)
+	}
+}
@@ -0,0 +1,125 @@
+package acme
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// Run runs the command cmd with the given arguments, streaming its
+// standard output and standard error a line at a time into the body of
+// the window with the given name, which is cleared first. It reuses a
+// window of that name created by this process, as Show finds, or else
+// creates one. Each line is appended at the end of the body and the
+// window scrolled to show it.
+//
+// Run returns once the command has exited, with the error from
+// exec.Cmd.Wait, which is an *exec.ExitError for a failed command.
+// If the window is deleted while the command runs, the command is killed.
+func Run(name, cmd string, args ...string) error {
+	w := Show(name)
+	if w == nil {
+		var err error
+		w, err = New()
+		if err != nil {
+			return err
+		}
+		if err := w.Name("%s", name); err != nil {
+			w.CloseFiles()
+			return err
+		}
+	}
+	w.Clear()
+	return w.run(context.Background(), "", cmd, args)
+}
+
+// RunCommand is like RunCommandContext with a background context.
+func (w *Win) RunCommand(cmd string, args ...string) error {
+	return w.RunCommandContext(context.Background(), cmd, args...)
+}
+
+// RunCommandContext clears the body of w and runs the command cmd with
+// the given arguments, streaming its standard output and standard
+// error into the body as Run does. The command runs in the window's
+// directory: the directory named at the start of the tag, or the one
+// holding the file named there. It is killed if ctx is done, as with
+// a timeout, or if the window is deleted.
+//
+// As when acme runs a command, a failure of the command itself, such
+// as a non-zero exit status, is reported in the window: it is
+// appended to the body. The returned error reports only a failure to
+// use the window.
+func (w *Win) RunCommandContext(ctx context.Context, cmd string, args ...string) error {
+	name, err := w.tagName()
+	if err != nil {
+		return err
+	}
+	dir := ""
+	if path.IsAbs(name) {
+		dir = name
+		if !strings.HasSuffix(name, "/") {
+			dir = path.Dir(name)
+		}
+	}
+	w.Clear()
+	if err := w.run(ctx, dir, cmd, args); err != nil {
+		if _, werr := w.Write("body", []byte(fmt.Sprintf("%s: %v\n", cmd, err))); werr != nil {
+			return werr
+		}
+	}
+	return nil
+}
+
+// run runs cmd in dir, appending its output to the body of w, and
+// returns the error from starting it or from exec.Cmd.Wait.
+// The command is killed if ctx is done or the window is deleted.
+func (w *Win) run(ctx context.Context, dir, cmd string, args []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop, err := w.watchDelete(cancel)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	pr, pw := io.Pipe()
+	c := exec.CommandContext(ctx, cmd, args...)
+	c.Dir = dir
+	c.Stdout = pw
+	c.Stderr = pw
+	if err := c.Start(); err != nil {
+		pw.Close()
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b := bufio.NewReader(pr)
+		for {
+			line, err := b.ReadBytes('\n')
+			if len(line) > 0 {
+				// Once the window is gone the writes fail;
+				// keep reading so the command is not blocked
+				// until it is killed.
+				w.Write("body", line)
+				w.Addr("$")
+				w.Ctl("dot=addr")
+				w.Ctl("show")
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	err = c.Wait()
+	pw.Close()
+	<-done
+	w.Ctl("clean")
+	return err
+}
@@ -0,0 +1,135 @@
+//go:build !plan9
+// +build !plan9
+
+package acme
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetReadOnlyKeepsAddr(t *testing.T) {
+	fw := newFakeWin("one\ntwo\n", "")
+	w := openFakeWin(t, fw)
+	if err := w.SetReadOnly(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The user's edit is reverted between the program's
+	// setting of the address and its write to data.
+	if err := w.Addr("#4,#7"); err != nil {
+		t.Fatal(err)
+	}
+	fw.Type(0, "X")
+	if _, err := w.ReadEvent(); err != nil {
+		t.Fatal(err)
+	}
+	if body := fw.Body(); body != "one\ntwo\n" {
+		t.Fatalf("body after revert = %q, want %q", body, "one\ntwo\n")
+	}
+	if _, err := w.Write("data", []byte("TWO")); err != nil {
+		t.Fatal(err)
+	}
+	if body := fw.Body(); body != "one\nTWO\n" {
+		t.Errorf("body = %q, want %q", body, "one\nTWO\n")
+	}
+}
+
+func TestLoadTextKeepsAddr(t *testing.T) {
+	fw := newFakeWin("one\ntwo\n", "")
+	w := openFakeWin(t, fw)
+	if err := w.Addr("#4,#7"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Acme omitted the text of the insertion, which the event
+	// loop reads back between the program's address and its write.
+	e := &Event{C1: 'K', C2: 'I', Q0: 0, Q1: 3}
+	w.loadText(e, nil)
+	if string(e.Text) != "one" {
+		t.Fatalf("loaded text %q, want %q", e.Text, "one")
+	}
+	if q0, q1, err := w.ReadAddr(); err != nil || q0 != 4 || q1 != 7 {
+		t.Errorf("ReadAddr = %d, %d, %v, want 4, 7", q0, q1, err)
+	}
+}
+
+func TestRenameWindow(t *testing.T) {
+	fw := newFakeWin("", "")
+	w := openFakeWin(t, fw)
+	for _, name := range []string{"/tmp/grep-results", "/tmp/grep results"} {
+		if err := w.RenameWindow(name); err != nil {
+			t.Fatalf("RenameWindow(%q): %v", name, err)
+		}
+		ctl := fw.Ctl()
+		want := "name " + name + "\n"
+		if len(ctl) == 0 || ctl[len(ctl)-1] != want {
+			t.Errorf("RenameWindow(%q) wrote ctl messages %q, want %q at the end", name, ctl, want)
+		}
+		if w.name != name {
+			t.Errorf("after RenameWindow(%q), w.name = %q", name, w.name)
+		}
+	}
+
+	n := len(fw.Ctl())
+	if err := w.RenameWindow("/tmp/a\nb"); err == nil {
+		t.Error("RenameWindow of a name with a newline succeeded")
+	}
+	if ctl := fw.Ctl(); len(ctl) != n {
+		t.Errorf("RenameWindow of a name with a newline wrote %q to ctl", ctl[n:])
+	}
+}
+
+func TestMiddleClick(t *testing.T) {
+	for _, tt := range []struct {
+		tag, want string
+	}{
+		{"/tmp/x Del Snarf | Look ", "/tmp/x Del Snarf | Look "},
+		{"/tmp/x Del Snarf |", "/tmp/x Del Snarf |"},
+		{"/tmp/x Del Snarf", "/tmp/x Del Snarf |"},
+		{"/tmp/ü Del Snarf | Löök", "/tmp/ü Del Snarf | Löök"},
+	} {
+		fw := newFakeWin("", tt.tag)
+		w := openFakeWin(t, fw)
+		if err := w.MiddleClick("Newcol"); err != nil {
+			t.Fatalf("tag %q: MiddleClick: %v", tt.tag, err)
+		}
+		if x := fw.Executed(); len(x) != 1 || x[0] != "Newcol" {
+			t.Errorf("tag %q: executed %q, want [Newcol]", tt.tag, x)
+		}
+		if tag := fw.Tag(); tag != tt.want {
+			t.Errorf("tag %q: tag after MiddleClick = %q, want %q", tt.tag, tag, tt.want)
+		}
+		if w.event != nil {
+			t.Errorf("tag %q: MiddleClick left the event file open", tt.tag)
+		}
+	}
+}
+
+func TestMenu(t *testing.T) {
+	fw := newFakeWin("", "/tmp/x Del Snarf | Look ")
+	w := openFakeWin(t, fw)
+	selected := make(chan string, 1)
+	if err := Menu(w, []string{"Next", "Prev"}, func(item string) { selected <- item }); err != nil {
+		t.Fatal(err)
+	}
+	if tag, want := fw.Tag(), "/tmp/x Del Snarf | Look Next Prev"; tag != want {
+		t.Errorf("tag = %q, want %q", tag, want)
+	}
+	fw.events <- "Mx0 4 0 4 Prev\n"
+	select {
+	case item := <-selected:
+		if item != "Prev" {
+			t.Errorf("selected %q, want Prev", item)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no item selected")
+	}
+
+	if err := Menu(w, []string{"A", "A"}, nil); err == nil {
+		t.Error("Menu with a duplicate item succeeded")
+	}
+	if err := Menu(w, []string{"A B"}, nil); err == nil {
+		t.Error("Menu with a two-word item succeeded")
+	}
+}
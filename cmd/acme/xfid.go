@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 
@@ -585,6 +586,25 @@ func xfidwrite(x *Xfid) {
 	}
 }
 
+// movetocol moves w to the n'th column of its row, counting from 0.
+// If there is no such column, it adds one at the right,
+// or uses the rightmost column if there is no room for another.
+func movetocol(w *wind.Window, n int) {
+	c := w.Col
+	row := c.Row
+	var nc *wind.Column
+	if n < len(row.Col) {
+		nc = row.Col[n]
+	} else if nc = wind.RowAdd(row, nil, -1); nc == nil {
+		nc = row.Col[len(row.Col)-1]
+	}
+	if nc == c {
+		return
+	}
+	wind.Colclose(c, w, false)
+	wind.Coladd(nc, w, nil, -1)
+}
+
 func xfidctlwrite(x *Xfid, w *wind.Window) {
 	scrdraw := false
 	settag := false
@@ -762,6 +782,22 @@ func xfidctlwrite(x *Xfid, w *wind.Window) {
 			wind.Wincleartatg(w)
 			settag = true
 			p = p[8:]
+		} else if strings.HasPrefix(p, "col ") { // move to column
+			pp := p[4:]
+			p = p[4:]
+			i := strings.Index(pp, "\n")
+			if i <= 0 {
+				err = Ebadctl
+				break
+			}
+			pp = pp[:i]
+			p = p[i+1:]
+			n, err1 := strconv.Atoi(pp)
+			if err1 != nil || n < 0 {
+				err = Ebadctl
+				break
+			}
+			movetocol(w, n)
 		} else {
 			err = Ebadctl
 			break
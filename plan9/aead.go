@@ -0,0 +1,164 @@
+package plan9
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+const aeadNonceSize = 12
+
+// NewAEADConn returns a connection that encrypts and authenticates
+// the 9P messages sent over inner with AES-256-GCM, using keys derived
+// from the pre-shared secret with HKDF-SHA256. Both ends of the
+// connection must use NewAEADConn with the same secret, at the same
+// time: NewAEADConn does not return until it has exchanged salts with
+// the other end, and it closes inner if the exchange fails.
+//
+// Each end sends a random 32-byte salt, and the key for each direction
+// is derived from the secret, both salts and the direction, so that
+// a message decrypts only on the connection and in the direction it
+// was sent; the nonce of each message is then its sequence number in
+// that direction, so nonces are never reused under a key. Each message
+// written, as delimited by its 4-byte length prefix, is sent as a
+// 4-byte length, counting itself, the 12-byte nonce and the ciphertext
+// including its 16-byte tag, followed by the nonce and ciphertext.
+// Read decrypts the messages, returning an error if a message has
+// been tampered with, reordered, replayed or reflected; after that
+// all further reads fail.
+func NewAEADConn(inner net.Conn, secret []byte) (net.Conn, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("plan9: empty AEAD secret")
+	}
+	wkey, rkey, err := exchangeSalts(inner, secret, "9P2000 AES-256-GCM")
+	if err != nil {
+		return nil, err
+	}
+	c := &aeadConn{Conn: inner}
+	if c.waead, err = newAEAD(wkey); err != nil {
+		return nil, err
+	}
+	if c.raead, err = newAEAD(rkey); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newAEAD returns the AES-256-GCM cipher with the 32-byte key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type aeadConn struct {
+	net.Conn
+
+	wmu   sync.Mutex
+	waead cipher.AEAD
+	wseq  uint64
+	wbuf  []byte // incomplete message written so far
+
+	rmu   sync.Mutex
+	raead cipher.AEAD
+	rseq  uint64
+	rbuf  []byte // decrypted message data not yet returned
+	rerr  error
+}
+
+var errAEAD = ProtocolError("message failed AEAD decryption")
+
+func aeadNonce(seq uint64) []byte {
+	var nonce [aeadNonceSize]byte
+	binary.BigEndian.PutUint64(nonce[aeadNonceSize-8:], seq)
+	return nonce[:]
+}
+
+func (c *aeadConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.wbuf = append(c.wbuf, b...)
+	for len(c.wbuf) >= 4 {
+		n, _ := gbit32(c.wbuf)
+		if n < 4 {
+			c.wbuf = nil
+			return 0, ProtocolError("invalid length")
+		}
+		if uint32(len(c.wbuf)) < n {
+			break
+		}
+		msg := c.wbuf[:n]
+		size := 4 + aeadNonceSize + len(msg) + c.waead.Overhead()
+		out := make([]byte, 4, size)
+		pbit32(out[0:0], uint32(size))
+		nonce := aeadNonce(c.wseq)
+		out = append(out, nonce...)
+		out = c.waead.Seal(out, nonce, msg, out[:4])
+		if _, err := c.Conn.Write(out); err != nil {
+			return 0, err
+		}
+		c.wseq++
+		c.wbuf = c.wbuf[n:]
+	}
+	if len(c.wbuf) == 0 {
+		c.wbuf = nil
+	}
+	return len(b), nil
+}
+
+func (c *aeadConn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	if len(c.rbuf) == 0 {
+		if c.rerr != nil {
+			return 0, c.rerr
+		}
+		msg, err := c.readMsg()
+		if err != nil {
+			c.rerr = err
+			return 0, err
+		}
+		c.rbuf = msg
+	}
+	n := copy(b, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+// readMsg reads and decrypts the next message from the underlying connection.
+func (c *aeadConn) readMsg() ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(c.Conn, hdr[:]); err != nil {
+		return nil, err
+	}
+	n, _ := gbit32(hdr[:])
+	if n < uint32(4+aeadNonceSize+c.raead.Overhead()) {
+		return nil, ProtocolError("invalid length")
+	}
+	buf := make([]byte, n-4)
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	nonce, ct := buf[:aeadNonceSize], buf[aeadNonceSize:]
+	if !hmac.Equal(nonce, aeadNonce(c.rseq)) {
+		return nil, errAEAD
+	}
+	msg, err := c.raead.Open(ct[:0], nonce, ct, hdr[:])
+	if err != nil {
+		return nil, errAEAD
+	}
+	c.rseq++
+	return msg, nil
+}
@@ -0,0 +1,14 @@
+package plan9_test
+
+import (
+	"net"
+	"testing"
+
+	"9fans.net/go/plan9"
+)
+
+func TestAEADConn(t *testing.T) {
+	testSecureConn(t, func(c net.Conn) (net.Conn, error) {
+		return plan9.NewAEADConn(c, []byte("secret"))
+	})
+}
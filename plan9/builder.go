@@ -0,0 +1,199 @@
+package plan9
+
+import "fmt"
+
+// The fields of an Fcall, for recording which ones a message type uses.
+const (
+	fFid uint32 = 1 << iota
+	fMsize
+	fVersion
+	fOldtag
+	fEname
+	fQid
+	fIounit
+	fAqid
+	fAfid
+	fUname
+	fAname
+	fPerm
+	fName
+	fMode
+	fNewfid
+	fWname
+	fWqid
+	fOffset
+	fCount
+	fData
+	fStat
+	fErrno
+	fUid
+	fExtension
+	fUnixfd
+)
+
+// fcallFields maps each message type to the fields it uses,
+// besides Type and Tag, which every message has.
+var fcallFields = map[uint8]uint32{
+	Tversion: fMsize | fVersion,
+	Rversion: fMsize | fVersion,
+	Tauth:    fAfid | fUname | fAname | fUid,
+	Rauth:    fAqid,
+	Tattach:  fFid | fAfid | fUname | fAname | fUid,
+	Rattach:  fQid,
+	Rerror:   fEname | fErrno,
+	Tflush:   fOldtag,
+	Rflush:   0,
+	Twalk:    fFid | fNewfid | fWname,
+	Rwalk:    fWqid,
+	Topen:    fFid | fMode,
+	Ropen:    fQid | fIounit,
+	Tcreate:  fFid | fName | fPerm | fMode | fExtension,
+	Rcreate:  fQid | fIounit,
+	Tread:    fFid | fOffset | fCount,
+	Rread:    fData,
+	Twrite:   fFid | fOffset | fData,
+	Rwrite:   fCount,
+	Tclunk:   fFid,
+	Rclunk:   0,
+	Tremove:  fFid,
+	Rremove:  0,
+	Tstat:    fFid,
+	Rstat:    fStat,
+	Twstat:   fFid | fStat,
+	Rwstat:   0,
+	Treaddir: fFid | fOffset | fCount,
+	Rreaddir: fData,
+
+	Topenfd: fFid | fMode,
+	Ropenfd: fQid | fIounit | fUnixfd,
+}
+
+// setFields returns the fields of f that hold other than their zero value.
+func (f *Fcall) setFields() uint32 {
+	var m uint32
+	set := func(bit uint32, ok bool) {
+		if ok {
+			m |= bit
+		}
+	}
+	set(fFid, f.Fid != 0)
+	set(fMsize, f.Msize != 0)
+	set(fVersion, f.Version != "")
+	set(fOldtag, f.Oldtag != 0)
+	set(fEname, f.Ename != "")
+	set(fQid, f.Qid != Qid{})
+	set(fIounit, f.Iounit != 0)
+	set(fAqid, f.Aqid != Qid{})
+	set(fAfid, f.Afid != 0)
+	set(fUname, f.Uname != "")
+	set(fAname, f.Aname != "")
+	set(fPerm, f.Perm != 0)
+	set(fName, f.Name != "")
+	set(fMode, f.Mode != 0)
+	set(fNewfid, f.Newfid != 0)
+	set(fWname, len(f.Wname) != 0)
+	set(fWqid, len(f.Wqid) != 0)
+	set(fOffset, f.Offset != 0)
+	set(fCount, f.Count != 0)
+	set(fData, len(f.Data) != 0)
+	set(fStat, len(f.Stat) != 0)
+	set(fErrno, f.Errno != 0)
+	set(fUid, f.Uid != 0)
+	set(fExtension, f.Extension != "")
+	set(fUnixfd, f.Unixfd != 0)
+	return m
+}
+
+var fieldNames = []string{
+	"Fid", "Msize", "Version", "Oldtag", "Ename", "Qid", "Iounit", "Aqid",
+	"Afid", "Uname", "Aname", "Perm", "Name", "Mode", "Newfid", "Wname",
+	"Wqid", "Offset", "Count", "Data", "Stat",
+	"Errno", "Uid", "Extension", "Unixfd",
+}
+
+// Validate checks that f is a well-formed message: that its Type is
+// a known message type, that no field the type does not use is set,
+// and that its Wname, Wqid and Stat fit within the protocol's limits.
+func (f *Fcall) Validate() error {
+	used, ok := fcallFields[f.Type]
+	if !ok {
+		return ProtocolError(fmt.Sprintf("invalid message type %d", f.Type))
+	}
+	if extra := f.setFields() &^ used; extra != 0 {
+		for i, name := range fieldNames {
+			if extra&(1<<i) != 0 {
+				return ProtocolError(fmt.Sprintf("%s message has %s set", TypeName(f.Type), name))
+			}
+		}
+	}
+	if len(f.Wname) > MAXWELEM || len(f.Wqid) > MAXWELEM {
+		return ProtocolError(fmt.Sprintf("%s message has more than %d path elements", TypeName(f.Type), MAXWELEM))
+	}
+	if len(f.Stat) > MaxStatSize {
+		return ProtocolError(fmt.Sprintf("%s message has %d-byte stat record", TypeName(f.Type), len(f.Stat)))
+	}
+	return nil
+}
+
+// TypeName returns the name of message type t, such as "Twalk".
+func TypeName(t uint8) string {
+	for name, typ := range types {
+		if typ == t {
+			return name
+		}
+	}
+	return fmt.Sprintf("type %d", t)
+}
+
+// An FcallBuilder builds an Fcall a field at a time, for code such as
+// tests that writes many messages out by hand:
+//
+//	f := plan9.New(plan9.Twalk).Tag(3).Fid(1).Newfid(2).Wname("a", "b").Build()
+//
+// Each method sets the Fcall field of the same name and returns the
+// builder. Build checks the result with Validate.
+type FcallBuilder struct {
+	f Fcall
+}
+
+// New returns a builder for a message of type typ.
+func New(typ uint8) *FcallBuilder {
+	return &FcallBuilder{f: Fcall{Type: typ}}
+}
+
+// Build returns the message built by b.
+// It panics if the message does not pass Validate.
+func (b *FcallBuilder) Build() *Fcall {
+	if err := b.f.Validate(); err != nil {
+		panic("plan9: FcallBuilder: " + err.Error())
+	}
+	f := b.f
+	return &f
+}
+
+func (b *FcallBuilder) Tag(tag uint16) *FcallBuilder         { b.f.Tag = tag; return b }
+func (b *FcallBuilder) Fid(fid uint32) *FcallBuilder         { b.f.Fid = fid; return b }
+func (b *FcallBuilder) Msize(msize uint32) *FcallBuilder     { b.f.Msize = msize; return b }
+func (b *FcallBuilder) Version(version string) *FcallBuilder { b.f.Version = version; return b }
+func (b *FcallBuilder) Oldtag(oldtag uint16) *FcallBuilder   { b.f.Oldtag = oldtag; return b }
+func (b *FcallBuilder) Ename(ename string) *FcallBuilder     { b.f.Ename = ename; return b }
+func (b *FcallBuilder) Qid(qid Qid) *FcallBuilder            { b.f.Qid = qid; return b }
+func (b *FcallBuilder) Iounit(iounit uint32) *FcallBuilder   { b.f.Iounit = iounit; return b }
+func (b *FcallBuilder) Aqid(aqid Qid) *FcallBuilder          { b.f.Aqid = aqid; return b }
+func (b *FcallBuilder) Afid(afid uint32) *FcallBuilder       { b.f.Afid = afid; return b }
+func (b *FcallBuilder) Uname(uname string) *FcallBuilder     { b.f.Uname = uname; return b }
+func (b *FcallBuilder) Aname(aname string) *FcallBuilder     { b.f.Aname = aname; return b }
+func (b *FcallBuilder) Perm(perm Perm) *FcallBuilder         { b.f.Perm = perm; return b }
+func (b *FcallBuilder) Name(name string) *FcallBuilder       { b.f.Name = name; return b }
+func (b *FcallBuilder) Mode(mode uint8) *FcallBuilder        { b.f.Mode = mode; return b }
+func (b *FcallBuilder) Newfid(newfid uint32) *FcallBuilder   { b.f.Newfid = newfid; return b }
+func (b *FcallBuilder) Wname(wname ...string) *FcallBuilder  { b.f.Wname = wname; return b }
+func (b *FcallBuilder) Wqid(wqid ...Qid) *FcallBuilder       { b.f.Wqid = wqid; return b }
+func (b *FcallBuilder) Offset(offset uint64) *FcallBuilder   { b.f.Offset = offset; return b }
+func (b *FcallBuilder) Count(count uint32) *FcallBuilder     { b.f.Count = count; return b }
+func (b *FcallBuilder) Data(data []byte) *FcallBuilder       { b.f.Data = data; return b }
+func (b *FcallBuilder) Stat(stat []byte) *FcallBuilder       { b.f.Stat = stat; return b }
+func (b *FcallBuilder) Errno(errno uint32) *FcallBuilder     { b.f.Errno = errno; return b }
+func (b *FcallBuilder) Uid(uid uint32) *FcallBuilder         { b.f.Uid = uid; return b }
+func (b *FcallBuilder) Extension(ext string) *FcallBuilder   { b.f.Extension = ext; return b }
+func (b *FcallBuilder) Unixfd(fd uint32) *FcallBuilder       { b.f.Unixfd = fd; return b }
@@ -0,0 +1,37 @@
+package plan9_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"9fans.net/go/plan9"
+)
+
+func TestFcallBuilder(t *testing.T) {
+	f := plan9.New(plan9.Twalk).Tag(3).Fid(1).Newfid(2).Wname("a", "b").Build()
+	want := &plan9.Fcall{Type: plan9.Twalk, Tag: 3, Fid: 1, Newfid: 2, Wname: []string{"a", "b"}}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("Build = %v, want %v", f, want)
+	}
+
+	for _, tt := range []struct {
+		b    *plan9.FcallBuilder
+		want string
+	}{
+		{plan9.New(plan9.Twalk).Fid(1).Data([]byte("x")), "Twalk message has Data set"},
+		{plan9.New(plan9.Rclunk).Fid(1), "Rclunk message has Fid set"},
+		{plan9.New(plan9.Terror), "invalid message type"},
+		{plan9.New(plan9.Twalk).Wname(make([]string, plan9.MAXWELEM+1)...), "path elements"},
+	} {
+		func() {
+			defer func() {
+				e := recover()
+				if s, _ := e.(string); !strings.Contains(s, tt.want) {
+					t.Errorf("Build panicked with %v, want %q", e, tt.want)
+				}
+			}()
+			tt.b.Build()
+		}()
+	}
+}
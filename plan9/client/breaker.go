@@ -0,0 +1,112 @@
+//go:build !plan9
+// +build !plan9
+
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"9fans.net/go/plan9"
+)
+
+// WithCircuitBreaker returns a DialOption that stops the connection
+// sending requests to a server that has failed threshold of them in
+// a row, as a server that accepts connections but rejects every
+// request might. While the circuit is open, new requests fail at once
+// with ErrCircuitOpen. After openDuration the connection stats the
+// root of an attached file tree as a probe: if that succeeds the
+// circuit closes, and otherwise it stays open for another openDuration.
+// If no file tree is attached, the circuit closes without a probe.
+//
+// Error replies and failures of the connection count against the
+// server; requests given up on because their context was done do not.
+// Tclunk and Tflush are sent even while the circuit is open,
+// so that fids and tags are not lost.
+func WithCircuitBreaker(threshold int, openDuration time.Duration) DialOption {
+	return func(c *conn) {
+		c.breaker = &breaker{c: c, threshold: threshold, wait: openDuration}
+	}
+}
+
+// A breaker is the circuit breaker of WithCircuitBreaker.
+// The nil *breaker, of a connection without one, allows everything.
+type breaker struct {
+	c         *conn
+	threshold int
+	wait      time.Duration
+
+	mu    sync.Mutex
+	fails int  // consecutive failed requests
+	open  bool // whether requests are being refused
+}
+
+type probeKey struct{}
+
+// exempt reports whether the request tx, made with ctx,
+// is neither refused nor counted by the breaker.
+func (b *breaker) exempt(ctx context.Context, tx *plan9.Fcall) bool {
+	return b == nil || tx.Type == plan9.Tclunk || tx.Type == plan9.Tflush || ctx.Value(probeKey{}) != nil
+}
+
+// allow returns ErrCircuitOpen if the request tx must not be sent.
+func (b *breaker) allow(ctx context.Context, tx *plan9.Fcall) error {
+	if b.exempt(ctx, tx) {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// record counts the outcome err of the request tx.
+func (b *breaker) record(ctx context.Context, tx *plan9.Fcall, err error) {
+	if b.exempt(ctx, tx) || errors.Is(err, ErrCircuitOpen) || err != nil && ctx.Err() != nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.fails = 0
+		return
+	}
+	b.fails++
+	if !b.open && b.fails >= b.threshold {
+		b.open = true
+		time.AfterFunc(b.wait, b.probe)
+	}
+}
+
+// probe stats a root fid of the connection, closing the circuit if
+// that succeeds and waiting to try again if not.
+func (b *breaker) probe() {
+	if b.c.getErr() != nil {
+		return // the connection is gone
+	}
+	var root *Fid
+	b.c.x.Lock()
+	for fid := range b.c.fids {
+		if fid.noReap.Load() && fid.qid.Type&plan9.QTAUTH == 0 {
+			root = fid
+			break
+		}
+	}
+	b.c.x.Unlock()
+	var err error
+	if root != nil {
+		_, err = root.StatContext(context.WithValue(context.Background(), probeKey{}, true))
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		time.AfterFunc(b.wait, b.probe)
+		return
+	}
+	b.open = false
+	b.fails = 0
+}
@@ -0,0 +1,704 @@
+//go:build !plan9
+// +build !plan9
+
+// Package compliance provides a test suite for 9P file servers,
+// run through the plan9/client package.
+//
+// A test of a server mounts it and passes TestSuite a way to connect:
+//
+//	func TestServer(t *testing.T) {
+//		compliance.TestSuite(t, func() (*client.Fsys, error) {
+//			return client.Mount("unix", "/tmp/ns.glenda/myfs")
+//		})
+//	}
+//
+// The suite expects the semantics of a Plan 9 disk file system,
+// such as those of server.MemFS.
+package compliance
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+)
+
+// TestSuite tests the file server reached by connect. It calls connect
+// once for the suite and again where it needs a second attach, and
+// closes each Fsys that connect returns when it is done with it.
+//
+// The suite works in a directory it creates at the root of the tree,
+// which must be writable, and removes that directory at the end.
+// Each subtest has its own directory inside it, so the server may
+// hold other files, and the suite may be run against it repeatedly.
+func TestSuite(t *testing.T, connect func() (*client.Fsys, error)) {
+	fsys := mount(t, connect)
+	root := "compliance." + strconv.FormatInt(time.Now().UnixNano(), 36)
+	mkdir(t, fsys, root)
+	t.Cleanup(func() {
+		if err := fsys.RemoveAll(root); err != nil {
+			t.Errorf("removing %s: %v", root, err)
+		}
+	})
+
+	s := &suite{fsys: fsys, connect: connect}
+	for _, tt := range []struct {
+		name string
+		fn   func(*testing.T, string)
+	}{
+		{"Create", s.testCreate},
+		{"ReadWrite", s.testReadWrite},
+		{"Version", s.testVersion},
+		{"Stat", s.testStat},
+		{"Wstat", s.testWstat},
+		{"Remove", s.testRemove},
+		{"Mkdir", s.testMkdir},
+		{"ReadDir", s.testReadDir},
+		{"Walk", s.testWalk},
+		{"Concurrent", s.testConcurrent},
+		{"Errors", s.testErrors},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := path.Join(root, tt.name)
+			mkdir(t, fsys, dir)
+			tt.fn(t, dir)
+		})
+	}
+}
+
+type suite struct {
+	fsys    *client.Fsys
+	connect func() (*client.Fsys, error)
+}
+
+// mount calls connect, closing the result when t ends.
+func mount(t *testing.T, connect func() (*client.Fsys, error)) *client.Fsys {
+	t.Helper()
+	fsys, err := connect()
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { fsys.Close() })
+	return fsys
+}
+
+func mkdir(t *testing.T, fsys *client.Fsys, name string) {
+	t.Helper()
+	fid, err := fsys.Create(name, plan9.OREAD, plan9.DMDIR|0777)
+	if err != nil {
+		t.Fatalf("mkdir %s: %v", name, err)
+	}
+	fid.Close()
+}
+
+func writeFile(t *testing.T, fsys *client.Fsys, name, data string) {
+	t.Helper()
+	fid, err := fsys.Create(name, plan9.OWRITE, 0666)
+	if err != nil {
+		t.Fatalf("create %s: %v", name, err)
+	}
+	defer fid.Close()
+	if _, err := fid.Write([]byte(data)); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+}
+
+func readFile(t *testing.T, fsys *client.Fsys, name string) string {
+	t.Helper()
+	data, err := fsys.ReadFile(name)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func stat(t *testing.T, fsys *client.Fsys, name string) *plan9.Dir {
+	t.Helper()
+	d, err := fsys.Stat(name)
+	if err != nil {
+		t.Fatalf("stat %s: %v", name, err)
+	}
+	return d
+}
+
+// names returns the sorted names of the entries in the directory name.
+func names(t *testing.T, fsys *client.Fsys, name string) []string {
+	t.Helper()
+	dirs, err := fsys.ReadDir(name)
+	if err != nil {
+		t.Fatalf("readdir %s: %v", name, err)
+	}
+	var list []string
+	for _, d := range dirs {
+		list = append(list, d.Name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func equal(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *suite) testCreate(t *testing.T, dir string) {
+	name := path.Join(dir, "f")
+	fid, err := s.fsys.Create(name, plan9.ORDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid.Close()
+	if q := fid.Qid(); q.Type&plan9.QTDIR != 0 {
+		t.Errorf("created file has qid type %#x, want a file", q.Type)
+	}
+	// The new fid is open for the mode given to Tcreate.
+	if _, err := fid.Write([]byte("x")); err != nil {
+		t.Errorf("write to created file: %v", err)
+	}
+	b := make([]byte, 10)
+	if n, err := fid.ReadAt(b, 0); n != 1 || err != nil && err != io.EOF {
+		t.Errorf("read of created file = %d, %v, want 1", n, err)
+	}
+
+	d := stat(t, s.fsys, name)
+	if d.Name != "f" || d.Mode != 0644 || d.Length != 1 || d.Qid.Path != fid.Qid().Path {
+		t.Errorf("stat of created file = name %q mode %v length %d path %#x, want f 0644 1 %#x",
+			d.Name, d.Mode, d.Length, d.Qid.Path, fid.Qid().Path)
+	}
+}
+
+func (s *suite) testReadWrite(t *testing.T, dir string) {
+	name := path.Join(dir, "f")
+	writeFile(t, s.fsys, name, "hello, world")
+	if data := readFile(t, s.fsys, name); data != "hello, world" {
+		t.Errorf("read = %q, want %q", data, "hello, world")
+	}
+
+	fid, err := s.fsys.Open(name, plan9.ORDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid.Close()
+
+	// Writes at an offset overwrite; writes past the end extend.
+	if _, err := fid.WriteAt([]byte("HELLO"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fid.WriteAt([]byte("!"), 12); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 5)
+	if n, err := fid.ReadAt(b, 7); string(b[:n]) != "world" || err != nil && err != io.EOF {
+		t.Errorf("ReadAt(7) = %q, %v, want %q", b[:n], err, "world")
+	}
+	if n, err := fid.ReadAt(b, 13); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt past end = %d, %v, want 0, EOF", n, err)
+	}
+	if data := readFile(t, s.fsys, name); data != "HELLO, world!" {
+		t.Errorf("read = %q, want %q", data, "HELLO, world!")
+	}
+
+	// A large write is split into messages and read back whole.
+	big := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	if _, err := fid.WriteAt(big, 0); err != nil {
+		t.Fatal(err)
+	}
+	if data := readFile(t, s.fsys, name); data != string(big) {
+		t.Errorf("read of %d-byte write returned %d bytes", len(big), len(data))
+	}
+
+	// OTRUNC empties a file.
+	tfid, err := s.fsys.OpenTrunc(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tfid.Close()
+	if d := stat(t, s.fsys, name); d.Length != 0 {
+		t.Errorf("length after OTRUNC = %d, want 0", d.Length)
+	}
+}
+
+func (s *suite) testVersion(t *testing.T, dir string) {
+	name := path.Join(dir, "f")
+	fid, err := s.fsys.Create(name, plan9.OWRITE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid.Close()
+	v := stat(t, s.fsys, name).Qid.Vers
+	for i := 0; i < 3; i++ {
+		if _, err := fid.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		vers := stat(t, s.fsys, name).Qid.Vers
+		if vers <= v {
+			t.Errorf("after write %d, qid version %d, want more than %d", i+1, vers, v)
+		}
+		v = vers
+	}
+
+	// Reading leaves the version alone.
+	readFile(t, s.fsys, name)
+	if vers := stat(t, s.fsys, name).Qid.Vers; vers != v {
+		t.Errorf("after read, qid version %d, want %d", vers, v)
+	}
+}
+
+func (s *suite) testStat(t *testing.T, dir string) {
+	name := path.Join(dir, "f")
+	writeFile(t, s.fsys, name, "12345")
+	d := stat(t, s.fsys, name)
+	if d.Name != "f" || d.Length != 5 || d.Mode&plan9.DMDIR != 0 || d.Qid.Type&plan9.QTDIR != 0 {
+		t.Errorf("stat f = name %q length %d mode %v qid type %#x, want f 5, a plain file",
+			d.Name, d.Length, d.Mode, d.Qid.Type)
+	}
+	if d.Uid == "" || d.Gid == "" {
+		t.Errorf("stat f = uid %q gid %q, want both set", d.Uid, d.Gid)
+	}
+	if now := time.Now().Unix(); int64(d.Mtime) < now-3600 || int64(d.Mtime) > now+3600 {
+		t.Errorf("stat f = mtime %v, want about %v", time.Unix(int64(d.Mtime), 0), time.Unix(now, 0))
+	}
+
+	// Stat of an open fid agrees with stat by name.
+	fid, err := s.fsys.Open(name, plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid.Close()
+	d1, err := fid.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d1.Qid != d.Qid || d1.Length != d.Length {
+		t.Errorf("fid stat = qid %v length %d, stat by name = qid %v length %d", d1.Qid, d1.Length, d.Qid, d.Length)
+	}
+
+	// A directory stats as one.
+	d = stat(t, s.fsys, dir)
+	if d.Mode&plan9.DMDIR == 0 || d.Qid.Type&plan9.QTDIR == 0 {
+		t.Errorf("stat of directory = mode %v qid type %#x", d.Mode, d.Qid.Type)
+	}
+}
+
+func (s *suite) testWstat(t *testing.T, dir string) {
+	name := path.Join(dir, "f")
+	writeFile(t, s.fsys, name, "hello")
+	path0 := stat(t, s.fsys, name).Qid.Path
+
+	// A null Dir changes nothing.
+	var d plan9.Dir
+	d.Null()
+	if err := s.fsys.Wstat(name, &d); err != nil {
+		t.Errorf("null wstat: %v", err)
+	}
+
+	d.Mode = 0600
+	if err := s.fsys.Wstat(name, &d); err != nil {
+		t.Fatalf("wstat mode: %v", err)
+	}
+	if got := stat(t, s.fsys, name); got.Mode != 0600 {
+		t.Errorf("mode after wstat = %v, want 0600", got.Mode)
+	}
+
+	d.Null()
+	d.Length = 2
+	if err := s.fsys.Wstat(name, &d); err != nil {
+		t.Fatalf("wstat length: %v", err)
+	}
+	if data := readFile(t, s.fsys, name); data != "he" {
+		t.Errorf("read after truncating wstat = %q, want %q", data, "he")
+	}
+
+	mtime := uint32(time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC).Unix())
+	d.Null()
+	d.Mtime = mtime
+	if err := s.fsys.Wstat(name, &d); err != nil {
+		t.Fatalf("wstat mtime: %v", err)
+	}
+	if got := stat(t, s.fsys, name); got.Mtime != mtime {
+		t.Errorf("mtime after wstat = %d, want %d", got.Mtime, mtime)
+	}
+
+	// Renaming keeps the file's identity and contents.
+	d.Null()
+	d.Name = "g"
+	if err := s.fsys.Wstat(name, &d); err != nil {
+		t.Fatalf("wstat name: %v", err)
+	}
+	if _, err := s.fsys.Stat(name); !errors.Is(err, client.ErrNotExist) {
+		t.Errorf("stat of old name after rename: %v, want ErrNotExist", err)
+	}
+	newName := path.Join(dir, "g")
+	if got := stat(t, s.fsys, newName); got.Name != "g" || got.Qid.Path != path0 {
+		t.Errorf("stat after rename = name %q path %#x, want g %#x", got.Name, got.Qid.Path, path0)
+	}
+	if data := readFile(t, s.fsys, newName); data != "he" {
+		t.Errorf("read after rename = %q, want %q", data, "he")
+	}
+
+	// Renaming onto an existing name fails and changes nothing.
+	writeFile(t, s.fsys, name, "other")
+	if err := s.fsys.Wstat(newName, &d); err != nil {
+		t.Fatalf("wstat of unchanged name: %v", err)
+	}
+	d.Name = "f"
+	if err := s.fsys.Wstat(newName, &d); err == nil {
+		t.Errorf("rename onto existing file succeeded")
+	}
+	if data := readFile(t, s.fsys, name); data != "other" {
+		t.Errorf("after failed rename, f = %q, want %q", data, "other")
+	}
+}
+
+func (s *suite) testRemove(t *testing.T, dir string) {
+	name := path.Join(dir, "f")
+	writeFile(t, s.fsys, name, "x")
+	if err := s.fsys.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.fsys.Stat(name); !errors.Is(err, client.ErrNotExist) {
+		t.Errorf("stat after remove: %v, want ErrNotExist", err)
+	}
+	if err := s.fsys.Remove(name); err == nil {
+		t.Errorf("second remove succeeded")
+	}
+
+	// An open fid on a removed file does not bring it back.
+	writeFile(t, s.fsys, name, "x")
+	fid, err := s.fsys.Open(name, plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.fsys.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	fid.Close()
+	if ok, err := s.fsys.Exists(name); ok || err != nil {
+		t.Errorf("Exists after remove of open file = %v, %v, want false, nil", ok, err)
+	}
+
+	// A directory can be removed once it is empty.
+	sub := path.Join(dir, "d")
+	mkdir(t, s.fsys, sub)
+	writeFile(t, s.fsys, path.Join(sub, "f"), "x")
+	if err := s.fsys.Remove(sub); err == nil {
+		t.Fatalf("remove of non-empty directory succeeded")
+	}
+	if err := s.fsys.Remove(path.Join(sub, "f")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.fsys.Remove(sub); err != nil {
+		t.Errorf("remove of empty directory: %v", err)
+	}
+}
+
+func (s *suite) testMkdir(t *testing.T, dir string) {
+	sub := path.Join(dir, "d")
+	fid, err := s.fsys.Create(sub, plan9.OREAD, plan9.DMDIR|0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := fid.Qid(); q.Type&plan9.QTDIR == 0 {
+		t.Errorf("created directory has qid type %#x, want QTDIR", q.Type)
+	}
+	// The new directory is open for reading and empty.
+	dirs, err := fid.Dirreadall()
+	if len(dirs) != 0 || err != nil {
+		t.Errorf("read of new directory = %v, %v, want no entries", dirs, err)
+	}
+	fid.Close()
+
+	d := stat(t, s.fsys, sub)
+	if d.Mode != plan9.DMDIR|0755 {
+		t.Errorf("mode of new directory = %v, want %v", d.Mode, plan9.DMDIR|0755)
+	}
+
+	// Directories nest.
+	mkdir(t, s.fsys, path.Join(sub, "e"))
+	writeFile(t, s.fsys, path.Join(sub, "e", "f"), "deep")
+	if data := readFile(t, s.fsys, path.Join(sub, "e", "f")); data != "deep" {
+		t.Errorf("read of nested file = %q, want %q", data, "deep")
+	}
+
+	// A directory cannot be created open for writing.
+	if fid, err := s.fsys.Create(path.Join(dir, "w"), plan9.OWRITE, plan9.DMDIR|0777); err == nil {
+		fid.Close()
+		t.Errorf("create of directory for writing succeeded")
+	}
+}
+
+func (s *suite) testReadDir(t *testing.T, dir string) {
+	if list := names(t, s.fsys, dir); len(list) != 0 {
+		t.Errorf("new directory lists %v, want nothing", list)
+	}
+
+	want := []string{"a", "b", "c", "d"}
+	for _, name := range want[:3] {
+		writeFile(t, s.fsys, path.Join(dir, name), name)
+	}
+	mkdir(t, s.fsys, path.Join(dir, "d"))
+	if list := names(t, s.fsys, dir); !equal(list, want) {
+		t.Errorf("after creates, directory lists %v, want %v", list, want)
+	}
+
+	if err := s.fsys.Remove(path.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"a", "c", "d"}
+	if list := names(t, s.fsys, dir); !equal(list, want) {
+		t.Errorf("after remove, directory lists %v, want %v", list, want)
+	}
+
+	// Entries are full Dirs, matching Stat.
+	dirs, err := s.fsys.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range dirs {
+		sd := stat(t, s.fsys, path.Join(dir, d.Name))
+		if d.Qid != sd.Qid || d.Mode != sd.Mode || d.Length != sd.Length {
+			t.Errorf("entry %s = qid %v mode %v length %d, stat = qid %v mode %v length %d",
+				d.Name, d.Qid, d.Mode, d.Length, sd.Qid, sd.Mode, sd.Length)
+		}
+	}
+
+	// A listing spanning many reads has every entry once.
+	big := path.Join(dir, "d")
+	want = nil
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("file%03d-with-a-longer-name", i)
+		writeFile(t, s.fsys, path.Join(big, name), "")
+		want = append(want, name)
+	}
+	if list := names(t, s.fsys, big); !equal(list, want) {
+		t.Errorf("large directory lists %d entries, want %d", len(list), len(want))
+	}
+}
+
+func (s *suite) testWalk(t *testing.T, dir string) {
+	mkdir(t, s.fsys, path.Join(dir, "a"))
+	mkdir(t, s.fsys, path.Join(dir, "a", "b"))
+	writeFile(t, s.fsys, path.Join(dir, "a", "b", "f"), "x")
+
+	dfid, err := s.fsys.Open(dir, plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dq := dfid.Qid()
+	dfid.Close()
+
+	fid, err := s.fsys.Open(path.Join(dir, "a", "b", "f"), plan9.OREAD)
+	if err != nil {
+		t.Fatalf("multi-element walk: %v", err)
+	}
+	fq := fid.Qid()
+	fid.Close()
+
+	// ".." walks up, and "." stays put.
+	d := stat(t, s.fsys, path.Join(dir, "a", "b", "..", ".."))
+	if d.Qid.Path != dq.Path {
+		t.Errorf("walk to a/b/../.. reached qid %v, want %v", d.Qid, dq)
+	}
+	d = stat(t, s.fsys, path.Join(dir, "a", "b", ".", "f"))
+	if d.Qid.Path != fq.Path {
+		t.Errorf("walk to a/b/./f reached qid %v, want %v", d.Qid, fq)
+	}
+	d = stat(t, s.fsys, path.Join(dir, "a", "b", "f"))
+	if d.Qid.Path != fq.Path {
+		t.Errorf("walk to a/b/f reached qid %v, want %v", d.Qid, fq)
+	}
+
+	// ".." at the root stays at the root.
+	root := stat(t, s.fsys, "")
+	if d := stat(t, s.fsys, ".."); d.Qid.Path != root.Qid.Path {
+		t.Errorf("walk to .. from root reached qid %v, want %v", d.Qid, root.Qid)
+	}
+
+	// A walk fails at a missing name or a plain file.
+	for _, bad := range []string{"nonexistent", "b/nonexistent", "b/f/x"} {
+		if _, err := s.fsys.Stat(path.Join(dir, "a", bad)); err == nil {
+			t.Errorf("walk to a/%s succeeded", bad)
+		}
+	}
+}
+
+func (s *suite) testConcurrent(t *testing.T, dir string) {
+	const n = 16
+	other := mount(t, s.connect)
+
+	var wg sync.WaitGroup
+	errc := make(chan error, n)
+	for i := 0; i < n; i++ {
+		fsys := s.fsys
+		if i%2 == 1 {
+			fsys = other
+		}
+		wg.Add(1)
+		go func(i int, fsys *client.Fsys) {
+			defer wg.Done()
+			name := path.Join(dir, fmt.Sprintf("f%d", i))
+			want := bytes.Repeat([]byte{byte('a' + i)}, 1000*(i+1))
+			if err := fsys.WriteFile(name, want, 0666); err != nil {
+				errc <- err
+				return
+			}
+			got, err := fsys.ReadFile(name)
+			if err != nil {
+				errc <- err
+				return
+			}
+			if !bytes.Equal(got, want) {
+				errc <- fmt.Errorf("%s: read %d bytes after writing %d", name, len(got), len(want))
+				return
+			}
+			if _, err := fsys.Stat(dir); err != nil {
+				errc <- err
+			}
+		}(i, fsys)
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Error(err)
+	}
+
+	// Both attaches see every file.
+	var want []string
+	for i := 0; i < n; i++ {
+		want = append(want, fmt.Sprintf("f%d", i))
+	}
+	sort.Strings(want)
+	for _, fsys := range []*client.Fsys{s.fsys, other} {
+		if list := names(t, fsys, dir); !equal(list, want) {
+			t.Errorf("after concurrent creates, directory lists %v, want %v", list, want)
+		}
+	}
+
+	// Concurrent writes at distinct offsets of one file all land.
+	name := path.Join(dir, "shared")
+	writeFile(t, s.fsys, name, "")
+	fid, err := s.fsys.Open(name, plan9.OWRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid.Close()
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := fid.WriteAt([]byte{byte('a' + i)}, int64(i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	want1 := make([]byte, n)
+	for i := range want1 {
+		want1[i] = byte('a' + i)
+	}
+	if data := readFile(t, other, name); data != string(want1) {
+		t.Errorf("after concurrent writes, file = %q, want %q", data, want1)
+	}
+}
+
+func (s *suite) testErrors(t *testing.T, dir string) {
+	name := path.Join(dir, "f")
+	writeFile(t, s.fsys, name, "x")
+	sub := path.Join(dir, "d")
+	mkdir(t, s.fsys, sub)
+
+	missing := path.Join(dir, "missing")
+	if _, err := s.fsys.Open(missing, plan9.OREAD); !errors.Is(err, client.ErrNotExist) {
+		t.Errorf("open of missing file: %v, want ErrNotExist", err)
+	}
+	if _, err := s.fsys.Stat(missing); !errors.Is(err, client.ErrNotExist) {
+		t.Errorf("stat of missing file: %v, want ErrNotExist", err)
+	}
+	if err := s.fsys.Remove(missing); err == nil {
+		t.Errorf("remove of missing file succeeded")
+	}
+	if fid, err := s.fsys.Create(path.Join(missing, "f"), plan9.OWRITE, 0666); err == nil {
+		fid.Close()
+		t.Errorf("create in missing directory succeeded")
+	}
+
+	if fid, err := s.fsys.Create(name, plan9.OWRITE, 0666); err == nil {
+		fid.Close()
+		t.Errorf("create of existing file succeeded")
+	}
+	if fid, err := s.fsys.Create(path.Join(name, "x"), plan9.OWRITE, 0666); err == nil {
+		fid.Close()
+		t.Errorf("create in a plain file succeeded")
+	}
+	if _, err := s.fsys.Stat(path.Join(name, "x")); err == nil {
+		t.Errorf("walk through a plain file succeeded")
+	}
+	for _, bad := range []string{".", ".."} {
+		if fid, err := s.fsys.Create(path.Join(dir, bad), plan9.OWRITE, 0666); err == nil {
+			fid.Close()
+			t.Errorf("create of %q succeeded", bad)
+		}
+	}
+
+	if fid, err := s.fsys.Open(sub, plan9.OWRITE); err == nil {
+		fid.Close()
+		t.Errorf("open of directory for writing succeeded")
+	}
+	var d plan9.Dir
+	d.Null()
+	d.Length = 1
+	if err := s.fsys.Wstat(sub, &d); err == nil {
+		t.Errorf("wstat of directory length succeeded")
+	}
+
+	// A fid can be used only as it was opened.
+	fid, err := s.fsys.Open(name, plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fid.Write([]byte("y")); err == nil {
+		t.Errorf("write to fid open for reading succeeded")
+	}
+	fid.Close()
+	fid, err = s.fsys.Open(name, plan9.OWRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fid.ReadAt(make([]byte, 1), 0); err == nil {
+		t.Errorf("read from fid open for writing succeeded")
+	}
+	fid.Close()
+
+	// A file without write permission cannot be opened for writing.
+	ro := path.Join(dir, "ro")
+	writeFile(t, s.fsys, ro, "x")
+	d.Null()
+	d.Mode = 0444
+	if err := s.fsys.Wstat(ro, &d); err != nil {
+		t.Fatal(err)
+	}
+	if fid, err := s.fsys.Open(ro, plan9.OWRITE); err == nil {
+		fid.Close()
+		t.Errorf("open of read-only file for writing succeeded")
+	}
+
+	if data := readFile(t, s.fsys, name); data != "x" {
+		t.Errorf("after failed operations, f = %q, want %q", data, "x")
+	}
+}
@@ -0,0 +1,42 @@
+package compliance_test
+
+import (
+	"flag"
+	"net"
+	"strings"
+	"testing"
+
+	"9fans.net/go/plan9/client"
+	"9fans.net/go/plan9/client/compliance"
+	"9fans.net/go/plan9/server"
+)
+
+var addr = flag.String("addr", "", "also run the suite against the 9P server at `network!address`, such as unix!/tmp/ns.glenda/ramfs")
+
+func TestMemFS(t *testing.T) {
+	m := server.New("glenda")
+	compliance.TestSuite(t, func() (*client.Fsys, error) {
+		c1, c2 := net.Pipe()
+		t.Cleanup(func() { c1.Close(); c2.Close() })
+		go m.Serve(c2, c2)
+		conn, err := client.NewConn(c1)
+		if err != nil {
+			return nil, err
+		}
+		return conn.Attach(nil, "glenda", "")
+	})
+}
+
+// TestServer runs the suite against the server named by -addr.
+func TestServer(t *testing.T) {
+	if *addr == "" {
+		t.Skip("no -addr given")
+	}
+	network, address, ok := strings.Cut(*addr, "!")
+	if !ok {
+		t.Fatalf("-addr %q is not network!address", *addr)
+	}
+	compliance.TestSuite(t, func() (*client.Fsys, error) {
+		return client.Mount(network, address)
+	})
+}
@@ -4,10 +4,13 @@
 package client // import "9fans.net/go/plan9/client"
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"9fans.net/go/plan9"
 )
@@ -16,6 +19,41 @@ type Error string
 
 func (e Error) Error() string { return string(e) }
 
+// A ConnError records the name of the Conn on which an error occurred.
+// See Conn.SetName.
+type ConnError struct {
+	Name string
+	Err  error
+}
+
+func (e *ConnError) Error() string { return e.Name + ": " + e.Err.Error() }
+
+func (e *ConnError) Unwrap() error { return e.Err }
+
+// A RequestError records the request that drew an error reply from
+// the server, for a connection using WithRequestErrors. Err is the
+// server's Error, so errors.Is matches ErrNotExist and the others
+// as it would without the wrapping.
+type RequestError struct {
+	Type uint8  // request type, such as plan9.Twalk
+	Fid  uint32 // fid the request was about
+	Path string // file the fid refers to, if known; see WithRequestPath
+	Err  error
+}
+
+func (e *RequestError) Error() string {
+	s := "plan9: " + plan9.TypeName(e.Type)
+	if e.Type != plan9.Tversion && e.Type != plan9.Tflush {
+		s += fmt.Sprintf(" fid=%d", e.Fid)
+	}
+	if e.Path != "" {
+		s += " path=" + e.Path
+	}
+	return s + ": " + e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error { return e.Err }
+
 type Conn struct {
 	// We wrap the underlying conn type so that
 	// there's a clear distinction between Close,
@@ -25,10 +63,145 @@ type Conn struct {
 	mu       sync.Mutex
 	_c       *conn
 	released bool
+
+	// base is the conn originally in _c.
+	// Unlike _c it is never cleared, so that the
+	// monitoring methods keep working after Close and Release.
+	base *conn
+
+	// mounts holds the Fsys values returned by Attach, for CloneConn.
+	// Closed ones are dropped as new ones are added.
+	mounts []*Fsys
+}
+
+// ConnStats holds counters describing the state of a Conn.
+type ConnStats struct {
+	// Fids is the number of Fids currently allocated:
+	// walked, attached or authenticated but not yet clunked.
+	Fids int
+}
+
+// Stats returns the current counters for c.
+func (c *Conn) Stats() ConnStats {
+	return ConnStats{
+		Fids: c.CurrentFidCount(),
+	}
+}
+
+// CurrentFidCount returns the number of Fids derived from c
+// that have not yet been clunked, for detecting fid leaks.
+// It does not take any locks.
+func (c *Conn) CurrentFidCount() int {
+	return int(atomic.LoadInt32(&c.base.nfid))
+}
+
+// SetName sets a name for c, such as the service it is connected to.
+// If the name is not empty, errors from requests on c, and on the Fids
+// derived from it, are returned as a *ConnError carrying that name,
+// so that programs with several connections can tell which one failed.
+func (c *Conn) SetName(name string) {
+	c.base.x.Lock()
+	defer c.base.x.Unlock()
+	c.base.name = name
+}
+
+// Quiesce pauses c: requests already sent continue to completion,
+// but new requests on c, and on the Fids derived from it, block
+// (rather than fail) until a matching call to Unquiesce.
+// Quiesce returns once any requests being sent have been sent.
+// Together with Wait it allows a connection manager to drain c,
+// for example during failover, without closing it.
+// Calls to Quiesce must not be nested.
+func (c *Conn) Quiesce() {
+	c.base.quiesce.Lock()
+}
+
+// Unquiesce resumes a connection paused by Quiesce,
+// unblocking the requests waiting to be sent.
+func (c *Conn) Unquiesce() {
+	c.base.quiesce.Unlock()
+}
+
+// Wait blocks until no RPCs are in flight on c: every request sent
+// has received its response. If ctx is done first, Wait returns ctx.Err().
+// For a clean shutdown, stop issuing requests, call Wait, and then Close.
+func (c *Conn) Wait(ctx context.Context) error {
+	conn := c.base
+	conn.x.Lock()
+	idle := conn.idle
+	conn.x.Unlock()
+	select {
+	case <-idle:
+		return nil
+	default:
+	}
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Ping measures the round-trip time to the server by sending a Tflush
+// for a tag that is not in use, which the server must answer
+// without doing anything else. It can be called periodically
+// to check that the connection is alive.
+func (c *Conn) Ping() (time.Duration, error) {
+	conn, err := c.conn()
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	tx := &plan9.Fcall{Type: plan9.Tflush, Oldtag: plan9.NOTAG}
+	if _, err := conn.rpc(tx, nil); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// Version returns the protocol version negotiated with the server,
+// "9P2000" or, for a connection using WithUnixExtensions, possibly "9P2000.u".
+func (c *Conn) Version() string {
+	return c.base.version
+}
+
+// Msize returns the maximum message size negotiated with the server,
+// the smaller of the sizes proposed by the client (see WithMsize) and
+// by the server. A read or write of more than Msize minus plan9.IOHDRSZ
+// bytes takes more than one message.
+func (c *Conn) Msize() uint32 {
+	return c.base.msize
 }
 
 var errClosed = fmt.Errorf("connection has been closed")
 
+// ErrUnmatchedReply is the protocol error for a reply whose tag matches
+// no outstanding request. An unmatched reply handler can return it
+// to fail the connection; see SetUnmatchedReplyHandler.
+var ErrUnmatchedReply = plan9.ProtocolError("reply tag matches no outstanding request")
+
+// SetUnmatchedReplyHandler sets the function c calls when it reads
+// a reply whose tag matches no outstanding request, as a buggy server
+// sending spurious or duplicate replies might.
+// If fn returns nil, the reply is dropped and c carries on.
+// Otherwise c fails: the requests in flight and all later requests
+// return fn's error, typically ErrUnmatchedReply.
+// A nil fn restores the default, which logs the reply and drops it.
+//
+// fn is called by the goroutine reading replies, so while it runs
+// no replies are delivered; it must not issue requests on c.
+func (c *Conn) SetUnmatchedReplyHandler(fn func(rx *plan9.Fcall) error) {
+	c.base.x.Lock()
+	defer c.base.x.Unlock()
+	c.base.unmatched = fn
+}
+
+func (c *conn) logUnmatched(rx *plan9.Fcall) error {
+	log.Printf("9P: dropping reply with unknown tag: %v", c.sanitized(rx))
+	return nil
+}
+
 // Close forces a close of the connection and all Fids derived
 // from it.
 func (c *Conn) Close() error {
@@ -74,32 +247,218 @@ func (c *Conn) Release() error {
 	return conn.release()
 }
 
+// CloneConn returns a new Conn sharing src's connection to the server,
+// in the way that rfork(RFNAMEG) gives a Plan 9 process a copy of its
+// parent's name space. For each file tree attached with src.Attach and
+// not yet closed, it walks a new fid to the tree's root; the new Conn's
+// Mounts method returns the resulting Fsys values, in the same order as
+// those of src. Fids are never shared, so either Conn can then attach,
+// walk and close independently of the other.
+//
+// Since the connection is shared, closing either Conn closes it for
+// both; to give up one, use Release instead.
+func CloneConn(src *Conn) (*Conn, error) {
+	conn, err := src.conn()
+	if err != nil {
+		return nil, err
+	}
+	conn.acquire()
+	c := &Conn{_c: conn, base: src.base}
+	for _, fs := range src.Mounts() {
+		root, err := fs.root.Walk("")
+		if err != nil {
+			for _, fs := range c.mounts {
+				fs.Close()
+			}
+			conn.release()
+			return nil, err
+		}
+		root.noReap.Store(true)
+		c.mounts = append(c.mounts, &Fsys{root: root, afid: fs.afid, user: fs.user, aname: fs.aname})
+	}
+	return c, nil
+}
+
+// Mounts returns the file trees attached with c.Attach,
+// or cloned by CloneConn, that have not been closed.
+func (c *Conn) Mounts() []*Fsys {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pruneMounts()
+	return append([]*Fsys(nil), c.mounts...)
+}
+
+// pruneMounts drops closed file trees from c.mounts.
+// c.mu must be held.
+func (c *Conn) pruneMounts() {
+	live := c.mounts[:0]
+	for _, fs := range c.mounts {
+		if _, err := fs.root.conn(); err == nil {
+			live = append(live, fs)
+		}
+	}
+	for i := len(live); i < len(c.mounts); i++ {
+		c.mounts[i] = nil
+	}
+	c.mounts = live
+}
+
 type conn struct {
 	rwc      io.ReadWriteCloser
 	err      error
 	tagmap   map[uint16]chan *plan9.Fcall
 	freetag  map[uint16]bool
-	freefid  map[uint32]bool
+	flushes  map[uint16]uint16 // tag of a pending Tflush -> its oldtag
+	held     map[uint16]bool   // tags not to be freed on reply; see rpcContext
+	freefid  []uint32          // clunked fid numbers, oldest first
+	maxfree  int               // maximum len(freefid)
+	maxwelem int               // maximum names per Twalk
 	nexttag  uint16
 	nextfid  uint32
 	msize    uint32
 	version  string
+	dialect  plan9.Dialect // wire format for version
 	w, x     sync.Mutex
 	muxer    bool
-	refCount int32 // atomic
+	refCount int32         // atomic
+	nfid     int32         // atomic; number of live Fids
+	fids     map[*Fid]bool // live Fids, for the fid reaper
+	breaker  *breaker      // see WithCircuitBreaker
+	inflight int           // number of calls in rpc
+	idle     chan struct{} // closed when inflight == 0
+	name     string        // see Conn.SetName
+	quiesce  sync.RWMutex  // held for reading while sending; see Conn.Quiesce
+
+	unmatched func(*plan9.Fcall) error   // see Conn.SetUnmatchedReplyHandler
+	alloc     func(op string, bytes int) // see WithAllocTracer
+	trace     func(*plan9.Fcall)         // see WithTracer
+	sanitize  plan9.SanitizePolicy       // see WithSanitize
+	reqErrs   bool                       // see WithRequestErrors
+	logger    *log.Logger                // see WithLogger
+}
+
+// A DialOption configures a Conn created by Dial or NewConn.
+type DialOption func(*conn)
+
+// WithAllocTracer returns a DialOption that calls fn for each significant
+// allocation the connection makes, with the name of the operation and
+// the number of bytes allocated:
+//
+//	"decode"  a buffer for an incoming message
+//	"encode"  a buffer for an outgoing message
+//	"walk"    the Wname slice for a walk
+//	"read"    a buffer for file data returned by a Fsys method
+//
+// The counts are of the buffers themselves, not of every allocation
+// made on their behalf. fn is called synchronously, often with internal
+// locks held, so it must be fast and must not use the connection.
+// Callers that want to report asynchronously should buffer inside fn.
+func WithAllocTracer(fn func(op string, bytes int)) DialOption {
+	return func(c *conn) { c.alloc = fn }
+}
+
+// WithTracer returns a DialOption that calls fn with each message
+// the connection sends or receives, starting with the Tversion, for
+// protocol traces; plan9.NewTracer makes a suitable fn. Like the fn of
+// WithAllocTracer, it is called synchronously, often with internal
+// locks held, and must not use the connection or keep the Fcall.
+// To keep credentials out of the trace, use WithSanitize too.
+func WithTracer(fn func(*plan9.Fcall)) DialOption {
+	return func(c *conn) { c.trace = fn }
 }
 
-func NewConn(rwc io.ReadWriteCloser) (*Conn, error) {
+// WithSanitize returns a DialOption that makes the connection
+// sanitize each message it logs, with plan9.Fcall.Sanitize and
+// the given policy: those passed to the fn of WithTracer and
+// the replies it logs for matching no request.
+// The messages on the wire, and those passed to the handler of
+// Conn.SetUnmatchedReplyHandler, are left alone.
+func WithSanitize(policy plan9.SanitizePolicy) DialOption {
+	return func(c *conn) { c.sanitize = policy }
+}
+
+// sanitized returns f as the connection should log it.
+func (c *conn) sanitized(f *plan9.Fcall) *plan9.Fcall {
+	if c.sanitize == 0 {
+		return f
+	}
+	return f.Sanitize(c.sanitize)
+}
+
+// WithUnixExtensions returns a DialOption that proposes the 9P2000.u
+// protocol, with its Unix extensions, instead of 9P2000. If the server
+// answers with plain 9P2000, the connection silently uses that instead;
+// Conn.Version reports which was negotiated. Over 9P2000.u, Dirs carry
+// the numeric ids and extension string of the Unix extensions,
+// and Fsys.Symlink and Fsys.Readlink are available.
+func WithUnixExtensions() DialOption {
+	return func(c *conn) { c.version = "9P2000.u" }
+}
+
+// WithMsize returns a DialOption that proposes a maximum message size
+// of n bytes, instead of 131072, in the Tversion that opens the
+// connection. The connection uses the smaller of n and the size the
+// server answers with, which Conn.Msize reports, and splits each read
+// and write into messages of at most that size.
+// NewConn fails if n leaves no room for data beyond a message header.
+func WithMsize(n uint32) DialOption {
+	return func(c *conn) { c.msize = n }
+}
+
+// WithLogger returns a DialOption that makes the connection
+// log events of interest, such as fids reaped by StartFidReaper, to l.
+func WithLogger(l *log.Logger) DialOption {
+	return func(c *conn) { c.logger = l }
+}
+
+// WithRequestErrors returns a DialOption that makes the connection
+// return each error reply from the server as a *RequestError,
+// recording the request that drew it. The path of the file involved
+// is included when the request was made with a context carrying one;
+// see WithRequestPath.
+func WithRequestErrors() DialOption {
+	return func(c *conn) { c.reqErrs = true }
+}
+
+type requestPathKey struct{}
+
+// WithRequestPath returns a copy of ctx that records path as the name
+// of the file that requests made with it refer to, for the
+// *RequestError reported by a connection using WithRequestErrors.
+func WithRequestPath(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, requestPathKey{}, path)
+}
+
+// traceAlloc reports an allocation to the tracer set by WithAllocTracer.
+func (c *conn) traceAlloc(op string, n int) {
+	if c.alloc != nil {
+		c.alloc(op, n)
+	}
+}
+
+func NewConn(rwc io.ReadWriteCloser, opts ...DialOption) (*Conn, error) {
 	c := &conn{
 		rwc:      rwc,
 		tagmap:   make(map[uint16]chan *plan9.Fcall),
 		freetag:  make(map[uint16]bool),
-		freefid:  make(map[uint32]bool),
+		flushes:  make(map[uint16]uint16),
+		held:     make(map[uint16]bool),
+		fids:     make(map[*Fid]bool),
+		maxfree:  DefaultFreeFidLimit,
+		maxwelem: plan9.MAXWELEM,
 		nexttag:  1,
 		nextfid:  1,
 		msize:    131072,
 		version:  "9P2000",
 		refCount: 1,
+		idle:     make(chan struct{}),
+	}
+	close(c.idle)
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.msize <= plan9.IOHDRSZ {
+		return nil, fmt.Errorf("msize %d too small", c.msize)
 	}
 
 	//	XXX raw messages, not c.rpc
@@ -116,37 +475,154 @@ func NewConn(rwc io.ReadWriteCloser) (*Conn, error) {
 		return nil, plan9.ProtocolError(fmt.Sprintf("invalid type/tag in Tversion exchange: %v %v", rx.Type, rx.Tag))
 	}
 
-	if rx.Msize > c.msize {
+	if rx.Msize > c.msize || rx.Msize <= plan9.IOHDRSZ {
 		return nil, plan9.ProtocolError(fmt.Sprintf("invalid msize %d in Rversion", rx.Msize))
 	}
 	c.msize = rx.Msize
-	if rx.Version != "9P2000" {
+	if rx.Version != "9P2000" && rx.Version != c.version {
 		return nil, plan9.ProtocolError(fmt.Sprintf("invalid version %s in Rversion", rx.Version))
 	}
+	c.version = rx.Version
+	c.dialect = plan9.DialectOf(c.version)
 	return &Conn{
-		_c: c,
+		_c:   c,
+		base: c,
 	}, nil
 }
 
+// DefaultFreeFidLimit is the default limit set by SetFreeFidLimit.
+const DefaultFreeFidLimit = 1024
+
+// SetFreeFidLimit sets the maximum number of clunked fid numbers
+// that c remembers for reuse, bounding the memory used by programs
+// that allocate and clunk many fids.
+// Fid numbers are reused in the order they were clunked;
+// once n numbers are waiting, further clunked numbers are forgotten
+// and new fids are given numbers never used before.
+// A limit of zero disables reuse entirely.
+func (c *Conn) SetFreeFidLimit(n int) error {
+	conn, err := c.conn()
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		n = 0
+	}
+	conn.x.Lock()
+	defer conn.x.Unlock()
+	conn.maxfree = n
+	if len(conn.freefid) > n {
+		conn.freefid = conn.freefid[:n]
+	}
+	return nil
+}
+
+// SetMaxWalkElem sets the maximum number of path elements c sends
+// in a single Twalk, for servers that accept fewer than the protocol's
+// limit of plan9.MAXWELEM, which is the default. Longer paths are
+// walked in several steps.
+func (c *Conn) SetMaxWalkElem(n int) error {
+	conn, err := c.conn()
+	if err != nil {
+		return err
+	}
+	if n < 1 || n > plan9.MAXWELEM {
+		return fmt.Errorf("invalid walk element limit %d", n)
+	}
+	conn.x.Lock()
+	defer conn.x.Unlock()
+	conn.maxwelem = n
+	return nil
+}
+
 func (c *conn) newFid(fid uint32, qid plan9.Qid) *Fid {
 	c.acquire()
-	return &Fid{
+	atomic.AddInt32(&c.nfid, 1)
+	f := &Fid{
 		_c:  c,
 		fid: fid,
 		qid: qid,
 	}
+	f.lastUse.Store(time.Now().UnixNano())
+	c.x.Lock()
+	c.fids[f] = true
+	c.x.Unlock()
+	return f
+}
+
+// forgetFid removes a clunked fid from the set of live Fids.
+func (c *conn) forgetFid(fid *Fid) {
+	c.x.Lock()
+	delete(c.fids, fid)
+	c.x.Unlock()
+}
+
+// StartFidReaper starts a goroutine that, until ctx is done or the
+// connection fails, clunks the Fids that have not been used, for
+// reading, writing, statting or anything else, for at least maxIdle.
+// It is a backstop for long-running programs that lose track of Fids
+// on early returns or in abandoned goroutines; a reaped Fid behaves
+// as if closed. Fids with an operation in progress are never reaped,
+// nor are the roots of file trees from Attach and the Fids from Auth.
+// The reaper logs each Fid it clunks to the logger set by WithLogger.
+func (c *Conn) StartFidReaper(ctx context.Context, maxIdle time.Duration) {
+	conn := c.base
+	go func() {
+		t := time.NewTicker(max(maxIdle/2, time.Millisecond))
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			if conn.getErr() != nil {
+				return
+			}
+			conn.reapIdle(maxIdle)
+		}
+	}()
+}
+
+// reapIdle clunks the Fids that have been idle for maxIdle.
+func (c *conn) reapIdle(maxIdle time.Duration) {
+	cutoff := time.Now().Add(-maxIdle).UnixNano()
+	var idle []*Fid
+	c.x.Lock()
+	for fid := range c.fids {
+		// Once busy is -1, use fails, so no operation can
+		// start on the fid while it is being clunked.
+		if !fid.noReap.Load() && fid.lastUse.Load() < cutoff && fid.busy.CompareAndSwap(0, -1) {
+			idle = append(idle, fid)
+		}
+	}
+	c.x.Unlock()
+
+	for _, fid := range idle {
+		idleFor := time.Since(time.Unix(0, fid.lastUse.Load())).Round(time.Millisecond)
+		_, err := c.rpc(&plan9.Fcall{Type: plan9.Tclunk, Fid: fid.fid}, fid)
+		if c.logger != nil {
+			if err != nil {
+				c.logger.Printf("9P: reaping fid %d idle for %v: %v", fid.fid, idleFor, err)
+			} else {
+				c.logger.Printf("9P: reaped fid %d idle for %v", fid.fid, idleFor)
+			}
+		}
+	}
 }
 
 func (c *conn) newfidnum() (uint32, error) {
 	c.x.Lock()
 	defer c.x.Unlock()
-	for fidnum := range c.freefid {
-		delete(c.freefid, fidnum)
+	if len(c.freefid) > 0 {
+		fidnum := c.freefid[0]
+		c.freefid = c.freefid[1:]
 		return fidnum, nil
 	}
 	fidnum := c.nextfid
 	if c.nextfid == plan9.NOFID {
-		return 0, plan9.ProtocolError("out of fids")
+		return 0, fmt.Errorf("%w: %d fids in use (see Conn.CurrentFidCount); are Fids being closed?",
+			ErrFidExhausted, atomic.LoadInt32(&c.nfid))
 	}
 	c.nextfid++
 	return fidnum, nil
@@ -155,7 +631,17 @@ func (c *conn) newfidnum() (uint32, error) {
 func (c *conn) putfidnum(fid uint32) {
 	c.x.Lock()
 	defer c.x.Unlock()
-	c.freefid[fid] = true
+	if len(c.freefid) < c.maxfree {
+		c.freefid = append(c.freefid, fid)
+	}
+}
+
+// newReplyChan returns a channel on which an rpc waits for its reply.
+// The muxer delivers at most one message on it, either the reply or
+// the muxer role, and the channel has room for that message, so the
+// muxer never waits for a slow caller before reading the next reply.
+func newReplyChan() chan *plan9.Fcall {
+	return make(chan *plan9.Fcall, 1)
 }
 
 func (c *conn) newtag(ch chan *plan9.Fcall) (uint16, error) {
@@ -189,31 +675,101 @@ func (c *conn) puttag(tag uint16) chan *plan9.Fcall {
 	return ch
 }
 
-func (c *conn) mux(rx *plan9.Fcall) {
+// mux delivers rx to the rpc waiting for it and passes on the
+// muxer role. If no rpc is waiting for rx, mux consults the
+// unmatched reply handler and returns its error, if any.
+func (c *conn) mux(rx *plan9.Fcall) error {
 	c.x.Lock()
+	ch, ok := c.tagmap[rx.Tag]
+	if !ok {
+		fn := c.unmatched
+		c.x.Unlock()
+		if fn == nil {
+			fn = c.logUnmatched
+		}
+		if err := fn(rx); err != nil {
+			return err
+		}
+		c.x.Lock()
+	} else {
+		delete(c.tagmap, rx.Tag)
+		if !c.held[rx.Tag] {
+			c.freetag[rx.Tag] = true
+		}
+		if old, ok := c.flushes[rx.Tag]; ok {
+			// The server will not answer the flushed request now,
+			// so wake its rpc and let its tag be reused.
+			delete(c.flushes, rx.Tag)
+			if och, ok := c.tagmap[old]; ok {
+				delete(c.tagmap, old)
+				och <- &flushedReply
+			}
+			delete(c.held, old)
+			c.freetag[old] = true
+		}
+	}
 	defer c.x.Unlock()
 
-	ch := c.tagmap[rx.Tag]
-	delete(c.tagmap, rx.Tag)
-	c.freetag[rx.Tag] = true
 	c.muxer = false
 	for _, ch2 := range c.tagmap {
 		c.muxer = true
 		ch2 <- &yourTurn
 		break
 	}
-	ch <- rx
+	if ok {
+		ch <- rx
+	}
+	return nil
+}
+
+// fail records err as the connection's error and wakes every rpc
+// waiting for a reply, since no more replies will be read.
+// An rpc whose channel already holds its turn to read is not
+// woken but finds the error when it reads, in rpcContext.
+// The muxer role stays with the rpc holding it, which leaves
+// rpcContext on its next read, so that no second muxer starts.
+func (c *conn) fail(err error) {
+	c.setErr(err)
+	c.x.Lock()
+	defer c.x.Unlock()
+	for tag, ch := range c.tagmap {
+		delete(c.tagmap, tag)
+		select {
+		case ch <- nil:
+		default:
+		}
+	}
+	clear(c.flushes)
+	clear(c.held)
 }
 
 func (c *conn) read() (*plan9.Fcall, error) {
 	if err := c.getErr(); err != nil {
 		return nil, err
 	}
-	f, err := plan9.ReadFcall(c.rwc)
+	r := io.Reader(c.rwc)
+	var cr *countReader
+	if c.alloc != nil {
+		cr = &countReader{r: r}
+		r = cr
+	}
+	f, err := plan9.ReadFcallDialect(r, c.dialect)
+	if cr != nil {
+		c.traceAlloc("decode", int(cr.n))
+	}
+	if err == nil && f.Type == plan9.Ropenfd {
+		// The descriptor follows the message; see Fsys.OpenFD.
+		var fd int
+		fd, err = recvFD(c.rwc)
+		f.Unixfd = uint32(fd)
+	}
 	if err != nil {
 		c.setErr(err)
 		return nil, err
 	}
+	if c.trace != nil {
+		c.trace(c.sanitized(f))
+	}
 	return f, nil
 }
 
@@ -221,21 +777,114 @@ func (c *conn) write(f *plan9.Fcall) error {
 	if err := c.getErr(); err != nil {
 		return err
 	}
-	err := plan9.WriteFcall(c.rwc, f)
+	b, err := f.BytesDialect(c.dialect)
 	if err != nil {
-		c.setErr(err)
+		return err
+	}
+	if c.trace != nil {
+		c.trace(c.sanitized(f))
+	}
+	c.traceAlloc("encode", len(b))
+	_, err = c.rwc.Write(b)
+	if err != nil {
+		// A connection that cannot be written is no use even if it
+		// can still be read: fail the rpcs waiting for replies and
+		// close rwc to stop the read the muxer may be blocked in.
+		c.fail(err)
+		c.rwc.Close()
 	}
 	return err
 }
 
+// A countReader counts the bytes read through it.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.n += int64(n)
+	return n, err
+}
+
 var yourTurn plan9.Fcall
 
+// flushedReply is delivered to an rpc whose request has been
+// flushed, in place of the reply the server will now never send.
+var flushedReply plan9.Fcall
+
+// beginRPC and endRPC track the number of calls in rpc for Wait.
+func (c *conn) beginRPC() {
+	c.x.Lock()
+	defer c.x.Unlock()
+	if c.inflight == 0 {
+		c.idle = make(chan struct{})
+	}
+	c.inflight++
+}
+
+func (c *conn) endRPC() {
+	c.x.Lock()
+	defer c.x.Unlock()
+	c.inflight--
+	if c.inflight == 0 {
+		close(c.idle)
+	}
+}
+
 func (c *conn) rpc(tx *plan9.Fcall, clunkFid *Fid) (rx *plan9.Fcall, err error) {
-	ch := make(chan *plan9.Fcall, 1)
+	return c.rpcContext(context.Background(), tx, clunkFid)
+}
+
+// rpcContext is like rpc but gives up when ctx is done. If the request
+// has been sent by then, rpcContext flushes it and returns ctx.Err()
+// only if the server confirms the request was abandoned; if the reply
+// arrives first, rpcContext returns it as usual, so that the caller
+// can account for whatever the request did.
+//
+// The tag of a request that might be flushed is held: it is not freed
+// when the reply arrives, since a Tflush naming it may be about to be
+// sent and must not reach a later request given the same tag.
+// rpcContext frees the tag itself if no flush was started;
+// otherwise it is freed once the flush is answered.
+func (c *conn) rpcContext(ctx context.Context, tx *plan9.Fcall, clunkFid *Fid) (rx *plan9.Fcall, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			err = c.nameErr(err)
+		}
+	}()
+	if err := c.breaker.allow(ctx, tx); err != nil {
+		return nil, err
+	}
+	defer func() { c.breaker.record(ctx, tx, err) }()
+
+	// Wait out any Quiesce before sending.
+	c.quiesce.RLock()
+	c.beginRPC()
+	defer c.endRPC()
+
+	ch := newReplyChan()
 	tx.Tag, err = c.newtag(ch)
 	if err != nil {
+		c.quiesce.RUnlock()
 		return nil, err
 	}
+	cancelable := ctx.Done() != nil
+	isFlush := tx.Type == plan9.Tflush && tx.Oldtag != plan9.NOTAG
+	if cancelable || isFlush {
+		c.x.Lock()
+		if cancelable {
+			c.held[tx.Tag] = true
+		}
+		if isFlush {
+			c.flushes[tx.Tag] = tx.Oldtag
+		}
+		c.x.Unlock()
+	}
 	if clunkFid != nil {
 		// Closing the Fid might release the conn, which would close the
 		// underlying rwc connection and prevent us from receiving the
@@ -246,24 +895,46 @@ func (c *conn) rpc(tx *plan9.Fcall, clunkFid *Fid) (rx *plan9.Fcall, err error)
 	c.w.Lock()
 	err = c.write(tx)
 	c.w.Unlock()
+	c.quiesce.RUnlock()
 	if err != nil {
 		return nil, err
 	}
+	stop := func() bool { return false }
+	if cancelable {
+		tag := tx.Tag
+		stop = context.AfterFunc(ctx, func() { c.flush(tag) })
+	}
 
 	for rx = range ch {
 		if rx != &yourTurn {
 			break
 		}
 		rx, err = c.read()
+		if err == nil {
+			err = c.mux(rx)
+		}
 		if err != nil {
+			// Wake the others. fail cannot be relied on to wake
+			// us too, so leave the loop and return the error.
+			c.fail(err)
+			rx = nil
 			break
 		}
-		c.mux(rx)
+	}
+
+	if cancelable && stop() {
+		c.x.Lock()
+		delete(c.held, tx.Tag)
+		c.freetag[tx.Tag] = true
+		c.x.Unlock()
 	}
 
 	if rx == nil {
 		return nil, c.getErr()
 	}
+	if rx == &flushedReply {
+		return nil, ctx.Err()
+	}
 	if clunkFid != nil {
 		// Recycle the fid number only after the server has responded
 		// to the Tclunk.  Proxy servers (e.g. 9pserve/acme) keep the
@@ -280,6 +951,14 @@ func (c *conn) rpc(tx *plan9.Fcall, clunkFid *Fid) (rx *plan9.Fcall, err error)
 		}
 	}
 	if rx.Type == plan9.Rerror {
+		if c.reqErrs {
+			path, _ := ctx.Value(requestPathKey{}).(string)
+			fid := tx.Fid
+			if tx.Type == plan9.Tauth {
+				fid = tx.Afid
+			}
+			return nil, &RequestError{Type: tx.Type, Fid: fid, Path: path, Err: Error(rx.Ename)}
+		}
 		return nil, Error(rx.Ename)
 	}
 	if rx.Type != tx.Type+1 {
@@ -288,6 +967,34 @@ func (c *conn) rpc(tx *plan9.Fcall, clunkFid *Fid) (rx *plan9.Fcall, err error)
 	return rx, nil
 }
 
+// flush sends a Tflush for the held tag of an rpcContext whose
+// context is done. If the flush fails, the tag is no longer held,
+// and is freed when the original reply arrives, if it has not already.
+func (c *conn) flush(tag uint16) {
+	if _, err := c.rpc(&plan9.Fcall{Type: plan9.Tflush, Oldtag: tag}, nil); err == nil {
+		return
+	}
+	c.x.Lock()
+	defer c.x.Unlock()
+	if c.held[tag] {
+		delete(c.held, tag)
+		if _, ok := c.tagmap[tag]; !ok {
+			c.freetag[tag] = true
+		}
+	}
+}
+
+// nameErr wraps err in a ConnError if c has a name.
+func (c *conn) nameErr(err error) error {
+	c.x.Lock()
+	name := c.name
+	c.x.Unlock()
+	if name == "" {
+		return err
+	}
+	return &ConnError{Name: name, Err: err}
+}
+
 func (c *conn) acquire() {
 	atomic.AddInt32(&c.refCount, 1)
 }
@@ -307,8 +1014,12 @@ func (c *conn) getErr() error {
 	return c.err
 }
 
+// setErr records err as the reason the connection no longer works,
+// unless an earlier reason has been recorded already.
 func (c *conn) setErr(err error) {
 	c.x.Lock()
 	defer c.x.Unlock()
-	c.err = err
+	if c.err == nil {
+		c.err = err
+	}
 }
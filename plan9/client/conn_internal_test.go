@@ -0,0 +1,134 @@
+//go:build !plan9
+
+package client
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/srv9p"
+)
+
+func TestFreeFidLimit(t *testing.T) {
+	c := &conn{nextfid: 1, maxfree: 2}
+	var fids []uint32
+	for i := 0; i < 5; i++ {
+		fid, err := c.newfidnum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fids = append(fids, fid)
+	}
+	for _, fid := range fids {
+		c.putfidnum(fid)
+	}
+	if len(c.freefid) != 2 {
+		t.Fatalf("free list holds %d fids, want 2", len(c.freefid))
+	}
+
+	// The first two clunked numbers are reused, in order,
+	// and allocation then continues past the highest number used.
+	var got []uint32
+	for i := 0; i < 3; i++ {
+		fid, err := c.newfidnum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fid)
+	}
+	if want := []uint32{1, 2, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("allocated fids %v, want %v", got, want)
+	}
+}
+
+func TestFidExhausted(t *testing.T) {
+	c := &conn{nextfid: plan9.NOFID - 1, maxfree: DefaultFreeFidLimit}
+	if _, err := c.newfidnum(); err != nil {
+		t.Fatalf("allocating last fid: %v", err)
+	}
+	_, err := c.newfidnum()
+	if !errors.Is(err, ErrFidExhausted) {
+		t.Fatalf("newfidnum after last fid = %v, want ErrFidExhausted", err)
+	}
+	if !errors.Is(Error("Rerror: no free fids"), ErrFidExhausted) {
+		t.Errorf("server error does not match ErrFidExhausted")
+	}
+}
+
+// TestSlowReceiver checks that a caller slow to collect its reply
+// does not hold up replies to other callers.
+func TestSlowReceiver(t *testing.T) {
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c1.Close(); c2.Close() })
+	go (&srv9p.Server{Tree: srv9p.NewTree("slow", "slow", plan9.DMDIR|0777, nil)}).Serve(c2, c2)
+	conn, err := NewConn(c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := conn._c
+
+	// Act as the muxer so that the slow caller, which never reads
+	// its channel, is not handed the muxer role.
+	c.x.Lock()
+	c.muxer = true
+	c.x.Unlock()
+	slow := newReplyChan()
+	tag, err := c.newtag(slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.write(&plan9.Fcall{Type: plan9.Tflush, Tag: tag, Oldtag: plan9.NOTAG}); err != nil {
+		t.Fatal(err)
+	}
+
+	rx, err := c.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rx.Tag != tag {
+		t.Fatalf("reply has tag %d, want %d", rx.Tag, tag)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.rpc(&plan9.Fcall{Type: plan9.Tflush, Oldtag: plan9.NOTAG}, nil)
+		done <- err
+	}()
+	for {
+		c.x.Lock()
+		n := len(c.tagmap)
+		c.x.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Delivering the slow caller's reply must not block,
+	// and must pass the muxer role on to the other rpc.
+	muxed := make(chan error, 1)
+	go func() { muxed <- c.mux(rx) }()
+	select {
+	case err := <-muxed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("mux blocked delivering to slow caller")
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("rpc did not complete while slow caller held its reply")
+	}
+	if rx := <-slow; rx == nil || rx.Type != plan9.Rflush {
+		t.Errorf("slow caller received %v, want Rflush", rx)
+	}
+}
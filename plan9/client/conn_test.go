@@ -1,13 +1,22 @@
 package client_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"9fans.net/go/plan9"
 	"9fans.net/go/plan9/client"
+	"9fans.net/go/plan9/srv9p"
 )
 
 // proxyServer simulates a 9P proxy (like 9pserve/acme): fids are entered
@@ -25,6 +34,7 @@ type proxyServer struct {
 	fmu        sync.Mutex
 	fids       map[uint32]bool
 	clunkDelay time.Duration
+	openErr    string // if set, the Rerror for every Topen
 
 	// clunkSeen is closed the moment the server reads a Tclunk.
 	clunkSeen chan struct{}
@@ -77,6 +87,11 @@ func (s *proxyServer) serve() {
 			s.send(&plan9.Fcall{Type: plan9.Rwalk, Tag: f.Tag, Wqid: wqid})
 
 		case plan9.Topen:
+			if s.openErr != "" {
+				s.send(&plan9.Fcall{Type: plan9.Rerror, Tag: f.Tag,
+					Ename: s.openErr})
+				continue
+			}
 			s.send(&plan9.Fcall{Type: plan9.Ropen, Tag: f.Tag,
 				Qid: plan9.Qid{Type: plan9.QTFILE, Path: 1}})
 
@@ -123,6 +138,47 @@ func (s *proxyServer) read() *plan9.Fcall {
 
 func (s *proxyServer) send(f *plan9.Fcall) { s.out <- f }
 
+// TestOpenFailureClunksWalkFid checks that when Topen fails after
+// a successful Twalk, Fsys.Open clunks the walked fid before
+// returning the error, so that the server does not leak it.
+func TestOpenFailureClunksWalkFid(t *testing.T) {
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c1.Close(); c2.Close() })
+
+	srv := &proxyServer{
+		conn:      c2,
+		out:       make(chan *plan9.Fcall, 64),
+		fids:      make(map[uint32]bool),
+		openErr:   "permission denied",
+		clunkSeen: make(chan struct{}),
+	}
+	go srv.serve()
+
+	conn, err := client.NewConn(c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := conn.Attach(nil, "nobody", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := fs.Open("file", plan9.OREAD); !errors.Is(err, client.ErrPermission) {
+			t.Fatalf("Open = %v, want permission denied", err)
+		}
+		srv.fmu.Lock()
+		n := len(srv.fids)
+		srv.fmu.Unlock()
+		if n != 1 {
+			t.Fatalf("after failed open %d, server holds %d fids, want only the root", i, n)
+		}
+	}
+	if n := conn.CurrentFidCount(); n != 1 {
+		t.Errorf("client holds %d fids, want only the root", n)
+	}
+}
+
 // TestFidRecycle is a regression test for a bug where rpc() recycled a fid
 // number into freefid as soon as Tclunk was written to the wire, before
 // the server's Rclunk was received.  Proxy servers such as 9pserve keep
@@ -197,3 +253,758 @@ func TestFidRecycle(t *testing.T) {
 			"fid number recycled before server Rclunk", dupFids, rounds)
 	}
 }
+
+func TestCurrentFidCount(t *testing.T) {
+	conn := newRAMConn(t)
+	check := func(when string, want int) {
+		t.Helper()
+		if n := conn.CurrentFidCount(); n != want {
+			t.Errorf("%s: CurrentFidCount() = %d, want %d", when, n, want)
+		}
+		if n := conn.Stats().Fids; n != want {
+			t.Errorf("%s: Stats().Fids = %d, want %d", when, n, want)
+		}
+	}
+
+	check("before attach", 0)
+	fs, err := conn.Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("after attach", 1)
+
+	fid, err := fs.Create("file", plan9.OWRITE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("after create", 2)
+	if _, err := fs.Stat("nonexistent"); err == nil {
+		t.Fatal("stat of nonexistent file succeeded")
+	}
+	check("after failed walk", 2)
+
+	var fids []*client.Fid
+	for i := 0; i < 3; i++ {
+		f, err := fs.Open("file", plan9.OREAD)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fids = append(fids, f)
+	}
+	check("after walks", 5)
+	for _, f := range fids {
+		f.Close()
+	}
+	check("after clunks", 2)
+
+	fid.Close()
+	fs.Close()
+	check("after closing all", 0)
+}
+
+func TestWait(t *testing.T) {
+	release := make(chan struct{})
+	tree := srv9p.NewTree("slow", "slow", plan9.DMDIR|0777, nil)
+	if _, err := tree.Root.Create("slow", "slow", 0444, nil); err != nil {
+		t.Fatal(err)
+	}
+	srv := &srv9p.Server{
+		Tree: tree,
+		Read: func(ctx context.Context, fid *srv9p.Fid, data []byte, offset int64) (int, error) {
+			<-release
+			return 0, nil
+		},
+	}
+	conn := serveConn(t, srv)
+	if err := conn.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait on idle conn: %v", err)
+	}
+	fs, err := conn.Attach(nil, "slow", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid, err := fs.Open("slow", plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		fid.Read(make([]byte, 10))
+		done <- true
+	}()
+	// Wait for the Tread to be in flight.
+	for conn.Wait(timeoutContext(t, 0)) == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := conn.Wait(timeoutContext(t, 10*time.Millisecond)); err != context.DeadlineExceeded {
+		t.Fatalf("Wait with read in flight = %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(release)
+	if err := conn.Wait(timeoutContext(t, 5*time.Second)); err != nil {
+		t.Fatalf("Wait after read finished: %v", err)
+	}
+	<-done
+}
+
+func timeoutContext(t *testing.T, d time.Duration) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestQuiesce(t *testing.T) {
+	conn := newRAMConn(t)
+	fs, err := conn.Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Quiesce()
+	done := make(chan error)
+	go func() {
+		_, err := fs.Stat("/")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Stat completed while quiesced: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := conn.Wait(timeoutContext(t, time.Second)); err != nil {
+		t.Fatalf("Wait while quiesced: %v", err)
+	}
+
+	conn.Unquiesce()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Stat after Unquiesce: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stat still blocked after Unquiesce")
+	}
+}
+
+// spuriousServer answers Tversion and then, before replying to the
+// Tattach, sends rx, or an Rclunk if rx is nil, with a tag the client
+// never used.
+func spuriousServer(t *testing.T, rx *plan9.Fcall, opts ...client.DialOption) *client.Conn {
+	if rx == nil {
+		rx = &plan9.Fcall{Type: plan9.Rclunk}
+	}
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c1.Close(); c2.Close() })
+	go func() {
+		f, err := plan9.ReadFcall(c2)
+		if err != nil {
+			return
+		}
+		plan9.WriteFcall(c2, &plan9.Fcall{Type: plan9.Rversion, Tag: plan9.NOTAG,
+			Msize: f.Msize, Version: "9P2000"})
+		f, err = plan9.ReadFcall(c2)
+		if err != nil {
+			return
+		}
+		spurious := *rx
+		spurious.Tag = f.Tag + 100
+		plan9.WriteFcall(c2, &spurious)
+		plan9.WriteFcall(c2, &plan9.Fcall{Type: plan9.Rattach, Tag: f.Tag,
+			Qid: plan9.Qid{Type: plan9.QTDIR}})
+	}()
+	conn, err := client.NewConn(c1, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestUnmatchedReply(t *testing.T) {
+	t.Run("drop", func(t *testing.T) {
+		conn := spuriousServer(t, nil)
+		var seen []*plan9.Fcall
+		conn.SetUnmatchedReplyHandler(func(rx *plan9.Fcall) error {
+			seen = append(seen, rx)
+			return nil
+		})
+		if _, err := conn.Attach(nil, "glenda", ""); err != nil {
+			t.Fatalf("Attach: %v", err)
+		}
+		if len(seen) != 1 || seen[0].Type != plan9.Rclunk {
+			t.Fatalf("handler saw %v, want one Rclunk", seen)
+		}
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		conn := spuriousServer(t, nil)
+		conn.SetUnmatchedReplyHandler(func(rx *plan9.Fcall) error {
+			return client.ErrUnmatchedReply
+		})
+		if _, err := conn.Attach(nil, "glenda", ""); err != client.ErrUnmatchedReply {
+			t.Fatalf("Attach = %v, want ErrUnmatchedReply", err)
+		}
+		if _, err := conn.Attach(nil, "glenda", ""); err != client.ErrUnmatchedReply {
+			t.Fatalf("second Attach = %v, want ErrUnmatchedReply", err)
+		}
+	})
+}
+
+func TestPing(t *testing.T) {
+	conn := newRAMConn(t)
+	rtt, err := conn.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtt <= 0 {
+		t.Errorf("Ping = %v, want positive duration", rtt)
+	}
+	conn.Close()
+	if _, err := conn.Ping(); err == nil {
+		t.Error("Ping on closed Conn succeeded")
+	}
+}
+
+func TestAllocTracer(t *testing.T) {
+	var mu sync.Mutex
+	allocs := make(map[string]int)
+	conn := newRAMConn(t, client.WithAllocTracer(func(op string, bytes int) {
+		mu.Lock()
+		allocs[op] += bytes
+		mu.Unlock()
+	}))
+	fs, err := conn.Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeTree(t, fs, "a/", "a/b")
+	if _, err := fs.ReadFileRange("a/b", 0, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, op := range []string{"decode", "encode", "walk"} {
+		if allocs[op] == 0 {
+			t.Errorf("no %s allocations traced", op)
+		}
+	}
+	if allocs["read"] != 100 {
+		t.Errorf("traced %d bytes of read buffers, want 100", allocs["read"])
+	}
+}
+
+func TestMsize(t *testing.T) {
+	// The RAM server offers 8 KiB of data, less than the default.
+	if got, want := newRAMConn(t).Msize(), uint32(8192+plan9.IOHDRSZ); got != want {
+		t.Errorf("default Msize = %d, want the server's %d", got, want)
+	}
+
+	var mu sync.Mutex
+	biggest := 0
+	conn := newRAMConn(t, client.WithMsize(1024), client.WithAllocTracer(func(op string, bytes int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if op == "encode" || op == "decode" {
+			biggest = max(biggest, bytes)
+		}
+	}))
+	if conn.Msize() != 1024 {
+		t.Fatalf("Msize = %d, want 1024", conn.Msize())
+	}
+	fs, err := conn.Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid, err := fs.Create("f", plan9.ORDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid.Close()
+	data := bytes.Repeat([]byte("x"), 5000)
+	if _, err := fid.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := fid.ReadAt(make([]byte, len(data)), 0); got != len(data) || err != nil {
+		t.Fatalf("ReadAt = %d, %v, want %d, nil", got, err, len(data))
+	}
+	mu.Lock()
+	if biggest > 1024 {
+		t.Errorf("largest message was %d bytes, more than Msize", biggest)
+	}
+	mu.Unlock()
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	if _, err := client.NewConn(c1, client.WithMsize(plan9.IOHDRSZ)); err == nil {
+		t.Error("NewConn with msize IOHDRSZ succeeded")
+	}
+}
+
+func TestTracer(t *testing.T) {
+	var buf bytes.Buffer
+	conn := newRAMConn(t, client.WithTracer(plan9.NewTracer(&buf)))
+	if _, err := conn.Attach(nil, "ram", ""); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	want := []string{"Tversion", "Rversion", "Tattach", "Rattach"}
+	if len(lines) != len(want) {
+		t.Fatalf("trace:\n%s\nwant %d lines", buf.String(), len(want))
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, want[i]+" ") {
+			t.Errorf("trace line %d = %q, want a %s", i+1, line, want[i])
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	var buf bytes.Buffer
+	conn := newRAMConn(t, client.WithTracer(plan9.NewTracer(&buf)), client.WithSanitize(plan9.RedactCredentials))
+	fs, err := conn.Attach(nil, "glenda", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); strings.Contains(s, "glenda") || strings.Contains(s, "secret") || !strings.Contains(s, plan9.Redacted) {
+		t.Errorf("sanitized trace:\n%s", s)
+	}
+	fs.Close()
+
+	// A dropped reply is logged sanitized too.
+	buf.Reset()
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	conn = spuriousServer(t, &plan9.Fcall{Type: plan9.Rread, Data: []byte("password")}, client.WithSanitize(plan9.RedactData))
+	if _, err := conn.Attach(nil, "glenda", ""); err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); strings.Contains(s, "password") || !strings.Contains(s, "dropping reply") {
+		t.Errorf("sanitized log:\n%s", s)
+	}
+}
+
+func TestCloneConn(t *testing.T) {
+	conn := newRAMConn(t)
+	fs, err := conn.Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeTree(t, fs, "a")
+	closed, err := conn.Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	clone, err := client.CloneConn(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mounts := clone.Mounts()
+	if len(mounts) != 1 {
+		t.Fatalf("clone has %d mounts, want 1", len(mounts))
+	}
+	if n := conn.CurrentFidCount(); n != 2 {
+		t.Errorf("%d fids allocated after CloneConn, want 2", n)
+	}
+
+	fs.Close()
+	if n := len(conn.Mounts()); n != 0 {
+		t.Errorf("original has %d mounts after Close, want 0", n)
+	}
+	if _, err := mounts[0].Stat("a"); err != nil {
+		t.Errorf("Stat in clone after original closed: %v", err)
+	}
+	if _, err := clone.Attach(nil, "ram", ""); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(clone.Mounts()); n != 2 {
+		t.Errorf("clone has %d mounts after Attach, want 2", n)
+	}
+}
+
+// failWriteConn is a net.Conn whose writes fail once broken is set,
+// leaving the read side working, as with a half-open connection.
+type failWriteConn struct {
+	net.Conn
+	broken atomic.Bool
+}
+
+var errBrokenPipe = errors.New("broken pipe")
+
+func (c *failWriteConn) Write(b []byte) (int, error) {
+	if c.broken.Load() {
+		return 0, errBrokenPipe
+	}
+	return c.Conn.Write(b)
+}
+
+func TestWriteFailure(t *testing.T) {
+	opening := make(chan bool, 1)
+	srv := &srv9p.Server{
+		Tree: srv9p.NewTree("half", "half", plan9.DMDIR|0777, nil),
+		Open: func(ctx context.Context, fid *srv9p.Fid, mode uint8) error {
+			opening <- true
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+	if _, err := srv.Tree.Root.Create("stuck", "half", 0666, nil); err != nil {
+		t.Fatal(err)
+	}
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c1.Close(); c2.Close() })
+	go srv.Serve(c2, c2)
+	nc := &failWriteConn{Conn: c1}
+	conn, err := client.NewConn(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := conn.Attach(nil, "half", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending := make(chan error, 1)
+	go func() {
+		_, err := fs.Open("stuck", plan9.OREAD)
+		pending <- err
+	}()
+	<-opening
+
+	nc.broken.Store(true)
+	if _, err := fs.Stat("stuck"); !errors.Is(err, errBrokenPipe) {
+		t.Errorf("Stat with broken writes = %v, want %v", err, errBrokenPipe)
+	}
+	select {
+	case err := <-pending:
+		if !errors.Is(err, errBrokenPipe) {
+			t.Errorf("pending Open = %v, want %v", err, errBrokenPipe)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending Open still waiting after write failure")
+	}
+}
+
+// TestWriteFailureBusy checks that no rpc is left waiting when writes
+// fail while other rpcs are passing the turn to read replies.
+func TestWriteFailureBusy(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		srv := &srv9p.Server{Tree: srv9p.NewTree("busy", "busy", plan9.DMDIR|0777, nil)}
+		c1, c2 := net.Pipe()
+		go srv.Serve(c2, c2)
+		nc := &failWriteConn{Conn: c1}
+		conn, err := client.NewConn(nc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Replies to the rpcs woken by the failure are expected.
+		conn.SetUnmatchedReplyHandler(func(*plan9.Fcall) error { return nil })
+		fs, err := conn.Attach(nil, "busy", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		const n = 8
+		done := make(chan bool, n)
+		for j := 0; j < n; j++ {
+			go func() {
+				for {
+					if _, err := fs.Stat("/"); err != nil {
+						done <- true
+						return
+					}
+				}
+			}()
+		}
+		time.Sleep(time.Millisecond)
+		nc.broken.Store(true)
+		for j := 0; j < n; j++ {
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("round %d: rpc still waiting after write failure", i)
+			}
+		}
+		c1.Close()
+		c2.Close()
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	fs, err := newRAMConn(t, client.WithRequestErrors()).Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.Open("missing", plan9.OREAD)
+	var rerr *client.RequestError
+	if !errors.As(err, &rerr) || rerr.Type != plan9.Twalk || rerr.Path != "" {
+		t.Fatalf("Open(missing) = %v, want Twalk RequestError", err)
+	}
+	if !errors.Is(err, client.ErrNotExist) {
+		t.Errorf("Open(missing) = %v, does not match ErrNotExist", err)
+	}
+
+	fid, err := fs.Create("dir", plan9.OREAD, plan9.DMDIR|0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid.Close()
+	ctx := client.WithRequestPath(context.Background(), "dir")
+	_, err = fs.OpenContext(ctx, "dir", plan9.OWRITE)
+	if !errors.As(err, &rerr) || rerr.Type != plan9.Topen || rerr.Path != "dir" {
+		t.Fatalf("OpenContext(dir, OWRITE) = %v, want Topen RequestError for dir", err)
+	}
+	if want := fmt.Sprintf("plan9: Topen fid=%d path=dir: is a directory", rerr.Fid); err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+	if !errors.Is(err, client.ErrIsDir) {
+		t.Errorf("OpenContext(dir, OWRITE) = %v, does not match ErrIsDir", err)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for use by a logger and a test.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestFidReaper(t *testing.T) {
+	var logbuf syncBuffer
+	conn := newRAMConn(t, client.WithLogger(log.New(&logbuf, "", 0)))
+	fs, err := conn.Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeTree(t, fs, "idle", "active")
+	idle, err := fs.Open("idle", plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := fs.Open("active", plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer active.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn.StartFidReaper(ctx, 20*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for conn.CurrentFidCount() > 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("idle fid not reaped: %d fids live", conn.CurrentFidCount())
+		}
+		if _, err := active.Stat(); err != nil {
+			t.Fatalf("active fid: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := idle.Stat(); err == nil {
+		t.Errorf("Stat of reaped fid succeeded")
+	}
+	if _, err := fs.Stat("idle"); err != nil {
+		t.Errorf("Fsys unusable after reaping: %v", err)
+	}
+	if !strings.Contains(logbuf.String(), "reaped fid") {
+		t.Errorf("log = %q, want a reaped fid line", logbuf.String())
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	conn := newRAMConn(t, client.WithCircuitBreaker(3, 20*time.Millisecond))
+	fs, err := conn.Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeTree(t, fs, "file")
+
+	// A success resets the count of consecutive failures.
+	for _, name := range []string{"x", "x", "file", "x", "x"} {
+		if _, err := fs.Stat(name); errors.Is(err, client.ErrCircuitOpen) {
+			t.Fatalf("Stat(%s) = %v before %d consecutive failures", name, err, 3)
+		}
+	}
+	if _, err := fs.Stat("x"); errors.Is(err, client.ErrCircuitOpen) {
+		t.Fatalf("third failure: Stat = %v, want the server's error", err)
+	}
+	if _, err := fs.Stat("file"); !errors.Is(err, client.ErrCircuitOpen) {
+		t.Fatalf("Stat with circuit open = %v, want ErrCircuitOpen", err)
+	}
+
+	// The probe finds the server working again and closes the circuit.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := fs.Stat("file")
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, client.ErrCircuitOpen) || time.Now().After(deadline) {
+			t.Fatalf("Stat after probe = %v, want success", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestFlushDelayedRead checks the flush of a Tread the server sits on:
+// the canceled read waits for the Rflush, no request uses the read's
+// tag until the Rflush has been sent, and the tag is reused after it.
+func TestFlushDelayedRead(t *testing.T) {
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c1.Close(); c2.Close() })
+	var (
+		mu       sync.Mutex
+		readTag  = -1
+		flushed  bool // the Rflush has been sent
+		early    []uint16
+		tagsSeen []uint16
+		batching bool           // hold pings until npings are waiting
+		batch    []*plan9.Fcall // pings held
+	)
+	const npings = 20
+	reading := make(chan bool, 1)
+	go func() {
+		var wmu sync.Mutex
+		reply := func(rx *plan9.Fcall) {
+			wmu.Lock()
+			defer wmu.Unlock()
+			plan9.WriteFcall(c2, rx)
+		}
+		for {
+			f, err := plan9.ReadFcall(c2)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			if readTag >= 0 && f.Tag == uint16(readTag) && !flushed {
+				early = append(early, f.Tag)
+			}
+			tagsSeen = append(tagsSeen, f.Tag)
+			mu.Unlock()
+			rx := &plan9.Fcall{Type: f.Type + 1, Tag: f.Tag}
+			switch f.Type {
+			case plan9.Tversion:
+				rx.Msize, rx.Version = f.Msize, "9P2000"
+			case plan9.Tattach:
+				rx.Qid = plan9.Qid{Type: plan9.QTDIR}
+			case plan9.Twalk:
+				rx.Wqid = make([]plan9.Qid, len(f.Wname))
+			case plan9.Tread:
+				mu.Lock()
+				readTag = int(f.Tag)
+				mu.Unlock()
+				reading <- true
+				continue // never answered
+			case plan9.Tflush:
+				if f.Oldtag == plan9.NOTAG {
+					mu.Lock()
+					if batching {
+						batch = append(batch, rx)
+						if len(batch) == npings {
+							for _, rx := range batch {
+								go reply(rx)
+							}
+						}
+						mu.Unlock()
+						continue
+					}
+					mu.Unlock()
+					break
+				}
+				// Answer the flush late, letting other
+				// requests arrive in the meantime.
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					mu.Lock()
+					flushed = true
+					mu.Unlock()
+					reply(rx)
+				}()
+				continue
+			}
+			// Reply from another goroutine: net.Pipe has no
+			// buffering, and the client's muxer may be busy
+			// writing a request when the reply is ready.
+			go reply(rx)
+		}
+	}()
+	conn, err := client.NewConn(c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid, err := fs.Open("file", plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pinged := make(chan bool)
+	go func() {
+		<-reading
+		cancel()
+		// Requests made while the flush is outstanding
+		// must not be given the read's tag.
+		for i := 0; i < 10; i++ {
+			conn.Ping()
+		}
+		close(pinged)
+	}()
+	if _, err := fid.ReadContext(ctx, make([]byte, 10)); err != context.Canceled {
+		t.Fatalf("ReadContext = %v, want context.Canceled", err)
+	}
+	mu.Lock()
+	if !flushed {
+		t.Error("ReadContext returned before the Rflush")
+	}
+	mu.Unlock()
+	<-pinged
+
+	// With more pings waiting at once than there have ever been
+	// tags, every free tag is in use, including the read's.
+	mu.Lock()
+	batching = true
+	mu.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < npings; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := conn.Ping(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(early) > 0 {
+		t.Errorf("tag %d of flushed read reused before Rflush", readTag)
+	}
+	reused := false
+	for _, tag := range tagsSeen[len(tagsSeen)-npings:] {
+		reused = reused || int(tag) == readTag
+	}
+	if !reused {
+		t.Errorf("tag %d of flushed read never reused after Rflush", readTag)
+	}
+}
@@ -3,6 +3,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
@@ -10,12 +11,12 @@ import (
 	"strings"
 )
 
-func Dial(network, addr string) (*Conn, error) {
+func Dial(network, addr string, opts ...DialOption) (*Conn, error) {
 	c, err := net.Dial(network, addr)
 	if err != nil {
 		return nil, err
 	}
-	return NewConn(c)
+	return NewConn(c, opts...)
 }
 
 func DialService(service string) (*Conn, error) {
@@ -47,6 +48,51 @@ func MountService(service string) (*Fsys, error) {
 	return fsys, err
 }
 
+// MountServiceConn is like MountService but also returns the Conn
+// underlying the Fsys, for access to its connection-level settings
+// and statistics.
+func MountServiceConn(service string) (*Fsys, *Conn, error) {
+	c, err := DialService(service)
+	if err != nil {
+		return nil, nil, err
+	}
+	fsys, err := c.Attach(nil, getuser(), "")
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	return fsys, c, nil
+}
+
+// MountServiceContext is like MountService but gives up when ctx is done.
+// The context bounds the dial as well as the Tversion and Tattach
+// exchanges, so a service whose socket exists but which never
+// answers cannot block the caller forever.
+// Once MountServiceContext returns, ctx no longer affects the connection.
+func MountServiceContext(ctx context.Context, service string) (*Fsys, error) {
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "unix", Namespace()+"/"+service)
+	if err != nil {
+		return nil, err
+	}
+	// Closing nc unblocks the exchanges below if ctx is done first.
+	stop := context.AfterFunc(ctx, func() { nc.Close() })
+	c, err := NewConn(nc)
+	var fsys *Fsys
+	if err == nil {
+		fsys, err = c.Attach(nil, getuser(), "")
+	}
+	if !stop() {
+		nc.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return fsys, nil
+}
+
 func MountServiceAname(service, aname string) (*Fsys, error) {
 	c, err := DialService(service)
 	if err != nil {
@@ -0,0 +1,50 @@
+//go:build !plan9
+
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"9fans.net/go/plan9/client"
+)
+
+// TestMountServiceContextHang checks that MountServiceContext gives up
+// on a service that accepts connections but never answers Tversion.
+func TestMountServiceContextHang(t *testing.T) {
+	ns := t.TempDir()
+	t.Setenv("NAMESPACE", ns)
+	l, err := net.Listen("unix", ns+"/hang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.MountServiceContext(ctx, "hang")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("MountServiceContext = %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("MountServiceContext did not return after context expired")
+	}
+}
@@ -0,0 +1,72 @@
+//go:build !plan9
+// +build !plan9
+
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+)
+
+// DialTLS connects to the 9P server at addr using TLS, as tls.Dial does,
+// and returns a Conn running over the encrypted connection.
+//
+// If fingerprint is non-empty, the server's leaf certificate must also
+// have that SHA-256 hash of its DER encoding, or the handshake fails and
+// the connection is closed.
+// The fingerprint check is in addition to the usual verification
+// configured by config, which may be nil. To trust a self-signed
+// certificate on first use, set config.InsecureSkipVerify
+// and rely on the fingerprint alone.
+func DialTLS(network, addr string, config *tls.Config, fingerprint []byte) (*Conn, error) {
+	if config == nil {
+		config = new(tls.Config)
+	} else {
+		config = config.Clone()
+	}
+	if len(fingerprint) > 0 {
+		verify := config.VerifyConnection
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if err := checkFingerprint(cs, fingerprint); err != nil {
+				return err
+			}
+			if verify != nil {
+				return verify(cs)
+			}
+			return nil
+		}
+	}
+	c, err := tls.Dial(network, addr, config)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := NewConn(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Fingerprint returns the SHA-256 hash of the DER encoding of the leaf
+// certificate presented by the peer in cs, in the form expected by DialTLS.
+func Fingerprint(cs tls.ConnectionState) []byte {
+	if len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+	sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+	return sum[:]
+}
+
+func checkFingerprint(cs tls.ConnectionState, want []byte) error {
+	have := Fingerprint(cs)
+	if have == nil {
+		return fmt.Errorf("tls: server presented no certificate to check against fingerprint %x", want)
+	}
+	if !bytes.Equal(have, want) {
+		return fmt.Errorf("tls: server certificate fingerprint %x does not match expected %x", have, want)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+//go:build !plan9
+// +build !plan9
+
+package client_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+	"9fans.net/go/plan9/srv9p"
+)
+
+// listenTLS starts a 9P server on a TLS listener with a new
+// self-signed certificate and returns its address and the
+// certificate's fingerprint.
+func listenTLS(t *testing.T) (addr string, fingerprint []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ram"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			srv := &srv9p.Server{Tree: srv9p.NewTree("ram", "ram", plan9.DMDIR|0777, nil)}
+			go func() {
+				defer c.Close()
+				srv.Serve(c, c)
+			}()
+		}
+	}()
+	sum := sha256.Sum256(der)
+	return l.Addr().String(), sum[:]
+}
+
+func TestDialTLS(t *testing.T) {
+	addr, fingerprint := listenTLS(t)
+	config := &tls.Config{InsecureSkipVerify: true}
+
+	conn, err := client.DialTLS("tcp", addr, config, fingerprint)
+	if err != nil {
+		t.Fatalf("DialTLS with matching fingerprint: %v", err)
+	}
+	defer conn.Close()
+	fs, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/"); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := append([]byte(nil), fingerprint...)
+	bad[0] ^= 1
+	if conn, err := client.DialTLS("tcp", addr, config, bad); err == nil {
+		conn.Close()
+		t.Fatal("DialTLS with mismatched fingerprint succeeded")
+	} else if !strings.Contains(err.Error(), "fingerprint") {
+		t.Errorf("DialTLS with mismatched fingerprint: %v, want fingerprint error", err)
+	}
+
+	if config.VerifyConnection != nil {
+		t.Error("DialTLS modified config")
+	}
+}
@@ -0,0 +1,108 @@
+//go:build !plan9
+// +build !plan9
+
+package client
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+// Errors that an Error returned by a 9P server can match using errors.Is.
+// ErrNotExist, ErrPermission and ErrExist are the io/fs errors,
+// so that os.IsNotExist and similar functions work too.
+var (
+	ErrNotExist   = fs.ErrNotExist
+	ErrPermission = fs.ErrPermission
+	ErrExist      = fs.ErrExist
+	ErrNotDir     = errors.New("not a directory")
+	ErrIsDir      = errors.New("is a directory")
+
+	// ErrFidExhausted reports that no more fids can be allocated,
+	// either because the client has used every fid number or
+	// because the server has no room for more.
+	// It usually means a program is leaking Fids by never closing them.
+	ErrFidExhausted = errors.New("out of fids")
+
+	// ErrNotSupported reports that the server does not support
+	// an operation, such as one from a protocol extension.
+	ErrNotSupported = errors.New("operation not supported")
+
+	// ErrCircuitOpen reports that a request was not sent because
+	// the connection's circuit breaker is open; see WithCircuitBreaker.
+	ErrCircuitOpen = errors.New("circuit breaker open")
+
+	// ErrConnReset reports that a request was lost when the
+	// connection under a SelfHealingConn failed and was replaced.
+	ErrConnReset = errors.New("9P connection reset")
+)
+
+// A WalkError records a walk that failed partway along a path.
+// Its Error method returns the text of Err alone, as walk errors
+// were reported before WalkError existed.
+type WalkError struct {
+	Path   string // the path being walked
+	Walked int    // the number of path elements walked successfully
+	Err    error
+}
+
+func (e *WalkError) Error() string { return e.Err.Error() }
+
+func (e *WalkError) Unwrap() error { return e.Err }
+
+type errorMapping struct {
+	substr string
+	target error
+}
+
+var errorMap struct {
+	sync.RWMutex
+	list []errorMapping
+}
+
+func init() {
+	for _, m := range []errorMapping{
+		{"does not exist", ErrNotExist},
+		{"not found", ErrNotExist},
+		{"no such file", ErrNotExist},
+		{"permission denied", ErrPermission},
+		{"already exists", ErrExist},
+		{"file exists", ErrExist},
+		{"not a directory", ErrNotDir},
+		{"non-directory", ErrNotDir},
+		{"is a directory", ErrIsDir},
+		{"no free fids", ErrFidExhausted},
+		{"out of fids", ErrFidExhausted},
+		{"too many fids", ErrFidExhausted},
+		{"9P connection reset", ErrConnReset},
+	} {
+		RegisterError(m.substr, m.target)
+	}
+}
+
+// RegisterError arranges for any Error whose text contains substr
+// to match target when tested with errors.Is.
+// It can be used to teach the package about the error strings of
+// a particular server. The common Plan 9 and plan9port strings for
+// ErrNotExist, ErrPermission, ErrExist, ErrNotDir, ErrIsDir,
+// ErrFidExhausted and ErrConnReset are registered already.
+func RegisterError(substr string, target error) {
+	errorMap.Lock()
+	defer errorMap.Unlock()
+	errorMap.list = append(errorMap.list, errorMapping{substr, target})
+}
+
+// Is reports whether e matches target according to the mappings
+// registered with RegisterError.
+func (e Error) Is(target error) bool {
+	errorMap.RLock()
+	defer errorMap.RUnlock()
+	for _, m := range errorMap.list {
+		if m.target == target && strings.Contains(string(e), m.substr) {
+			return true
+		}
+	}
+	return false
+}
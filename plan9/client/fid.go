@@ -12,7 +12,7 @@ func (fid *Fid) Dirread() ([]*plan9.Dir, error) {
 	if err != nil {
 		return nil, err
 	}
-	return dirUnpack(buf[0:n])
+	return dirUnpack(buf[0:n], fid.dialect())
 }
 
 func (fid *Fid) Dirreadall() ([]*plan9.Dir, error) {
@@ -20,20 +20,25 @@ func (fid *Fid) Dirreadall() ([]*plan9.Dir, error) {
 	// because it assumes it can read small amounts.
 	// Plan 9 requires providing a buffer big enough for
 	// at least a single directory entry.
-	var dirs []*plan9.Dir
+	// Large directories take many reads, so collect the raw
+	// stat records until the server returns no more data
+	// and then decode them all at once.
+	var data []byte
+	buf := make([]byte, plan9.STATMAX)
 	for {
-		d, err := fid.Dirread()
-		dirs = append(dirs, d...)
+		n, err := fid.Read(buf)
+		data = append(data, buf[:n]...)
 		if err != nil {
+			dirs, derr := dirUnpack(data, fid.dialect())
 			if err == io.EOF {
-				err = nil
+				err = derr
 			}
 			return dirs, err
 		}
 	}
 }
 
-func dirUnpack(b []byte) ([]*plan9.Dir, error) {
+func dirUnpack(b []byte, dialect plan9.Dialect) ([]*plan9.Dir, error) {
 	var err error
 	dirs := make([]*plan9.Dir, 0, 10)
 	for len(b) > 0 {
@@ -47,7 +52,7 @@ func dirUnpack(b []byte) ([]*plan9.Dir, error) {
 			break
 		}
 		var d *plan9.Dir
-		d, err = plan9.UnmarshalDir(b[0 : n+2])
+		d, err = plan9.UnmarshalDirDialect(b[0:n+2], dialect)
 		if err != nil {
 			break
 		}
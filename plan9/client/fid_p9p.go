@@ -4,10 +4,14 @@
 package client
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"9fans.net/go/plan9"
 )
@@ -24,6 +28,11 @@ type Fid struct {
 	// It's nil after the Fid has been closed.
 	_c     *conn
 	offset int64
+
+	// For the fid reaper; see Conn.StartFidReaper.
+	busy    atomic.Int32 // operations in progress, or -1 once reaped
+	lastUse atomic.Int64 // time of last use, in Unix nanoseconds
+	noReap  atomic.Bool  // an Fsys root or auth fid
 }
 
 func (fid *Fid) conn() (*conn, error) {
@@ -36,15 +45,43 @@ func (fid *Fid) conn() (*conn, error) {
 	return c, nil
 }
 
+// use is conn for an operation on fid: it marks fid busy, so that the
+// fid reaper leaves it alone, until the caller calls done.
+func (fid *Fid) use() (*conn, error) {
+	for {
+		n := fid.busy.Load()
+		if n < 0 {
+			return nil, errClosed
+		}
+		if fid.busy.CompareAndSwap(n, n+1) {
+			break
+		}
+	}
+	c, err := fid.conn()
+	if err != nil {
+		fid.busy.Add(-1)
+		return nil, err
+	}
+	fid.lastUse.Store(time.Now().UnixNano())
+	return c, nil
+}
+
+// done ends an operation begun with use.
+func (fid *Fid) done() {
+	fid.lastUse.Store(time.Now().UnixNano())
+	fid.busy.Add(-1)
+}
+
 func (fid *Fid) Close() error {
 	if fid == nil {
 		// TODO why is Close allowed on a nil fid but no other operations?
 		return nil
 	}
-	conn, err := fid.conn()
+	conn, err := fid.use()
 	if err != nil {
 		return err
 	}
+	defer fid.done()
 	tx := &plan9.Fcall{Type: plan9.Tclunk, Fid: fid.fid}
 	_, err = conn.rpc(tx, fid)
 	return err
@@ -58,18 +95,27 @@ func (fid *Fid) clunked() error {
 	if fid._c == nil {
 		return errClosed
 	}
+	fid._c.forgetFid(fid)
 	fid._c.putfidnum(fid.fid)
+	atomic.AddInt32(&fid._c.nfid, -1)
 	fid._c.release()
 	fid._c = nil
 	return nil
 }
 
 func (fid *Fid) Create(name string, mode uint8, perm plan9.Perm) error {
-	conn, err := fid.conn()
+	return fid.create(name, mode, perm, "")
+}
+
+// create is Create with the 9P2000.u extension string,
+// which is sent only if the connection negotiated 9P2000.u.
+func (fid *Fid) create(name string, mode uint8, perm plan9.Perm, ext string) error {
+	conn, err := fid.use()
 	if err != nil {
 		return err
 	}
-	tx := &plan9.Fcall{Type: plan9.Tcreate, Fid: fid.fid, Name: name, Mode: mode, Perm: perm}
+	defer fid.done()
+	tx := &plan9.Fcall{Type: plan9.Tcreate, Fid: fid.fid, Name: name, Mode: mode, Perm: perm, Extension: ext}
 	rx, err := conn.rpc(tx, nil)
 	if err != nil {
 		return err
@@ -80,12 +126,17 @@ func (fid *Fid) Create(name string, mode uint8, perm plan9.Perm) error {
 }
 
 func (fid *Fid) Open(mode uint8) error {
-	conn, err := fid.conn()
+	return fid.openContext(context.Background(), mode)
+}
+
+func (fid *Fid) openContext(ctx context.Context, mode uint8) error {
+	conn, err := fid.use()
 	if err != nil {
 		return err
 	}
+	defer fid.done()
 	tx := &plan9.Fcall{Type: plan9.Topen, Fid: fid.fid, Mode: mode}
-	if _, err := conn.rpc(tx, nil); err != nil {
+	if _, err := conn.rpcContext(ctx, tx, nil); err != nil {
 		return err
 	}
 	fid.mode = mode
@@ -97,12 +148,30 @@ func (fid *Fid) Qid() plan9.Qid {
 }
 
 func (fid *Fid) Read(b []byte) (n int, err error) {
-	return fid.readAt(b, -1)
+	return fid.ReadContext(context.Background(), b)
+}
+
+// ReadContext is like Read but gives up when ctx is done. A read still
+// in progress then is flushed. If the server answered the read before
+// it saw the flush, ReadContext returns the data as though ctx had not
+// been done, advancing the Fid's offset; otherwise it returns ctx.Err()
+// and the offset is unchanged.
+func (fid *Fid) ReadContext(ctx context.Context, b []byte) (n int, err error) {
+	return fid.readAt(ctx, b, -1)
 }
 
+// ReadAt reads len(b) bytes from the file at offset, or at the Fid's
+// current offset if offset is -1, splitting the read into as many
+// Tread messages as needed. Reading at an explicit offset leaves the
+// Fid's offset alone, so that ReadAt satisfies io.ReaderAt and may be
+// called concurrently. If the file ends first, ReadAt returns the
+// bytes there were and io.EOF.
 func (fid *Fid) ReadAt(b []byte, offset int64) (n int, err error) {
+	if offset < -1 {
+		return 0, Error("negative offset")
+	}
 	for len(b) > 0 {
-		m, err := fid.readAt(b, offset)
+		m, err := fid.readAt(context.Background(), b, offset)
 		if err != nil {
 			return n, err
 		}
@@ -115,11 +184,12 @@ func (fid *Fid) ReadAt(b []byte, offset int64) (n int, err error) {
 	return n, nil
 }
 
-func (fid *Fid) readAt(b []byte, offset int64) (n int, err error) {
-	conn, err := fid.conn()
+func (fid *Fid) readAt(ctx context.Context, b []byte, offset int64) (n int, err error) {
+	conn, err := fid.use()
 	if err != nil {
 		return 0, err
 	}
+	defer fid.done()
 	msize := conn.msize - plan9.IOHDRSZ
 	n = len(b)
 	if uint32(n) > msize {
@@ -132,9 +202,9 @@ func (fid *Fid) readAt(b []byte, offset int64) (n int, err error) {
 		fid.f.Unlock()
 	}
 	tx := &plan9.Fcall{Type: plan9.Tread, Fid: fid.fid, Offset: uint64(o), Count: uint32(n)}
-	rx, err := conn.rpc(tx, nil)
+	rx, err := conn.rpcContext(ctx, tx, nil)
 	if err != nil {
-		return 0, err
+		return 0, ctxErr(ctx, err)
 	}
 	if len(rx.Data) == 0 {
 		return 0, io.EOF
@@ -148,11 +218,37 @@ func (fid *Fid) readAt(b []byte, offset int64) (n int, err error) {
 	return len(rx.Data), nil
 }
 
-func (fid *Fid) Remove() error {
+// dialect returns the dialect negotiated by fid's connection.
+func (fid *Fid) dialect() plan9.Dialect {
 	conn, err := fid.conn()
+	if err != nil {
+		return plan9.Dialect9P2000
+	}
+	return conn.dialect
+}
+
+// openfd opens fid with the plan9port Topenfd message and returns
+// the file descriptor the server passed back.
+func (fid *Fid) openfd(mode uint8) (int, error) {
+	conn, err := fid.use()
+	if err != nil {
+		return -1, err
+	}
+	defer fid.done()
+	tx := &plan9.Fcall{Type: plan9.Topenfd, Fid: fid.fid, Mode: mode}
+	rx, err := conn.rpc(tx, nil)
+	if err != nil {
+		return -1, err
+	}
+	return int(rx.Unixfd), nil
+}
+
+func (fid *Fid) Remove() error {
+	conn, err := fid.use()
 	if err != nil {
 		return err
 	}
+	defer fid.done()
 	tx := &plan9.Fcall{Type: plan9.Tremove, Fid: fid.fid}
 	_, err = conn.rpc(tx, fid)
 	return err
@@ -196,24 +292,42 @@ func (fid *Fid) Seek(n int64, whence int) (int64, error) {
 }
 
 func (fid *Fid) Stat() (*plan9.Dir, error) {
-	conn, err := fid.conn()
+	return fid.StatContext(context.Background())
+}
+
+// StatContext is like Stat but gives up when ctx is done,
+// flushing the Tstat in progress then.
+func (fid *Fid) StatContext(ctx context.Context) (*plan9.Dir, error) {
+	conn, err := fid.use()
 	if err != nil {
 		return nil, err
 	}
+	defer fid.done()
 	tx := &plan9.Fcall{Type: plan9.Tstat, Fid: fid.fid}
-	rx, err := conn.rpc(tx, nil)
+	rx, err := conn.rpcContext(ctx, tx, nil)
 	if err != nil {
-		return nil, err
+		return nil, ctxErr(ctx, err)
 	}
-	return plan9.UnmarshalDir(rx.Stat)
+	return plan9.UnmarshalDirDialect(rx.Stat, conn.dialect)
 }
 
+// stringHeaderSize is the size of a string header on 64-bit systems,
+// which WithAllocTracer counts for each element of a walk.
+const stringHeaderSize = 16
+
 // TODO(rsc): Could use ...string instead?
 func (fid *Fid) Walk(name string) (*Fid, error) {
-	conn, err := fid.conn()
+	return fid.WalkContext(context.Background(), name)
+}
+
+// WalkContext is like Walk but gives up when ctx is done, flushing
+// the Twalk in progress then. No new fid is left behind either way.
+func (fid *Fid) WalkContext(ctx context.Context, name string) (*Fid, error) {
+	conn, err := fid.use()
 	if err != nil {
 		return nil, err
 	}
+	defer fid.done()
 	wfidnum, err := conn.newfidnum()
 	if err != nil {
 		return nil, err
@@ -229,30 +343,48 @@ func (fid *Fid) Walk(name string) (*Fid, error) {
 		}
 	}
 	elem = elem[0:j]
+	conn.traceAlloc("walk", cap(elem)*stringHeaderSize)
+
+	conn.x.Lock()
+	maxwelem := conn.maxwelem
+	conn.x.Unlock()
 
 	var wfid *Fid
 	fromfidnum := fid.fid
-	for nwalk := 0; ; nwalk++ {
+	walked := 0
+	for {
 		n := len(elem)
-		if n > plan9.MAXWELEM {
-			n = plan9.MAXWELEM
+		if n > maxwelem {
+			n = maxwelem
 		}
 		tx := &plan9.Fcall{Type: plan9.Twalk, Fid: fromfidnum, Newfid: wfidnum, Wname: elem[0:n]}
-		rx, err := conn.rpc(tx, nil)
+		rx, err := conn.rpcContext(ctx, tx, nil)
 		if err == nil && len(rx.Wqid) != n {
+			walked += len(rx.Wqid)
 			err = Error("file '" + name + "' not found")
 		}
 		if err != nil {
 			if wfid != nil {
 				wfid.Close()
+			} else {
+				// The server did not create wfidnum.
+				conn.putfidnum(wfidnum)
 			}
-			return nil, err
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, &WalkError{Path: name, Walked: walked, Err: err}
+		}
+		qid := fid.qid
+		if n > 0 {
+			qid = rx.Wqid[n-1]
 		}
-		if n == 0 {
-			wfid = conn.newFid(wfidnum, fid.qid)
+		if wfid == nil {
+			wfid = conn.newFid(wfidnum, qid)
 		} else {
-			wfid = conn.newFid(wfidnum, rx.Wqid[n-1])
+			wfid.qid = qid
 		}
+		walked += n
 		elem = elem[n:]
 		if len(elem) == 0 {
 			break
@@ -263,10 +395,34 @@ func (fid *Fid) Walk(name string) (*Fid, error) {
 }
 
 func (fid *Fid) Write(b []byte) (n int, err error) {
-	return fid.WriteAt(b, -1)
+	return fid.WriteContext(context.Background(), b)
+}
+
+// WriteContext is like Write but gives up when ctx is done, flushing
+// the Twrite in progress then. It returns the number of bytes the
+// server accepted and, if that is not all of b, ctx.Err(). A Twrite
+// the server answered before it saw the flush counts as written.
+func (fid *Fid) WriteContext(ctx context.Context, b []byte) (n int, err error) {
+	return fid.writeAll(ctx, b, -1)
 }
 
+// WriteAt writes b to the file at offset, or at the Fid's current
+// offset if offset is -1, splitting it into as many Twrite messages
+// as needed.
+// If the server accepts fewer bytes than sent, WriteAt resends the
+// remainder; if the server accepts no bytes at all, WriteAt stops and
+// returns the number of bytes written so far along with io.ErrShortWrite.
+// As with ReadAt, writing at an explicit offset leaves the Fid's offset
+// alone, so that WriteAt satisfies io.WriterAt.
 func (fid *Fid) WriteAt(b []byte, offset int64) (n int, err error) {
+	if offset < -1 {
+		return 0, Error("negative offset")
+	}
+	return fid.writeAll(context.Background(), b, offset)
+}
+
+// writeAll is WriteAt and WriteContext.
+func (fid *Fid) writeAll(ctx context.Context, b []byte, offset int64) (n int, err error) {
 	conn, err := fid.conn()
 	if err != nil {
 		return 0, err
@@ -280,11 +436,14 @@ func (fid *Fid) WriteAt(b []byte, offset int64) (n int, err error) {
 		if uint32(want) > msize {
 			want = int(msize)
 		}
-		got, err := fid.writeAt(b[tot:tot+want], offset)
+		got, err := fid.writeAt(ctx, b[tot:tot+want], offset)
 		tot += got
 		if err != nil {
 			return tot, err
 		}
+		if got == 0 && want > 0 {
+			return tot, io.ErrShortWrite
+		}
 		if offset != -1 {
 			offset += int64(got)
 		}
@@ -293,11 +452,12 @@ func (fid *Fid) WriteAt(b []byte, offset int64) (n int, err error) {
 	return tot, nil
 }
 
-func (fid *Fid) writeAt(b []byte, offset int64) (n int, err error) {
-	conn, err := fid.conn()
+func (fid *Fid) writeAt(ctx context.Context, b []byte, offset int64) (n int, err error) {
+	conn, err := fid.use()
 	if err != nil {
 		return 0, err
 	}
+	defer fid.done()
 	o := offset
 	if o == -1 {
 		fid.f.Lock()
@@ -305,9 +465,12 @@ func (fid *Fid) writeAt(b []byte, offset int64) (n int, err error) {
 		fid.f.Unlock()
 	}
 	tx := &plan9.Fcall{Type: plan9.Twrite, Fid: fid.fid, Offset: uint64(o), Data: b}
-	rx, err := conn.rpc(tx, nil)
+	rx, err := conn.rpcContext(ctx, tx, nil)
 	if err != nil {
-		return 0, err
+		return 0, ctxErr(ctx, err)
+	}
+	if rx.Count > uint32(len(b)) {
+		return 0, plan9.ProtocolError(fmt.Sprintf("invalid count %d in Rwrite of %d bytes", rx.Count, len(b)))
 	}
 	if offset == -1 && rx.Count > 0 {
 		fid.f.Lock()
@@ -318,15 +481,35 @@ func (fid *Fid) writeAt(b []byte, offset int64) (n int, err error) {
 }
 
 func (fid *Fid) Wstat(d *plan9.Dir) error {
-	conn, err := fid.conn()
+	return fid.WstatContext(context.Background(), d)
+}
+
+// WstatContext is like Wstat but gives up when ctx is done,
+// flushing the Twstat in progress then.
+func (fid *Fid) WstatContext(ctx context.Context, d *plan9.Dir) error {
+	conn, err := fid.use()
 	if err != nil {
 		return err
 	}
-	b, err := d.Bytes()
+	defer fid.done()
+	b, err := d.BytesDialect(conn.dialect)
 	if err != nil {
 		return err
 	}
 	tx := &plan9.Fcall{Type: plan9.Twstat, Fid: fid.fid, Stat: b}
-	_, err = conn.rpc(tx, nil)
+	_, err = conn.rpcContext(ctx, tx, nil)
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	return nil
+}
+
+// ctxErr returns ctx.Err() in place of err, the error from a request
+// made with ctx, if ctx is done: the server may have failed the
+// request on seeing the flush rather than abandoning it.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	return err
 }
@@ -1,7 +1,17 @@
 package client
 
-import "os"
+import (
+	"os"
+
+	"9fans.net/go/plan9"
+)
 
 type Fid struct {
 	*os.File
 }
+
+// dialect returns the dialect of directory reads: the kernel's,
+// which is plain 9P2000.
+func (fid *Fid) dialect() plan9.Dialect {
+	return plan9.Dialect9P2000
+}
@@ -0,0 +1,168 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/srv9p"
+)
+
+// TestShortWrite checks the accounting when the server accepts fewer
+// bytes than were sent, using a device that takes at most 4 bytes per
+// Twrite and holds 10 bytes in total.
+func TestShortWrite(t *testing.T) {
+	const (
+		chunk    = 4
+		capacity = 10
+	)
+	var dev []byte
+	tree := srv9p.NewTree("dev", "dev", plan9.DMDIR|0777, nil)
+	if _, err := tree.Root.Create("dev", "dev", 0666, nil); err != nil {
+		t.Fatal(err)
+	}
+	srv := &srv9p.Server{
+		Tree: tree,
+		Write: func(ctx context.Context, fid *srv9p.Fid, data []byte, offset int64) (int, error) {
+			n := min(len(data), chunk, capacity-len(dev))
+			dev = append(dev, data[:n]...)
+			return n, nil
+		},
+	}
+	fs, err := serveConn(t, srv).Attach(nil, "dev", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid, err := fs.Open("dev", plan9.OWRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid.Close()
+
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	n, err := fid.Write(data)
+	if n != capacity || err != io.ErrShortWrite {
+		t.Fatalf("Write = %d, %v, want %d, %v", n, err, capacity, io.ErrShortWrite)
+	}
+	if !bytes.Equal(dev, data[:capacity]) {
+		t.Errorf("device holds %q, want %q", dev, data[:capacity])
+	}
+	if off, _ := fid.Seek(0, io.SeekCurrent); off != capacity {
+		t.Errorf("offset after short write = %d, want %d", off, capacity)
+	}
+}
+
+// TestReadWriteContext checks that ReadContext and WriteContext flush
+// a request the server is stuck on, and that the Fid works afterward.
+// TestReadAtWriteAt checks that positioned reads and writes leave the
+// Fid's offset alone and that a Fid serves as an io.ReaderAt.
+func TestReadAtWriteAt(t *testing.T) {
+	fs := newRAMFsys(t)
+	fid, err := fs.Create("f", plan9.ORDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid.Close()
+	// Larger than the message size, so that each call takes several messages.
+	data := bytes.Repeat([]byte("0123456789"), 3000)
+	if _, err := fid.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fid.WriteAt([]byte("abc"), 10); err != nil {
+		t.Fatal(err)
+	}
+	copy(data[10:], "abc")
+
+	var (
+		_ io.ReaderAt = fid
+		_ io.WriterAt = fid
+	)
+	if off, err := fid.Seek(0, io.SeekCurrent); off != 0 || err != nil {
+		t.Fatalf("offset after WriteAt = %d, %v, want 0", off, err)
+	}
+
+	got, err := io.ReadAll(io.NewSectionReader(fid, 5, int64(len(data)-5)))
+	if err != nil || !bytes.Equal(got, data[5:]) {
+		t.Errorf("read through SectionReader returned %d bytes, %v, want the file's %d", len(got), err, len(data)-5)
+	}
+	buf := make([]byte, 10)
+	n, err := fid.ReadAt(buf, int64(len(data)-4))
+	if n != 4 || err != io.EOF || string(buf[:n]) != "6789" {
+		t.Errorf("ReadAt past end = %d, %v, %q, want 4, io.EOF, 6789", n, err, buf[:n])
+	}
+	if _, err := fid.ReadAt(buf, -2); err == nil {
+		t.Error("ReadAt at negative offset succeeded")
+	}
+	if off, err := fid.Seek(0, io.SeekCurrent); off != 0 || err != nil {
+		t.Errorf("offset after ReadAt = %d, %v, want 0", off, err)
+	}
+}
+
+func TestReadWriteContext(t *testing.T) {
+	stuck := make(chan bool, 1)
+	tree := srv9p.NewTree("slow", "slow", plan9.DMDIR|0777, nil)
+	if _, err := tree.Root.Create("file", "slow", 0666, nil); err != nil {
+		t.Fatal(err)
+	}
+	srv := &srv9p.Server{
+		Tree: tree,
+		Read: func(ctx context.Context, fid *srv9p.Fid, data []byte, offset int64) (int, error) {
+			if offset == 0 {
+				stuck <- true
+				<-ctx.Done()
+				return 0, ctx.Err()
+			}
+			return copy(data, "data"), nil
+		},
+		Write: func(ctx context.Context, fid *srv9p.Fid, data []byte, offset int64) (int, error) {
+			if string(data) == "stuck" {
+				stuck <- true
+				<-ctx.Done()
+				return 0, ctx.Err()
+			}
+			return len(data), nil
+		},
+	}
+	conn := serveConn(t, srv)
+	fs, err := conn.Attach(nil, "slow", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid, err := fs.Open("file", plan9.ORDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid.Close()
+	cancelWhenStuck := func() context.Context {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-stuck
+			cancel()
+		}()
+		return ctx
+	}
+
+	buf := make([]byte, 10)
+	if n, err := fid.ReadContext(cancelWhenStuck(), buf); n != 0 || err != context.Canceled {
+		t.Errorf("ReadContext canceled = %d, %v, want 0, context.Canceled", n, err)
+	}
+	if off, _ := fid.Seek(0, io.SeekCurrent); off != 0 {
+		t.Errorf("offset after canceled read = %d, want 0", off)
+	}
+	if n, err := fid.WriteContext(cancelWhenStuck(), []byte("stuck")); n != 0 || err != context.Canceled {
+		t.Errorf("WriteContext canceled = %d, %v, want 0, context.Canceled", n, err)
+	}
+
+	// The Fid, and its tags, are still usable.
+	if n, err := fid.WriteContext(context.Background(), []byte("ok")); n != 2 || err != nil {
+		t.Fatalf("WriteContext = %d, %v, want 2, nil", n, err)
+	}
+	if n, err := fid.Read(buf); err != nil || string(buf[:n]) != "data" {
+		t.Fatalf("Read after canceled requests = %q, %v, want data", buf[:n], err)
+	}
+	if _, err := conn.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,56 @@
+//go:build !plan9
+// +build !plan9
+
+package client
+
+import (
+	"io/fs"
+	"time"
+
+	"9fans.net/go/plan9"
+)
+
+// dirInfo adapts a plan9.Dir to the io/fs interfaces,
+// implementing both fs.FileInfo and fs.DirEntry.
+type dirInfo struct {
+	d *plan9.Dir
+}
+
+func (i dirInfo) Name() string       { return i.d.Name }
+func (i dirInfo) Size() int64        { return int64(i.d.Length) }
+func (i dirInfo) ModTime() time.Time { return time.Unix(int64(i.d.Mtime), 0) }
+func (i dirInfo) IsDir() bool        { return i.d.Qid.Type&plan9.QTDIR != 0 }
+func (i dirInfo) Sys() interface{}   { return i.d }
+
+func (i dirInfo) Mode() fs.FileMode {
+	m := fs.FileMode(i.d.Mode & 0777)
+	if i.IsDir() {
+		m |= fs.ModeDir
+	}
+	if i.d.Mode&plan9.DMAPPEND != 0 {
+		m |= fs.ModeAppend
+	}
+	if i.d.Mode&plan9.DMEXCL != 0 {
+		m |= fs.ModeExclusive
+	}
+	if i.d.Mode&plan9.DMTMP != 0 {
+		m |= fs.ModeTemporary
+	}
+	if i.d.Mode&plan9.DMSYMLINK != 0 {
+		m |= fs.ModeSymlink
+	}
+	if i.d.Mode&plan9.DMDEVICE != 0 {
+		m |= fs.ModeDevice
+	}
+	if i.d.Mode&plan9.DMNAMEDPIPE != 0 {
+		m |= fs.ModeNamedPipe
+	}
+	if i.d.Mode&plan9.DMSOCKET != 0 {
+		m |= fs.ModeSocket
+	}
+	return m
+}
+
+func (i dirInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i dirInfo) Info() (fs.FileInfo, error) { return i, nil }
+func (i dirInfo) String() string             { return fs.FormatDirEntry(i) }
@@ -3,6 +3,14 @@
 package client
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
 	"strings"
 
 	"9fans.net/go/plan9"
@@ -10,6 +18,10 @@ import (
 
 type Fsys struct {
 	root *Fid
+
+	// The arguments to Attach, for Clone.
+	afid        *Fid
+	user, aname string
 }
 
 func (c *Conn) Auth(uname, aname string) (*Fid, error) {
@@ -21,13 +33,15 @@ func (c *Conn) Auth(uname, aname string) (*Fid, error) {
 	if err != nil {
 		return nil, err
 	}
-	tx := &plan9.Fcall{Type: plan9.Tauth, Afid: afidnum, Uname: uname, Aname: aname}
+	tx := &plan9.Fcall{Type: plan9.Tauth, Afid: afidnum, Uname: uname, Aname: aname, Uid: plan9.NOUID}
 	rx, err := conn.rpc(tx, nil)
 	if err != nil {
 		conn.putfidnum(afidnum)
 		return nil, err
 	}
-	return conn.newFid(afidnum, rx.Qid), nil
+	afid := conn.newFid(afidnum, rx.Aqid)
+	afid.noReap.Store(true)
+	return afid, nil
 }
 
 func (c *Conn) Attach(afid *Fid, user, aname string) (*Fsys, error) {
@@ -35,20 +49,52 @@ func (c *Conn) Attach(afid *Fid, user, aname string) (*Fsys, error) {
 	if err != nil {
 		return nil, err
 	}
-	fidnum, err := conn.newfidnum()
+	fs, err := conn.attach(afid, user, aname)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.pruneMounts()
+	c.mounts = append(c.mounts, fs)
+	c.mu.Unlock()
+	return fs, nil
+}
+
+func (c *conn) attach(afid *Fid, user, aname string) (*Fsys, error) {
+	fidnum, err := c.newfidnum()
 	if err != nil {
 		return nil, err
 	}
-	tx := &plan9.Fcall{Type: plan9.Tattach, Afid: plan9.NOFID, Fid: fidnum, Uname: user, Aname: aname}
+	tx := &plan9.Fcall{Type: plan9.Tattach, Afid: plan9.NOFID, Fid: fidnum, Uname: user, Aname: aname, Uid: plan9.NOUID}
 	if afid != nil {
 		tx.Afid = afid.fid
 	}
-	rx, err := conn.rpc(tx, nil)
+	rx, err := c.rpc(tx, nil)
+	if err != nil {
+		c.putfidnum(fidnum)
+		return nil, err
+	}
+	root := c.newFid(fidnum, rx.Qid)
+	root.noReap.Store(true)
+	return &Fsys{
+		root:  root,
+		afid:  afid,
+		user:  user,
+		aname: aname,
+	}, nil
+}
+
+// Clone returns a new Fsys for the same file tree as fs, attached
+// again over the same connection with the same arguments, so that
+// separate parts of a program can share one connection without
+// sharing a root fid. Closing either Fsys does not affect the other.
+// Clone works even after the Conn has been released.
+func (fs *Fsys) Clone() (*Fsys, error) {
+	conn, err := fs.root.conn()
 	if err != nil {
-		conn.putfidnum(fidnum)
 		return nil, err
 	}
-	return &Fsys{conn.newFid(fidnum, rx.Qid)}, nil
+	return conn.attach(fs.afid, fs.user, fs.aname)
 }
 
 var accessOmode = [8]uint8{
@@ -74,14 +120,24 @@ func (fs *Fsys) Access(name string, mode int) error {
 	return err
 }
 
-func (fs *Fsys) Create(name string, mode uint8, perm plan9.Perm) (*Fid, error) {
-	i := strings.LastIndex(name, "/")
-	var dir, elem string
-	if i < 0 {
-		elem = name
-	} else {
-		dir, elem = name[0:i], name[i+1:]
+// Exists reports whether the named file exists. It returns false and
+// a nil error only if the walk to name fails with an error matching
+// ErrNotExist; any other failure, such as ErrPermission or a broken
+// connection, is returned as an error.
+func (fs *Fsys) Exists(name string) (bool, error) {
+	fid, err := fs.root.Walk(name)
+	if errors.Is(err, ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
 	}
+	fid.Close()
+	return true, nil
+}
+
+func (fs *Fsys) Create(name string, mode uint8, perm plan9.Perm) (*Fid, error) {
+	dir, elem := splitName(name)
 	fid, err := fs.root.Walk(dir)
 	if err != nil {
 		return nil, err
@@ -94,6 +150,57 @@ func (fs *Fsys) Create(name string, mode uint8, perm plan9.Perm) (*Fid, error) {
 	return fid, nil
 }
 
+// Symlink creates a symbolic link called name that points to target,
+// using the 9P2000.u form of Tcreate. It returns ErrNotSupported
+// unless the connection negotiated 9P2000.u; see WithUnixExtensions.
+func (fs *Fsys) Symlink(target, name string) error {
+	conn, err := fs.root.conn()
+	if err != nil {
+		return err
+	}
+	if conn.dialect != plan9.Dialect9P2000u {
+		return ErrNotSupported
+	}
+	dir, elem := splitName(name)
+	fid, err := fs.root.Walk(dir)
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
+	return fid.create(elem, plan9.OREAD, plan9.DMSYMLINK|0777, target)
+}
+
+// Readlink returns the target of the symbolic link name, which
+// 9P2000.u keeps in the extension string of the link's Dir.
+// It returns ErrNotSupported unless the connection negotiated
+// 9P2000.u; see WithUnixExtensions.
+func (fs *Fsys) Readlink(name string) (string, error) {
+	conn, err := fs.root.conn()
+	if err != nil {
+		return "", err
+	}
+	if conn.dialect != plan9.Dialect9P2000u {
+		return "", ErrNotSupported
+	}
+	d, err := fs.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	if d.Mode&plan9.DMSYMLINK == 0 {
+		return "", fmt.Errorf("readlink %s: not a symbolic link", name)
+	}
+	return d.Extension, nil
+}
+
+// splitName splits name into its directory and final element.
+func splitName(name string) (dir, elem string) {
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return "", name
+	}
+	return name[0:i], name[i+1:]
+}
+
 func (fs *Fsys) Open(name string, mode uint8) (*Fid, error) {
 	fid, err := fs.root.Walk(name)
 	if err != nil {
@@ -106,6 +213,258 @@ func (fs *Fsys) Open(name string, mode uint8) (*Fid, error) {
 	return fid, nil
 }
 
+// OpenFD opens the named file with the plan9port Topenfd message and
+// returns it as an *os.File. The server passes back a file descriptor
+// over the connection's Unix domain socket, and reads and writes on it
+// reach the file without 9P framing, which is much faster for large
+// transfers. It returns ErrNotSupported unless the connection is a
+// *net.UnixConn on a Unix system, as from DialService.
+func (fs *Fsys) OpenFD(name string, mode uint8) (*os.File, error) {
+	conn, err := fs.root.conn()
+	if err != nil {
+		return nil, err
+	}
+	if !canPassFD(conn.rwc) {
+		return nil, ErrNotSupported
+	}
+	fid, err := fs.root.Walk(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fid.Close()
+	fd, err := fid.openfd(mode)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// OpenContext is like Open but gives up when ctx is done. An open still
+// in progress then is flushed. If the server had already opened the file
+// by the time it saw the flush, OpenContext returns the open Fid and a
+// nil error, as though ctx had not been done; otherwise it returns
+// ctx.Err(). Either way no fid is left behind.
+func (fs *Fsys) OpenContext(ctx context.Context, name string, mode uint8) (*Fid, error) {
+	fid, err := fs.root.WalkContext(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := fid.openContext(ctx, mode); err != nil {
+		fid.Close()
+		return nil, ctxErr(ctx, err)
+	}
+	return fid, nil
+}
+
+// OpenTrunc opens the named file for writing (mode OWRITE|OTRUNC),
+// and the server truncates it to zero length as part of the open.
+// It is an error if the file does not exist; use Create to make one.
+func (fs *Fsys) OpenTrunc(name string) (*Fid, error) {
+	return fs.Open(name, plan9.OWRITE|plan9.OTRUNC)
+}
+
+// OpenAppend opens the named file for writing at its end.
+// If the file is append-only (its qid has the QTAPPEND bit),
+// the server places every write at the end of the file.
+// Otherwise OpenAppend only sets the Fid's offset to the current
+// length of the file, so writes by others may interleave.
+// The returned Fid's Qid reports which case applies.
+func (fs *Fsys) OpenAppend(name string) (*Fid, error) {
+	fid, err := fs.Open(name, plan9.OWRITE)
+	if err != nil {
+		return nil, err
+	}
+	if fid.Qid().Type&plan9.QTAPPEND == 0 {
+		if _, err := fid.Seek(0, io.SeekEnd); err != nil {
+			fid.Close()
+			return nil, err
+		}
+	}
+	return fid, nil
+}
+
+// CreateAppend creates the named file, open for writing,
+// with the DMAPPEND bit added to perm so that the server
+// places every write at the end of the file.
+func (fs *Fsys) CreateAppend(name string, perm plan9.Perm) (*Fid, error) {
+	return fs.Create(name, plan9.OWRITE, perm|plan9.DMAPPEND)
+}
+
+// OpenExcl creates the named file, open for reading and writing,
+// with the DMEXCL bit added to perm, so that while the returned Fid
+// is open the server refuses to open the file for anyone else.
+// Exclusive use is enforced by the server alone; the client has no
+// advisory fallback, so on a server that ignores DMEXCL the file is
+// not locked at all.
+func (fs *Fsys) OpenExcl(name string, perm plan9.Perm) (*Fid, error) {
+	return fs.Create(name, plan9.ORDWR, perm|plan9.DMEXCL)
+}
+
+// LockFile opens the named exclusive-use file, one with the DMEXCL bit
+// in its mode, for reading and writing. While the returned Fid is open
+// the server refuses to open the file for anyone else, and if someone
+// has it open already, LockFile fails. As with OpenExcl, the lock is
+// only as good as the server's enforcement of DMEXCL.
+// It is an error if the file is not an exclusive-use file.
+func (fs *Fsys) LockFile(name string) (*Fid, error) {
+	fid, err := fs.root.Walk(name)
+	if err != nil {
+		return nil, err
+	}
+	if fid.Qid().Type&plan9.QTEXCL == 0 {
+		fid.Close()
+		return nil, Error("file '" + name + "' is not exclusive-use")
+	}
+	if err := fid.Open(plan9.ORDWR); err != nil {
+		fid.Close()
+		return nil, err
+	}
+	return fid, nil
+}
+
+// ReadDir returns all the entries in the named directory.
+func (fs *Fsys) ReadDir(name string) ([]*plan9.Dir, error) {
+	fid, err := fs.Open(name, plan9.OREAD)
+	if err != nil {
+		return nil, err
+	}
+	defer fid.Close()
+	return fid.Dirreadall()
+}
+
+// ReadDirSorted is like ReadDir but returns the entries sorted by name,
+// keeping only the first of any entries with the same name, as a server
+// may return when the directory changes between reads.
+func (fs *Fsys) ReadDirSorted(name string) ([]*plan9.Dir, error) {
+	dirs, err := fs.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(dirs, func(i, j int) bool { return dirs[i].Name < dirs[j].Name })
+	out := dirs[:0]
+	for i, d := range dirs {
+		if i == 0 || d.Name != dirs[i-1].Name {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// Lines opens the named file and returns functions to iterate over its
+// lines without reading the whole file into memory. Each call to next
+// returns the next line, without its trailing newline, and true; a final
+// line with no newline is returned too. At the end of the file, or after
+// an error, next closes the file and returns "", false, and err then
+// returns the error that stopped the iteration, or nil at end of file.
+// Calling err also closes the file, so a caller stopping early should
+// call err when done.
+func (fs *Fsys) Lines(name string) (next func() (string, bool), err func() error) {
+	fid, openErr := fs.Open(name, plan9.OREAD)
+	if openErr != nil {
+		return func() (string, bool) { return "", false },
+			func() error { return openErr }
+	}
+	var (
+		b       = bufio.NewReader(fid)
+		lineErr error
+		done    bool
+	)
+	stop := func(err error) {
+		if !done {
+			done = true
+			lineErr = err
+			fid.Close()
+		}
+	}
+	next = func() (string, bool) {
+		if done {
+			return "", false
+		}
+		line, err := b.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			stop(err)
+			if line == "" {
+				return "", false
+			}
+			return line, true
+		}
+		return line[:len(line)-1], true
+	}
+	err = func() error {
+		stop(nil)
+		return lineErr
+	}
+	return next, err
+}
+
+// ReadFileRange returns up to n bytes of the named file starting at
+// offset off. It opens the file, reads at that offset and closes it again,
+// so it never depends on or disturbs the offset of any other Fid.
+// The result is shorter than n only if the file ends first.
+func (fs *Fsys) ReadFileRange(name string, off int64, n int) ([]byte, error) {
+	if off < 0 || n < 0 {
+		return nil, Error("negative offset or count")
+	}
+	fid, err := fs.Open(name, plan9.OREAD)
+	if err != nil {
+		return nil, err
+	}
+	defer fid.Close()
+	buf := make([]byte, n)
+	if conn, err := fid.conn(); err == nil {
+		conn.traceAlloc("read", n)
+	}
+	m, err := fid.ReadAt(buf, off)
+	if err == io.EOF {
+		err = nil
+	}
+	return buf[:m], err
+}
+
+// ReadN is like ReadFileRange but reads exactly n bytes, for callers
+// such as servers of HTTP range requests that have already checked
+// the range against the file's length. If the file ends first, ReadN
+// returns the bytes there were and io.ErrUnexpectedEOF, as io.ReadFull
+// does. A range longer than the connection's message size is read
+// with several Treads.
+func (fs *Fsys) ReadN(name string, off int64, n int) ([]byte, error) {
+	data, err := fs.ReadFileRange(name, off, n)
+	if err == nil && len(data) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return data, err
+}
+
+// ReadFile returns the contents of the named file.
+func (fs *Fsys) ReadFile(name string) ([]byte, error) {
+	fid, err := fs.Open(name, plan9.OREAD)
+	if err != nil {
+		return nil, err
+	}
+	defer fid.Close()
+	return io.ReadAll(fid)
+}
+
+// WriteFile writes data to the named file, truncating it if it exists
+// and otherwise creating it with permissions perm.
+func (fs *Fsys) WriteFile(name string, data []byte, perm plan9.Perm) error {
+	fid, err := fs.OpenTrunc(name)
+	if errors.Is(err, ErrNotExist) {
+		fid, err = fs.Create(name, plan9.OWRITE, perm)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fid.Write(data)
+	if err1 := fid.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
 func (fs *Fsys) Remove(name string) error {
 	fid, err := fs.root.Walk(name)
 	if err != nil {
@@ -114,22 +473,91 @@ func (fs *Fsys) Remove(name string) error {
 	return fid.Remove()
 }
 
-func (fs *Fsys) Stat(name string) (*plan9.Dir, error) {
+// RemoveAll removes name and, if it is a directory, everything it
+// contains, depth first. Each directory is walked to once and its
+// entries are removed by walking from it, so the cost does not grow
+// with the depth of the tree. RemoveAll stops at the first entry that
+// cannot be removed and returns an error naming it; entries removed
+// before then stay removed.
+func (fs *Fsys) RemoveAll(name string) error {
 	fid, err := fs.root.Walk(name)
+	if err != nil {
+		return err
+	}
+	return removeAll(fid, name)
+}
+
+// removeAll removes the file open on fid, whose path is name,
+// along with its contents if it is a directory. It always consumes fid.
+func removeAll(fid *Fid, name string) error {
+	if fid.qid.Type&plan9.QTDIR != 0 {
+		if err := removeContents(fid, name); err != nil {
+			fid.Close()
+			return err
+		}
+	}
+	if err := fid.Remove(); err != nil {
+		return fmt.Errorf("remove %s: %w", name, err)
+	}
+	return nil
+}
+
+// removeContents removes everything in the directory dir, whose path is
+// name, leaving dir itself walked but not opened.
+func removeContents(dir *Fid, name string) error {
+	rfid, err := dir.Walk("")
+	if err != nil {
+		return err
+	}
+	if err := rfid.Open(plan9.OREAD); err != nil {
+		rfid.Close()
+		return fmt.Errorf("read %s: %w", name, err)
+	}
+	dirs, err := rfid.Dirreadall()
+	rfid.Close()
+	if err != nil {
+		return fmt.Errorf("read %s: %w", name, err)
+	}
+	for _, d := range dirs {
+		child, err := dir.Walk(d.Name)
+		if err != nil {
+			return fmt.Errorf("remove %s: %w", path.Join(name, d.Name), err)
+		}
+		if err := removeAll(child, path.Join(name, d.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *Fsys) Stat(name string) (*plan9.Dir, error) {
+	return fs.StatContext(context.Background(), name)
+}
+
+// StatContext is like Stat but gives up when ctx is done,
+// flushing the request in progress then.
+func (fs *Fsys) StatContext(ctx context.Context, name string) (*plan9.Dir, error) {
+	fid, err := fs.root.WalkContext(ctx, name)
 	if err != nil {
 		return nil, err
 	}
-	d, err := fid.Stat()
+	d, err := fid.StatContext(ctx)
 	fid.Close()
 	return d, err
 }
 
 func (fs *Fsys) Wstat(name string, d *plan9.Dir) error {
-	fid, err := fs.root.Walk(name)
+	return fs.WstatContext(context.Background(), name, d)
+}
+
+// WstatContext is like Wstat but gives up when ctx is done,
+// flushing the request in progress then.
+func (fs *Fsys) WstatContext(ctx context.Context, name string, d *plan9.Dir) error {
+	fid, err := fs.root.WalkContext(ctx, name)
 	if err != nil {
 		return err
 	}
-	err = fid.Wstat(d)
+	err = fid.WstatContext(ctx, d)
 	fid.Close()
 	return err
 }
@@ -28,6 +28,18 @@ func (fs *Fsys) Open(name string, mode uint8) (*Fid, error) {
 	return &Fid{File: f}, err
 }
 
+// OpenFD opens the named file as an *os.File,
+// which on Plan 9 is how every file is opened.
+func (fs *Fsys) OpenFD(name string, mode uint8) (*os.File, error) {
+	return os.OpenFile(filepath.Join(fs.Mtpt, name), int(mode), 0)
+}
+
+// Close releases fs. On Plan 9, where fs names a mount point
+// rather than holding a connection, it does nothing.
+func (fs *Fsys) Close() error {
+	return nil
+}
+
 func (fs *Fsys) Remove(name string) error {
 	panic("unimplemented")
 }
@@ -0,0 +1,909 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+	"9fans.net/go/plan9/srv9p"
+)
+
+var visibleTimeout = flag.Duration("visibletimeout", 1*time.Second,
+	"how long to wait for a created file to become visible")
+
+// ramFile is the per-file state of the server started by newRAMConn.
+type ramFile struct {
+	data []byte
+}
+
+// serveConn runs srv on one end of a net.Pipe and returns
+// a client Conn, configured by opts, talking to it over the other.
+func serveConn(t *testing.T, srv *srv9p.Server, opts ...client.DialOption) *client.Conn {
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c1.Close(); c2.Close() })
+	go srv.Serve(c2, c2)
+	conn, err := client.NewConn(c1, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// newRAMConn starts an in-memory file server and returns
+// a client Conn, configured by opts, talking to it.
+func newRAMConn(t *testing.T, opts ...client.DialOption) *client.Conn {
+	srv := &srv9p.Server{
+		Tree: srv9p.NewTree("ram", "ram", plan9.DMDIR|0777, nil),
+		Open: func(ctx context.Context, fid *srv9p.Fid, mode uint8) error {
+			if rf, ok := fid.File().Aux.(*ramFile); ok && mode&plan9.OTRUNC != 0 {
+				rf.data = nil
+				fid.File().Stat.Length = 0
+			}
+			return nil
+		},
+		Create: func(ctx context.Context, fid *srv9p.Fid, name string, perm plan9.Perm, mode uint8) (plan9.Qid, error) {
+			f, err := fid.File().Create(name, "ram", perm, new(ramFile))
+			if err != nil {
+				return plan9.Qid{}, err
+			}
+			fid.SetFile(f)
+			return f.Stat.Qid, nil
+		},
+		Read: func(ctx context.Context, fid *srv9p.Fid, data []byte, offset int64) (int, error) {
+			rf := fid.File().Aux.(*ramFile)
+			return fid.ReadBytes(data, offset, rf.data)
+		},
+		Write: func(ctx context.Context, fid *srv9p.Fid, data []byte, offset int64) (int, error) {
+			rf := fid.File().Aux.(*ramFile)
+			if int64(int(offset)) != offset || int(offset)+len(data) < 0 {
+				return 0, srv9p.ErrBadOffset
+			}
+			end := int(offset) + len(data)
+			if len(rf.data) < end {
+				rf.data = slices.Grow(rf.data, end-len(rf.data))
+				rf.data = rf.data[:end]
+			}
+			copy(rf.data[offset:], data)
+			fid.File().Stat.Length = uint64(len(rf.data))
+			return len(data), nil
+		},
+	}
+	return serveConn(t, srv, opts...)
+}
+
+// newRAMFsys is like newRAMConn but also attaches to the server.
+func newRAMFsys(t *testing.T) *client.Fsys {
+	fs, err := newRAMConn(t).Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+// TestCreateClunkWalkRace checks that a file is visible to a fresh walk
+// as soon as the fid that created it has been clunked.
+// A miss on the first stat means the server has not committed the
+// create by the time it answers the Tclunk; a "duplicate fid" error means
+// the client handed out a fid number the server still considers in use.
+func TestCreateClunkWalkRace(t *testing.T) {
+	fs := newRAMFsys(t)
+
+	const rounds = 50
+	for i := 0; i < rounds; i++ {
+		name := fmt.Sprintf("file%d", i)
+		fid, err := fs.Create(name, plan9.OWRITE, 0666)
+		if err != nil {
+			t.Fatalf("round %d create: %v", i, err)
+		}
+		if err := fid.Close(); err != nil {
+			t.Fatalf("round %d clunk: %v", i, err)
+		}
+
+		misses := 0
+		deadline := time.Now().Add(*visibleTimeout)
+		for {
+			_, err := fs.Stat(name)
+			if err == nil {
+				break
+			}
+			if err.Error() == "duplicate fid" {
+				t.Fatalf("round %d stat: client reused a fid the server still holds", i)
+			}
+			misses++
+			if time.Now().After(deadline) {
+				t.Fatalf("round %d: %s not visible %v after clunk: %v", i, name, *visibleTimeout, err)
+			}
+		}
+		if misses > 0 {
+			t.Errorf("round %d: %s not visible for %d stats after clunk", i, name, misses)
+		}
+	}
+}
+
+// TestLargeDirectory checks that reading a directory whose entries
+// do not fit in a single Rread returns every entry.
+func TestLargeDirectory(t *testing.T) {
+	fs := newRAMFsys(t)
+	const n = 1000
+	for i := 0; i < n; i++ {
+		fid, err := fs.Create(fmt.Sprintf("file%04d", i), plan9.OREAD, 0444)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fid.Close()
+	}
+
+	dirs, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != n {
+		t.Fatalf("ReadDir returned %d entries, want %d", len(dirs), n)
+	}
+	seen := make(map[string]bool)
+	for _, d := range dirs {
+		seen[d.Name] = true
+	}
+	for i := 0; i < n; i++ {
+		if name := fmt.Sprintf("file%04d", i); !seen[name] {
+			t.Errorf("ReadDir did not return %s", name)
+		}
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	fs := newRAMFsys(t)
+	if _, err := fs.Stat("missing"); !errors.Is(err, client.ErrNotExist) {
+		t.Errorf("Stat(missing) = %v, want ErrNotExist", err)
+	}
+	if err := client.Error("walk in non-directory"); !errors.Is(err, client.ErrNotDir) {
+		t.Errorf("%v does not match ErrNotDir", err)
+	}
+
+	errQuota := errors.New("quota exceeded")
+	client.RegisterError("over quota", errQuota)
+	if err := client.Error("user glenda over quota"); !errors.Is(err, errQuota) {
+		t.Errorf("registered mapping not applied to %v", err)
+	}
+}
+
+func TestReadFileRange(t *testing.T) {
+	fs := newRAMFsys(t)
+	fid, err := fs.Create("log", plan9.OWRITE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fid.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	fid.Close()
+
+	for _, tt := range []struct {
+		off  int64
+		n    int
+		want string
+	}{
+		{0, 4, "0123"},
+		{3, 4, "3456"},
+		{8, 4, "89"},
+		{12, 4, ""},
+	} {
+		data, err := fs.ReadFileRange("log", tt.off, tt.n)
+		if err != nil || string(data) != tt.want {
+			t.Errorf("ReadFileRange(log, %d, %d) = %q, %v, want %q, nil", tt.off, tt.n, data, err, tt.want)
+		}
+	}
+}
+
+func TestReadN(t *testing.T) {
+	fs := newRAMFsys(t)
+	fid, err := fs.Create("big", plan9.OWRITE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Larger than the message size, so that ReadN needs several Treads.
+	big := bytes.Repeat([]byte("0123456789"), 3000)
+	if _, err := fid.Write(big); err != nil {
+		t.Fatal(err)
+	}
+	fid.Close()
+
+	data, err := fs.ReadN("big", 5, len(big)-10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, big[5:len(big)-5]) {
+		t.Errorf("ReadN(big, 5, %d) returned %d bytes, not the file's", len(big)-10, len(data))
+	}
+	data, err = fs.ReadN("big", int64(len(big)-4), 10)
+	if err != io.ErrUnexpectedEOF || string(data) != "6789" {
+		t.Errorf("ReadN past end = %q, %v, want 6789, io.ErrUnexpectedEOF", data, err)
+	}
+}
+
+func TestReadWriteJSON(t *testing.T) {
+	type config struct {
+		Name  string
+		Ports []int
+	}
+	fs := newRAMFsys(t)
+	want := config{Name: "agent", Ports: []int{564, 17010}}
+	if err := fs.WriteJSON("config.json", want); err != nil {
+		t.Fatal(err)
+	}
+	var got config
+	if err := fs.ReadJSON("config.json", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != want.Name || !slices.Equal(got.Ports, want.Ports) {
+		t.Errorf("ReadJSON = %+v, want %+v", got, want)
+	}
+
+	// Rewriting truncates: a shorter value leaves no trailing bytes.
+	if err := fs.WriteJSON("config.json", config{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := fs.ReadFile("config.json")
+	if want := `{"Name":"a","Ports":null}`; err != nil || string(data) != want {
+		t.Errorf("ReadFile after rewrite = %q, %v, want %q", data, err, want)
+	}
+
+	if err := fs.ReadJSON("missing.json", &got); !errors.Is(err, client.ErrNotExist) {
+		t.Errorf("ReadJSON(missing.json) = %v, want ErrNotExist", err)
+	}
+}
+
+func TestExists(t *testing.T) {
+	fs := newRAMFsys(t)
+	makeTree(t, fs, "dir/", "dir/file")
+	for _, tt := range []struct {
+		name string
+		want bool
+	}{
+		{"dir", true},
+		{"dir/file", true},
+		{"", true},
+		{"missing", false},
+		{"dir/missing", false},
+	} {
+		if ok, err := fs.Exists(tt.name); ok != tt.want || err != nil {
+			t.Errorf("Exists(%q) = %v, %v, want %v, nil", tt.name, ok, err, tt.want)
+		}
+	}
+
+	fs.Close()
+	if ok, err := fs.Exists("dir"); ok || err == nil {
+		t.Errorf("Exists after Close = %v, %v, want false and an error", ok, err)
+	}
+}
+
+func TestSetName(t *testing.T) {
+	conn := newRAMConn(t)
+	conn.SetName("ramfs")
+	fs, err := conn.Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.Stat("missing")
+	var cerr *client.ConnError
+	if !errors.As(err, &cerr) || cerr.Name != "ramfs" {
+		t.Fatalf("Stat(missing) = %v, want *ConnError named ramfs", err)
+	}
+	if !errors.Is(err, client.ErrNotExist) {
+		t.Errorf("Stat(missing) = %v, does not match ErrNotExist", err)
+	}
+}
+
+func TestOpenAppend(t *testing.T) {
+	fs := newRAMFsys(t)
+	fid, err := fs.Create("log", plan9.OWRITE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid.Write([]byte("one\n"))
+	fid.Close()
+
+	fid, err = fs.OpenAppend("log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid.Write([]byte("two\n"))
+	fid.Close()
+
+	data, err := fs.ReadFileRange("log", 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "one\ntwo\n"; string(data) != want {
+		t.Errorf("log holds %q, want %q", data, want)
+	}
+}
+
+func TestReadDirSorted(t *testing.T) {
+	fs := newRAMFsys(t)
+	for _, name := range []string{"c", "a", "b"} {
+		fid, err := fs.Create(name, plan9.OREAD, 0444)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fid.Close()
+	}
+	dirs, err := fs.ReadDirSorted("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, d := range dirs {
+		names = append(names, d.Name)
+	}
+	if want := []string{"a", "b", "c"}; !slices.Equal(names, want) {
+		t.Errorf("ReadDirSorted = %q, want %q", names, want)
+	}
+}
+
+func TestOpenTrunc(t *testing.T) {
+	fs := newRAMFsys(t)
+	fid, err := fs.Create("file", plan9.OWRITE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fid.Write([]byte("old contents")); err != nil {
+		t.Fatal(err)
+	}
+	fid.Close()
+
+	fid, err = fs.OpenTrunc("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid.Close()
+
+	fid, err = fs.Open("file", plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid.Close()
+	buf := make([]byte, 100)
+	if n, err := fid.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read after OpenTrunc = %d, %v, want 0, EOF", n, err)
+	}
+}
+
+func TestLines(t *testing.T) {
+	fs := newRAMFsys(t)
+	fid, err := fs.Create("text", plan9.OWRITE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid.Write([]byte("one\n\nthree\nfour"))
+	fid.Close()
+
+	next, lineErr := fs.Lines("text")
+	var lines []string
+	for line, ok := next(); ok; line, ok = next() {
+		lines = append(lines, line)
+	}
+	if err := lineErr(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"one", "", "three", "four"}; !slices.Equal(lines, want) {
+		t.Errorf("Lines = %q, want %q", lines, want)
+	}
+
+	next, lineErr = fs.Lines("missing")
+	if _, ok := next(); ok {
+		t.Error("Lines(missing) returned a line")
+	}
+	if err := lineErr(); !errors.Is(err, client.ErrNotExist) {
+		t.Errorf("Lines(missing) error = %v, want ErrNotExist", err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	conn := newRAMConn(t)
+	fs, err := conn.Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Release()
+	clone, err := fs.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clone.Stat("/"); err != nil {
+		t.Errorf("Stat on clone after closing original: %v", err)
+	}
+	clone.Close()
+}
+
+func TestUploadDownload(t *testing.T) {
+	fs := newRAMFsys(t)
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	data := bytes.Repeat([]byte("0123456789"), 50000)
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Upload(src, "copy"); err != nil {
+		t.Fatal(err)
+	}
+	// Uploading again truncates the existing copy.
+	if err := os.WriteFile(src, data[:100], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Upload(src, "copy"); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, "dst")
+	if err := fs.Download("copy", dst); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data[:100]) {
+		t.Errorf("downloaded %d bytes, want the 100 uploaded", len(got))
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	stuck := true
+	srv := &srv9p.Server{
+		Tree: srv9p.NewTree("ram", "ram", plan9.DMDIR|0777, nil),
+		Create: func(ctx context.Context, fid *srv9p.Fid, name string, perm plan9.Perm, mode uint8) (plan9.Qid, error) {
+			f, err := fid.File().Create(name, "ram", perm, nil)
+			if err != nil {
+				return plan9.Qid{}, err
+			}
+			fid.SetFile(f)
+			return f.Stat.Qid, nil
+		},
+		Remove: func(ctx context.Context, fid *srv9p.Fid) error {
+			if stuck && fid.File().Stat.Name == "stuck" {
+				return errors.New("file is busy")
+			}
+			return nil
+		},
+	}
+	conn := serveConn(t, srv)
+	fs, err := conn.Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeTree(t, fs, "keep", "d/", "d/a", "d/e/", "d/e/b", "d/e/f/", "d/e/stuck")
+
+	err = fs.RemoveAll("d")
+	if err == nil || !strings.Contains(err.Error(), "d/e/stuck") {
+		t.Errorf("RemoveAll with busy file = %v, want error naming d/e/stuck", err)
+	}
+	if n := conn.CurrentFidCount(); n != 1 {
+		t.Errorf("%d fids allocated after failed RemoveAll, want 1", n)
+	}
+
+	stuck = false
+	if err := fs.RemoveAll("d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("d"); err == nil {
+		t.Error("d exists after RemoveAll")
+	}
+	if _, err := fs.Stat("keep"); err != nil {
+		t.Errorf("RemoveAll(d) removed keep: %v", err)
+	}
+	if err := fs.RemoveAll("missing"); err == nil {
+		t.Error("RemoveAll of missing file succeeded")
+	}
+	if n := conn.CurrentFidCount(); n != 1 {
+		t.Errorf("%d fids allocated after RemoveAll, want 1", n)
+	}
+}
+
+func TestOpenContext(t *testing.T) {
+	opening := make(chan bool, 1)
+	srv := &srv9p.Server{
+		Tree: srv9p.NewTree("slow", "slow", plan9.DMDIR|0777, nil),
+		Open: func(ctx context.Context, fid *srv9p.Fid, mode uint8) error {
+			if fid.File().Stat.Name != "stuck" {
+				return nil
+			}
+			opening <- true
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+	for _, name := range []string{"file", "stuck"} {
+		if _, err := srv.Tree.Root.Create(name, "slow", 0666, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn := serveConn(t, srv)
+	fs, err := conn.Attach(nil, "slow", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fid, err := fs.OpenContext(context.Background(), "file", plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-opening
+		cancel()
+	}()
+	if _, err := fs.OpenContext(ctx, "stuck", plan9.OREAD); err != context.Canceled {
+		t.Errorf("OpenContext canceled = %v, want context.Canceled", err)
+	}
+	if n := conn.CurrentFidCount(); n != 1 {
+		t.Errorf("%d fids allocated after canceled OpenContext, want 1", n)
+	}
+	if _, err := fs.OpenContext(ctx, "file", plan9.OREAD); err != context.Canceled {
+		t.Errorf("OpenContext with done context = %v, want context.Canceled", err)
+	}
+
+	// The connection still works.
+	if _, err := fs.Stat("file"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestOpenContextFlushed checks OpenContext against a server that
+// abandons a flushed open without answering it, as 9P allows.
+func TestOpenContextFlushed(t *testing.T) {
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c1.Close(); c2.Close() })
+	go func() {
+		for {
+			f, err := plan9.ReadFcall(c2)
+			if err != nil {
+				return
+			}
+			rx := &plan9.Fcall{Type: f.Type + 1, Tag: f.Tag}
+			switch f.Type {
+			case plan9.Tversion:
+				rx.Msize, rx.Version = f.Msize, "9P2000"
+			case plan9.Tattach:
+				rx.Qid = plan9.Qid{Type: plan9.QTDIR}
+			case plan9.Twalk:
+				rx.Wqid = make([]plan9.Qid, len(f.Wname))
+			case plan9.Topen:
+				continue // never answered unless flushed
+			}
+			plan9.WriteFcall(c2, rx)
+		}
+	}()
+	conn, err := client.NewConn(c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := fs.OpenContext(ctx, "file", plan9.OREAD); err != context.DeadlineExceeded {
+		t.Errorf("OpenContext = %v, want context.DeadlineExceeded", err)
+	}
+	if n := conn.CurrentFidCount(); n != 1 {
+		t.Errorf("%d fids allocated after flushed OpenContext, want 1", n)
+	}
+	if _, err := conn.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStatContextFlushed checks that StatContext gives up on
+// a stuck walk or stat, leaving no fid behind, against a server that
+// abandons the flushed requests without answering them.
+func TestStatContextFlushed(t *testing.T) {
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c1.Close(); c2.Close() })
+	go func() {
+		for {
+			f, err := plan9.ReadFcall(c2)
+			if err != nil {
+				return
+			}
+			rx := &plan9.Fcall{Type: f.Type + 1, Tag: f.Tag}
+			switch f.Type {
+			case plan9.Tversion:
+				rx.Msize, rx.Version = f.Msize, "9P2000"
+			case plan9.Tattach:
+				rx.Qid = plan9.Qid{Type: plan9.QTDIR}
+			case plan9.Twalk:
+				if slices.Contains(f.Wname, "stuck") {
+					continue
+				}
+				rx.Wqid = make([]plan9.Qid, len(f.Wname))
+			case plan9.Tstat:
+				continue // never answered unless flushed
+			}
+			plan9.WriteFcall(c2, rx)
+		}
+	}()
+	conn, err := client.NewConn(c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"stuck", "file"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		if _, err := fs.StatContext(ctx, name); err != context.DeadlineExceeded {
+			t.Errorf("StatContext(%s) = %v, want context.DeadlineExceeded", name, err)
+		}
+		cancel()
+		if n := conn.CurrentFidCount(); n != 1 {
+			t.Errorf("%d fids allocated after flushed StatContext(%s), want 1", n, name)
+		}
+	}
+	if _, err := conn.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenExcl(t *testing.T) {
+	fs := newRAMFsys(t)
+	fid, err := fs.OpenExcl("lock", 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fid.Qid().Type&plan9.QTEXCL == 0 {
+		t.Errorf("OpenExcl qid type %#x, want QTEXCL", fid.Qid().Type)
+	}
+	fid.Close()
+
+	fid, err = fs.LockFile("lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid.Close()
+
+	makeTree(t, fs, "plain")
+	if _, err := fs.LockFile("plain"); err == nil {
+		t.Error("LockFile of file without DMEXCL succeeded")
+	}
+}
+
+// TestUnixExtensions checks the 9P2000.u negotiation and encoding
+// against a server that speaks it.
+func TestUnixExtensions(t *testing.T) {
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c1.Close(); c2.Close() })
+	var attachUid uint32
+	var symlink *plan9.Fcall
+	go func() {
+		dialect := plan9.Dialect9P2000
+		for {
+			f, err := plan9.ReadFcallDialect(c2, dialect)
+			if err != nil {
+				return
+			}
+			rx := &plan9.Fcall{Type: f.Type + 1, Tag: f.Tag}
+			switch f.Type {
+			case plan9.Tversion:
+				rx.Msize, rx.Version = f.Msize, "9P2000.u"
+			case plan9.Tattach:
+				attachUid = f.Uid
+				rx.Qid = plan9.Qid{Type: plan9.QTDIR}
+			case plan9.Twalk:
+				rx.Wqid = make([]plan9.Qid, len(f.Wname))
+			case plan9.Tcreate:
+				symlink = f
+				rx.Qid = plan9.Qid{Path: 1, Type: plan9.QTSYMLINK}
+			case plan9.Tstat:
+				d := &plan9.Dir{Name: "link", Mode: plan9.DMSYMLINK | 0777, Extension: "target", Uidnum: 1000, Gidnum: 1000, Muidnum: 1000}
+				rx.Stat, _ = d.BytesDialect(plan9.Dialect9P2000u)
+			}
+			plan9.WriteFcallDialect(c2, rx, dialect)
+			dialect = plan9.Dialect9P2000u
+		}
+	}()
+	conn, err := client.NewConn(c1, client.WithUnixExtensions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := conn.Version(); v != "9P2000.u" {
+		t.Errorf("Version = %q, want 9P2000.u", v)
+	}
+	fs, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachUid != plan9.NOUID {
+		t.Errorf("Tattach n_uname = %d, want NOUID", attachUid)
+	}
+
+	if err := fs.Symlink("target", "link"); err != nil {
+		t.Fatal(err)
+	}
+	if symlink.Name != "link" || symlink.Extension != "target" || symlink.Perm&plan9.DMSYMLINK == 0 {
+		t.Errorf("Symlink sent %v extension %q", symlink, symlink.Extension)
+	}
+	d, err := fs.Stat("link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Extension != "target" || d.Uidnum != 1000 {
+		t.Errorf("Stat = extension %q uidnum %d, want target 1000", d.Extension, d.Uidnum)
+	}
+}
+
+// TestUnixExtensionsFallback checks that a client proposing 9P2000.u
+// falls back to 9P2000 when the server does not support it.
+func TestUnixExtensionsFallback(t *testing.T) {
+	conn := newRAMConn(t, client.WithUnixExtensions())
+	if v := conn.Version(); v != "9P2000" {
+		t.Errorf("Version = %q, want 9P2000", v)
+	}
+	fs, err := conn.Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("target", "link"); err != client.ErrNotSupported {
+		t.Errorf("Symlink over 9P2000 = %v, want ErrNotSupported", err)
+	}
+	makeTree(t, fs, "file")
+	d, err := fs.Stat("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Uidnum != plan9.NOUID || d.Extension != "" {
+		t.Errorf("Stat over 9P2000 = uidnum %d extension %q, want NOUID and none", d.Uidnum, d.Extension)
+	}
+}
+
+// TestReadlink checks that Readlink walks to a symbolic link and
+// returns the target from its 9P2000.u stat.
+func TestReadlink(t *testing.T) {
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c1.Close(); c2.Close() })
+	var walk *plan9.Fcall
+	go func() {
+		dialect := plan9.Dialect9P2000
+		for {
+			f, err := plan9.ReadFcallDialect(c2, dialect)
+			if err != nil {
+				return
+			}
+			rx := &plan9.Fcall{Type: f.Type + 1, Tag: f.Tag}
+			switch f.Type {
+			case plan9.Tversion:
+				rx.Msize, rx.Version = f.Msize, "9P2000.u"
+			case plan9.Tattach:
+				rx.Qid = plan9.Qid{Type: plan9.QTDIR}
+			case plan9.Twalk:
+				walk = f
+				rx.Wqid = make([]plan9.Qid, len(f.Wname))
+				if len(rx.Wqid) > 0 {
+					rx.Wqid[len(rx.Wqid)-1] = plan9.Qid{Path: 1, Type: plan9.QTSYMLINK}
+				}
+			case plan9.Tstat:
+				d := &plan9.Dir{Name: "link", Mode: plan9.DMSYMLINK | 0777, Extension: "../target"}
+				rx.Stat, _ = d.BytesDialect(plan9.Dialect9P2000u)
+			}
+			plan9.WriteFcallDialect(c2, rx, dialect)
+			dialect = plan9.Dialect9P2000u
+		}
+	}()
+	conn, err := client.NewConn(c1, client.WithUnixExtensions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := fs.Readlink("dir/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "../target" {
+		t.Errorf("Readlink = %q, want ../target", target)
+	}
+	if walk == nil || strings.Join(walk.Wname, "/") != "dir/link" {
+		t.Errorf("Readlink walked with %v, want dir/link", walk)
+	}
+}
+
+// TestReadlinkNotSupported checks that Readlink needs 9P2000.u.
+func TestReadlinkNotSupported(t *testing.T) {
+	fs, err := newRAMConn(t).Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Readlink("link"); err != client.ErrNotSupported {
+		t.Errorf("Readlink over 9P2000 = %v, want ErrNotSupported", err)
+	}
+}
+
+// TestAttachWithAuth checks the authentication handshake against a
+// server that requires it: Tauth, credentials written to the auth fid,
+// and a Tattach naming that fid.
+func TestAttachWithAuth(t *testing.T) {
+	const creds = "glenda:secret"
+	aqid := plan9.Qid{Path: 42, Type: plan9.QTAUTH}
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c1.Close(); c2.Close() })
+	go func() {
+		afid := uint32(plan9.NOFID)
+		var written []byte
+		for {
+			f, err := plan9.ReadFcall(c2)
+			if err != nil {
+				return
+			}
+			rx := &plan9.Fcall{Type: f.Type + 1, Tag: f.Tag}
+			switch f.Type {
+			case plan9.Tversion:
+				rx.Msize, rx.Version = f.Msize, f.Version
+			case plan9.Tauth:
+				afid = f.Afid
+				rx.Aqid = aqid
+			case plan9.Twrite:
+				if f.Fid != afid {
+					rx = &plan9.Fcall{Type: plan9.Rerror, Tag: f.Tag, Ename: "unknown fid"}
+					break
+				}
+				written = append(written, f.Data...)
+				rx.Count = uint32(len(f.Data))
+			case plan9.Tattach:
+				if f.Afid == plan9.NOFID || f.Afid != afid || string(written) != creds {
+					rx = &plan9.Fcall{Type: plan9.Rerror, Tag: f.Tag, Ename: "authentication failed"}
+					break
+				}
+				rx.Qid = plan9.Qid{Type: plan9.QTDIR}
+			}
+			plan9.WriteFcall(c2, rx)
+		}
+	}()
+	conn, err := client.NewConn(c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Attach(nil, "glenda", ""); err == nil {
+		t.Fatal("Attach without authenticating succeeded")
+	}
+
+	afid, err := conn.Auth("glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if afid.Qid() != aqid {
+		t.Errorf("auth fid qid = %v, want %v", afid.Qid(), aqid)
+	}
+	// A stand-in for a p9any conversation: write the credentials.
+	writeCreds := func(afid *client.Fid) error {
+		_, err := afid.Write([]byte(creds))
+		return err
+	}
+	if err := writeCreds(afid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Attach(afid, "glenda", ""); err != nil {
+		t.Fatalf("Attach with auth fid: %v", err)
+	}
+}
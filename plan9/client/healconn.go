@@ -0,0 +1,348 @@
+//go:build !plan9
+// +build !plan9
+
+package client
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"9fans.net/go/plan9"
+)
+
+// A HealthCheck vets a connection that a SelfHealingConn has dialed
+// to replace a failed one. It is called after the 9P handshake and
+// before any other message is sent; a non-nil error rejects the
+// connection and is returned by the read or write that failed.
+type HealthCheck func(net.Conn) error
+
+// SelfHealingConn returns a net.Conn, for passing to NewConn, that
+// calls dial for its underlying connection when first used and calls
+// it again for a new one whenever a read or write fails. It replays
+// the client's Tversion on each new connection, so that the server
+// sees a conversation it can answer, and checks it with health if
+// that is not nil.
+//
+// Only the connection is restored: the server on the new connection
+// knows none of the fids of the old one, and requests still waiting
+// for a reply when it failed are answered with an error matching
+// ErrConnReset. Callers must be prepared to attach again and redo
+// their walks; SelfHealingConn suits programs that already keep
+// their fids short-lived or rebuild them on error.
+//
+// A failure before the Tversion exchange has completed, a failed
+// dial, and a read or write past a deadline are returned as errors
+// rather than re-dialed, as is a new connection that fails its
+// check. Deadlines apply only to the connection current when they
+// are set.
+func SelfHealingConn(dial func() (net.Conn, error), health HealthCheck) net.Conn {
+	return &healingConn{dial: dial, health: health, pending: make(map[uint16]*healReq)}
+}
+
+type healingConn struct {
+	dial   func() (net.Conn, error)
+	health HealthCheck
+
+	rmu  sync.Mutex // held by Read
+	rbuf []byte     // rest of the message being read
+
+	wmu  sync.Mutex // held by Write
+	wbuf []byte     // partial message written so far
+
+	mu       sync.Mutex
+	c        net.Conn // nil until first dialed
+	gen      int      // incremented each time c is replaced
+	closed   bool
+	tversion []byte // the client's Tversion, to replay
+	version  string // the version negotiated; "" until then
+	msize    uint32
+	dialect  plan9.Dialect
+	pending  map[uint16]*healReq // requests awaiting replies, by tag
+	synth    []byte              // error replies for requests lost to a failure
+}
+
+// A healReq is a request on a healingConn awaiting its reply.
+type healReq struct {
+	oldtag  int  // for a Tflush, the tag it flushes; otherwise -1
+	writing bool // being written, so a failure will resend it
+}
+
+func (h *healingConn) Read(p []byte) (int, error) {
+	h.rmu.Lock()
+	defer h.rmu.Unlock()
+	for len(h.rbuf) == 0 {
+		h.mu.Lock()
+		if len(h.synth) > 0 {
+			h.rbuf, h.synth = h.synth, nil
+			h.mu.Unlock()
+			break
+		}
+		h.mu.Unlock()
+		c, gen, err := h.conn()
+		if err != nil {
+			return 0, err
+		}
+		msg, err := readMsg(c)
+		if err != nil {
+			if err := h.heal(gen, err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		h.received(msg)
+		h.rbuf = msg
+	}
+	n := copy(p, h.rbuf)
+	h.rbuf = h.rbuf[n:]
+	return n, nil
+}
+
+func (h *healingConn) Write(p []byte) (int, error) {
+	h.wmu.Lock()
+	defer h.wmu.Unlock()
+	h.wbuf = append(h.wbuf, p...)
+	for len(h.wbuf) >= 4 {
+		size := int(h.wbuf[0]) | int(h.wbuf[1])<<8 | int(h.wbuf[2])<<16 | int(h.wbuf[3])<<24
+		if size < 7 {
+			h.wbuf = h.wbuf[:0]
+			return 0, plan9.ProtocolError("malformed message")
+		}
+		if len(h.wbuf) < size {
+			break
+		}
+		err := h.send(h.wbuf[:size])
+		h.wbuf = h.wbuf[size:]
+		if err != nil {
+			h.wbuf = h.wbuf[:0]
+			return 0, err
+		}
+	}
+	if len(h.wbuf) == 0 {
+		h.wbuf = nil
+	}
+	return len(p), nil
+}
+
+// send writes the whole message msg, re-dialing and writing it again
+// if the connection fails during or after the write.
+func (h *healingConn) send(msg []byte) error {
+	typ, tag := msg[4], uint16(msg[5])|uint16(msg[6])<<8
+	h.mu.Lock()
+	var r *healReq
+	switch {
+	case typ == plan9.Tversion:
+		h.tversion = append([]byte(nil), msg...)
+	case len(msg) >= 9 && typ == plan9.Tflush:
+		r = &healReq{oldtag: int(msg[7]) | int(msg[8])<<8}
+	default:
+		r = &healReq{oldtag: -1}
+	}
+	if r != nil {
+		r.writing = true
+		h.pending[tag] = r
+	}
+	h.mu.Unlock()
+
+	for {
+		c, gen, err := h.conn()
+		if err != nil {
+			return err
+		}
+		_, err = c.Write(msg)
+		if err != nil {
+			if err := h.heal(gen, err); err != nil {
+				return err
+			}
+			continue
+		}
+		h.mu.Lock()
+		if h.gen != gen {
+			// Replaced while writing: the message may never
+			// have reached the old server.
+			h.mu.Unlock()
+			continue
+		}
+		if r != nil {
+			r.writing = false
+		}
+		h.mu.Unlock()
+		return nil
+	}
+}
+
+// conn returns the current connection and its generation,
+// dialing the first one if need be.
+func (h *healingConn) conn() (net.Conn, int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, 0, net.ErrClosed
+	}
+	if h.c == nil {
+		c, err := h.dial()
+		if err != nil {
+			return nil, 0, err
+		}
+		h.c = c
+	}
+	return h.c, h.gen, nil
+}
+
+// received notes the reply msg read from the server.
+func (h *healingConn) received(msg []byte) {
+	typ, tag := msg[4], uint16(msg[5])|uint16(msg[6])<<8
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if typ == plan9.Rversion && tag == plan9.NOTAG && h.version == "" {
+		if rx, err := plan9.UnmarshalFcall(msg); err == nil {
+			h.version, h.msize, h.dialect = rx.Version, rx.Msize, plan9.DialectOf(rx.Version)
+		}
+		return
+	}
+	if r, ok := h.pending[tag]; ok {
+		if r.oldtag >= 0 {
+			delete(h.pending, uint16(r.oldtag))
+		}
+		delete(h.pending, tag)
+	}
+}
+
+// heal replaces the connection of generation gen, which failed with
+// err, and returns nil, or returns why it could not. If the connection
+// has been replaced already, heal returns nil at once.
+func (h *healingConn) heal(gen int, err error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case h.closed:
+		return net.ErrClosed
+	case h.gen != gen:
+		return nil
+	case h.version == "" || errors.Is(err, os.ErrDeadlineExceeded):
+		return err
+	}
+	h.c.Close()
+	c, err := h.dial()
+	if err != nil {
+		return err
+	}
+	if err := h.handshake(c); err != nil {
+		c.Close()
+		return err
+	}
+	if h.health != nil {
+		if err := h.health(c); err != nil {
+			c.Close()
+			return err
+		}
+	}
+	h.c = c
+	h.gen++
+	for tag, r := range h.pending {
+		if r.writing {
+			continue
+		}
+		delete(h.pending, tag)
+		rx := &plan9.Fcall{Type: plan9.Rerror, Tag: tag, Ename: ErrConnReset.Error()}
+		if b, err := rx.BytesDialect(h.dialect); err == nil {
+			h.synth = append(h.synth, b...)
+		}
+	}
+	return nil
+}
+
+// handshake replays the client's Tversion on c and checks that the
+// server agrees to the version and message size negotiated before.
+// h.mu must be held.
+func (h *healingConn) handshake(c net.Conn) error {
+	if _, err := c.Write(h.tversion); err != nil {
+		return err
+	}
+	msg, err := readMsg(c)
+	if err != nil {
+		return err
+	}
+	rx, err := plan9.UnmarshalFcall(msg)
+	if err != nil {
+		return err
+	}
+	if rx.Type != plan9.Rversion || rx.Tag != plan9.NOTAG || rx.Version != h.version || rx.Msize < h.msize {
+		return plan9.ProtocolError("re-dialed server negotiated differently: " + rx.String())
+	}
+	return nil
+}
+
+// readMsg reads one whole 9P message from r.
+func readMsg(r io.Reader) ([]byte, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	size := int(n[0]) | int(n[1])<<8 | int(n[2])<<16 | int(n[3])<<24
+	if size < 7 {
+		return nil, plan9.ProtocolError("malformed message")
+	}
+	msg := make([]byte, size)
+	copy(msg, n[:])
+	if _, err := io.ReadFull(r, msg[4:]); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (h *healingConn) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	if h.c == nil {
+		return nil
+	}
+	return h.c.Close()
+}
+
+// The addresses and deadlines are those of the current connection,
+// which is dialed if need be.
+
+func (h *healingConn) LocalAddr() net.Addr {
+	c, _, err := h.conn()
+	if err != nil {
+		return nil
+	}
+	return c.LocalAddr()
+}
+
+func (h *healingConn) RemoteAddr() net.Addr {
+	c, _, err := h.conn()
+	if err != nil {
+		return nil
+	}
+	return c.RemoteAddr()
+}
+
+func (h *healingConn) SetDeadline(t time.Time) error {
+	c, _, err := h.conn()
+	if err != nil {
+		return err
+	}
+	return c.SetDeadline(t)
+}
+
+func (h *healingConn) SetReadDeadline(t time.Time) error {
+	c, _, err := h.conn()
+	if err != nil {
+		return err
+	}
+	return c.SetReadDeadline(t)
+}
+
+func (h *healingConn) SetWriteDeadline(t time.Time) error {
+	c, _, err := h.conn()
+	if err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
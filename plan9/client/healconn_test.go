@@ -0,0 +1,89 @@
+package client_test
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+	"9fans.net/go/plan9/srv9p"
+)
+
+// TestSelfHealingConn checks that a SelfHealingConn replaces a dropped
+// connection, failing the request lost with it with ErrConnReset,
+// and that the connection is usable again after a fresh attach.
+func TestSelfHealingConn(t *testing.T) {
+	var mu sync.Mutex
+	dials, checks := 0, 0
+	dial := func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		t.Cleanup(func() { c1.Close(); c2.Close() })
+		mu.Lock()
+		dials++
+		first := dials == 1
+		mu.Unlock()
+		if !first {
+			srv := &srv9p.Server{Tree: srv9p.NewTree("ram", "ram", plan9.DMDIR|0777, nil)}
+			go srv.Serve(c2, c2)
+			return c1, nil
+		}
+		// The first server drops the connection, as a NAT
+		// timeout might, on the first walk after attaching.
+		go func() {
+			defer c2.Close()
+			for {
+				f, err := plan9.ReadFcall(c2)
+				if err != nil || f.Type == plan9.Twalk {
+					return
+				}
+				rx := &plan9.Fcall{Type: f.Type + 1, Tag: f.Tag}
+				switch f.Type {
+				case plan9.Tversion:
+					rx.Msize, rx.Version = 8192, f.Version
+				case plan9.Tattach:
+					rx.Qid = plan9.Qid{Type: plan9.QTDIR}
+				}
+				plan9.WriteFcall(c2, rx)
+			}
+		}()
+		return c1, nil
+	}
+	health := func(net.Conn) error {
+		mu.Lock()
+		checks++
+		mu.Unlock()
+		return nil
+	}
+
+	conn, err := client.NewConn(client.SelfHealingConn(dial, health))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fs, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("x"); !errors.Is(err, client.ErrConnReset) {
+		t.Fatalf("Stat across dropped connection = %v, want ErrConnReset", err)
+	}
+
+	fs, err = conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatalf("Attach after reconnect: %v", err)
+	}
+	d, err := fs.Stat("/")
+	if err != nil {
+		t.Fatalf("Stat after reconnect: %v", err)
+	}
+	if d.Mode&plan9.DMDIR == 0 {
+		t.Errorf("Stat after reconnect = mode %v, want a directory", d.Mode)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if dials != 2 || checks != 1 {
+		t.Errorf("dialed %d times with %d health checks, want 2 and 1", dials, checks)
+	}
+}
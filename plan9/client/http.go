@@ -0,0 +1,171 @@
+//go:build !plan9
+// +build !plan9
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"9fans.net/go/plan9"
+)
+
+// HTTPHandler returns an http.Handler that serves the tree of fs
+// at the URLs below prefix, such as "/9p/". A request for prefix+name
+// is a request for the file name in fs:
+//
+//   - GET and HEAD of a file send its contents, with a Content-Length
+//     taken from the file's Dir.Length and support for Range requests,
+//     each range read with Treads at its offset.
+//   - GET of a directory sends a JSON array of its entries' plan9.Dirs,
+//     or an HTML listing if the request's Accept header asks for
+//     text/html first.
+//   - PUT of a file writes the request body to it, truncating it if it
+//     exists and otherwise creating it with permissions 0666.
+//     PUT of a directory fails with status 405.
+//
+// Requests for URLs outside prefix and for files that do not exist
+// are passed to next if it is not nil. HTTPHandler does no
+// authentication; wrap it in a handler that does if need be.
+func HTTPHandler(fs *Fsys, prefix string, next http.Handler) http.Handler {
+	return &httpHandler{fs: fs, prefix: prefix, next: next}
+}
+
+type httpHandler struct {
+	fs     *Fsys
+	prefix string
+	next   http.Handler
+}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest, ok := strings.CutPrefix(r.URL.Path, h.prefix)
+	if !ok {
+		h.notFound(w, r)
+		return
+	}
+	name := path.Clean("/" + rest)[1:]
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.get(w, r, name)
+	case http.MethodPut:
+		h.put(w, r, name)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *httpHandler) get(w http.ResponseWriter, r *http.Request, name string) {
+	d, err := h.fs.Stat(name)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	if d.Mode&plan9.DMDIR != 0 {
+		dirs, err := h.fs.ReadDirSorted(name)
+		if err != nil {
+			h.error(w, r, err)
+			return
+		}
+		if preferHTML(r) {
+			serveDirHTML(w, r, dirs)
+			return
+		}
+		if dirs == nil {
+			dirs = []*plan9.Dir{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(dirs)
+		return
+	}
+	fid, err := h.fs.Open(name, plan9.OREAD)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	defer fid.Close()
+	// ServeContent sets Content-Length and answers Range requests,
+	// reading through the SectionReader at the ranges' offsets.
+	mtime := time.Unix(int64(d.Mtime), 0)
+	http.ServeContent(w, r, d.Name, mtime, io.NewSectionReader(fid, 0, int64(d.Length)))
+}
+
+func (h *httpHandler) put(w http.ResponseWriter, r *http.Request, name string) {
+	if d, err := h.fs.Stat(name); err == nil && d.Mode&plan9.DMDIR != 0 {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "cannot PUT a directory", http.StatusMethodNotAllowed)
+		return
+	}
+	status := http.StatusNoContent
+	fid, err := h.fs.OpenTrunc(name)
+	if errors.Is(err, ErrNotExist) {
+		fid, err = h.fs.Create(name, plan9.OWRITE, 0666)
+		status = http.StatusCreated
+	}
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	_, err = io.Copy(fid, r.Body)
+	if err1 := fid.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	w.WriteHeader(status)
+}
+
+// preferHTML reports whether r lists text/html in its Accept header
+// ahead of application/json, as browsers do.
+func preferHTML(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	i := strings.Index(accept, "text/html")
+	j := strings.Index(accept, "application/json")
+	return i >= 0 && (j < 0 || i < j)
+}
+
+func serveDirHTML(w http.ResponseWriter, r *http.Request, dirs []*plan9.Dir) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<title>%s</title>\n<pre>\n", html.EscapeString(r.URL.Path))
+	for _, d := range dirs {
+		name := d.Name
+		u := url.URL{Path: path.Join(r.URL.Path, name)}
+		if d.Mode&plan9.DMDIR != 0 {
+			name += "/"
+			u.Path += "/"
+		}
+		fmt.Fprintf(w, "%s %10d <a href=\"%s\">%s</a>\n", d.Mode, d.Length,
+			html.EscapeString(u.String()), html.EscapeString(name))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+}
+
+func (h *httpHandler) notFound(w http.ResponseWriter, r *http.Request) {
+	if h.next != nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// error replies to r with the status matching err.
+func (h *httpHandler) error(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrNotExist):
+		h.notFound(w, r)
+	case errors.Is(err, ErrPermission):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
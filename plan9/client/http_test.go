@@ -0,0 +1,91 @@
+//go:build !plan9
+// +build !plan9
+
+package client_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	fs := newRAMFsys(t)
+	makeTree(t, fs, "dir/", "dir/a", "dir/b/")
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "next", http.StatusTeapot)
+	})
+	srv := httptest.NewServer(client.HTTPHandler(fs, "/9p/", next))
+	defer srv.Close()
+
+	do := func(method, path, body string, hdr ...string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i+1 < len(hdr); i += 2 {
+			req.Header.Set(hdr[i], hdr[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(data)
+	}
+
+	if resp, _ := do("PUT", "/9p/dir/new", "hello, world\n"); resp.StatusCode != http.StatusCreated {
+		t.Errorf("PUT of new file: status %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	if resp, _ := do("PUT", "/9p/dir/new", "0123456789"); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("PUT of existing file: status %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	resp, body := do("GET", "/9p/dir/new", "")
+	if resp.StatusCode != http.StatusOK || body != "0123456789" || resp.ContentLength != 10 {
+		t.Errorf("GET = %d, %q, length %d, want 200, 0123456789, length 10", resp.StatusCode, body, resp.ContentLength)
+	}
+	resp, body = do("GET", "/9p/dir/new", "", "Range", "bytes=3-5")
+	if resp.StatusCode != http.StatusPartialContent || body != "345" {
+		t.Errorf("GET of range = %d, %q, want 206, 345", resp.StatusCode, body)
+	}
+
+	resp, body = do("GET", "/9p/dir", "")
+	var dirs []plan9.Dir
+	if err := json.Unmarshal([]byte(body), &dirs); err != nil {
+		t.Fatalf("GET of directory: %v in %q", err, body)
+	}
+	var names []string
+	for _, d := range dirs {
+		names = append(names, d.Name)
+	}
+	if strings.Join(names, " ") != "a b new" || dirs[1].Qid.Type&plan9.QTDIR == 0 {
+		t.Errorf("GET of directory returned %v, want a b/ new", names)
+	}
+	resp, body = do("GET", "/9p/dir/", "", "Accept", "text/html,application/json")
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") || !strings.Contains(body, `href="/9p/dir/b/"`) {
+		t.Errorf("GET of directory as HTML = %s, %q", ct, body)
+	}
+
+	if resp, _ := do("PUT", "/9p/dir", "x"); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("PUT of directory: status %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+	if resp, _ := do("DELETE", "/9p/dir/a", ""); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE: status %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+	for _, path := range []string{"/9p/missing", "/elsewhere"} {
+		if resp, _ := do("GET", path, ""); resp.StatusCode != http.StatusTeapot {
+			t.Errorf("GET %s: status %d, want the next handler's %d", path, resp.StatusCode, http.StatusTeapot)
+		}
+	}
+}
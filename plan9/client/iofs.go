@@ -0,0 +1,186 @@
+//go:build !plan9
+// +build !plan9
+
+package client
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+
+	"9fans.net/go/plan9"
+)
+
+// FS returns the file tree of fs as an io/fs file system, for code such
+// as html/template.ParseFS, net/http.FS and fs.WalkDir. Names are
+// slash-separated paths relative to the root of fs, as fs.ValidPath
+// requires, and files are opened read-only. The result also implements
+// fs.ReadDirFS, fs.StatFS and fs.GlobFS, and its files implement
+// io.Seeker and io.ReaderAt. Errors are *fs.PathErrors, which match
+// fs.ErrNotExist and the like as the 9P errors they wrap do.
+func (fs *Fsys) FS() fs.FS {
+	return &ioFS{fsys: fs}
+}
+
+type ioFS struct {
+	fsys *Fsys
+}
+
+// walk returns a new Fid for name, which has been checked with
+// fs.ValidPath. It is a clone of the root for ".".
+func (f *ioFS) walk(name string) (*Fid, error) {
+	if name == "." {
+		name = ""
+	}
+	return f.fsys.root.Walk(name)
+}
+
+func (f *ioFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	fid, err := f.walk(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if err := fid.Open(plan9.OREAD); err != nil {
+		fid.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &ioFile{fid: fid, name: name}, nil
+}
+
+// Stat implements fs.StatFS. The root is statted without a walk.
+func (f *ioFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	var d *plan9.Dir
+	var err error
+	if name == "." {
+		d, err = f.fsys.root.Stat()
+	} else {
+		d, err = f.fsys.Stat(name)
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return dirInfo{d}, nil
+}
+
+// ReadDir implements fs.ReadDirFS, returning the entries sorted by name.
+func (f *ioFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		name = ""
+	}
+	dirs, err := f.fsys.ReadDirSorted(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: path.Clean("/" + name)[1:], Err: err}
+	}
+	ents := make([]fs.DirEntry, len(dirs))
+	for i, d := range dirs {
+		ents[i] = dirInfo{d}
+	}
+	return ents, nil
+}
+
+// Glob implements fs.GlobFS, matching each element of pattern
+// against a single listing of the directory it applies to.
+func (f *ioFS) Glob(pattern string) ([]string, error) {
+	// fs.Glob reads directories with ReadDir; hiding Glob keeps
+	// it from calling back here.
+	return fs.Glob(struct{ fs.ReadDirFS }{f}, pattern)
+}
+
+// An ioFile is a file opened by ioFS.Open.
+type ioFile struct {
+	fid  *Fid
+	name string
+	ents []*plan9.Dir // directory entries read but not yet returned
+	eof  bool         // no more directory entries to read
+}
+
+func (f *ioFile) Stat() (fs.FileInfo, error) {
+	d, err := f.fid.Stat()
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: err}
+	}
+	return dirInfo{d}, nil
+}
+
+func (f *ioFile) Read(b []byte) (int, error) {
+	if f.fid.Qid().Type&plan9.QTDIR != 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: ErrIsDir}
+	}
+	if len(b) == 0 {
+		// Fid.Read takes an empty reply as end of file.
+		return 0, nil
+	}
+	n, err := f.fid.Read(b)
+	if err != nil && err != io.EOF {
+		err = &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return n, err
+}
+
+func (f *ioFile) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &fs.PathError{Op: "readat", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, err := f.fid.ReadAt(b, off)
+	if err != nil && err != io.EOF {
+		err = &fs.PathError{Op: "readat", Path: f.name, Err: err}
+	}
+	return n, err
+}
+
+func (f *ioFile) Seek(offset int64, whence int) (int64, error) {
+	off, err := f.fid.Seek(offset, whence)
+	if err != nil {
+		err = &fs.PathError{Op: "seek", Path: f.name, Err: err}
+	}
+	return off, err
+}
+
+// ReadDir implements fs.ReadDirFile, returning entries in the order
+// the server lists them.
+func (f *ioFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if f.fid.Qid().Type&plan9.QTDIR == 0 {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: ErrNotDir}
+	}
+	for !f.eof && (n <= 0 || len(f.ents) < n) {
+		dirs, err := f.fid.Dirread()
+		if errors.Is(err, io.EOF) {
+			f.eof = true
+			break
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: err}
+		}
+		f.ents = append(f.ents, dirs...)
+	}
+	m := len(f.ents)
+	if n > 0 && m > n {
+		m = n
+	}
+	ents := make([]fs.DirEntry, m)
+	for i, d := range f.ents[:m] {
+		ents[i] = dirInfo{d}
+	}
+	f.ents = f.ents[m:]
+	if n > 0 && m == 0 {
+		return ents, io.EOF
+	}
+	return ents, nil
+}
+
+func (f *ioFile) Close() error {
+	return f.fid.Close()
+}
@@ -0,0 +1,118 @@
+//go:build !plan9
+// +build !plan9
+
+package client_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"9fans.net/go/plan9"
+)
+
+func TestFS(t *testing.T) {
+	fsys := newRAMFsys(t)
+	makeTree(t, fsys, "a/", "a/b/", "a/b/empty", "c.txt")
+	for name, data := range map[string]string{"a/x.txt": "hello, world\n", "a/b/y": "y"} {
+		fid, err := fsys.Create(name, plan9.OWRITE, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fid.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		fid.Close()
+	}
+
+	fsfs := fsys.FS()
+	if err := fstest.TestFS(fsfs, "a/x.txt", "a/b/y", "a/b/empty", "c.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.ReadFile(fsfs, "a/x.txt")
+	if err != nil || string(data) != "hello, world\n" {
+		t.Errorf("ReadFile(a/x.txt) = %q, %v, want %q, nil", data, err, "hello, world\n")
+	}
+	matches, err := fs.Glob(fsfs, "a/*.txt")
+	if err != nil || len(matches) != 1 || matches[0] != "a/x.txt" {
+		t.Errorf("Glob(a/*.txt) = %q, %v, want [a/x.txt]", matches, err)
+	}
+
+	_, err = fsfs.Open("missing")
+	var perr *fs.PathError
+	if !errors.As(err, &perr) || perr.Op != "open" || perr.Path != "missing" || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open(missing) = %v, want *fs.PathError matching fs.ErrNotExist", err)
+	}
+	if _, err := fsfs.Open("/a"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Open(/a) = %v, want fs.ErrInvalid", err)
+	}
+}
+
+// TestFSStdlib uses the FS of an Fsys with the standard library code
+// it is meant for, and checks ReadDir(n) on a directory with more
+// entries than n.
+func TestFSStdlib(t *testing.T) {
+	fsys := newRAMFsys(t)
+	makeTree(t, fsys, "d/", "d/1", "d/2", "d/3", "d/e/", "d/e/4")
+	fid, err := fsys.Create("index.html", plan9.OWRITE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid.Write([]byte("<p>hello</p>\n"))
+	fid.Close()
+	fsfs := fsys.FS()
+
+	var walked []string
+	err = fs.WalkDir(fsfs, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, path)
+		return nil
+	})
+	if want := ". d d/1 d/2 d/3 d/e d/e/4 index.html"; err != nil || strings.Join(walked, " ") != want {
+		t.Errorf("WalkDir visited %q, %v, want %q", walked, err, want)
+	}
+
+	f, err := fsfs.Open("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dir := f.(fs.ReadDirFile)
+	n := 0
+	for {
+		ents, err := dir.ReadDir(3)
+		n += len(ents)
+		if err == io.EOF {
+			if len(ents) != 0 {
+				t.Errorf("ReadDir returned %d entries with io.EOF", len(ents))
+			}
+			break
+		}
+		if err != nil || len(ents) == 0 || len(ents) > 3 {
+			t.Fatalf("ReadDir(3) = %d entries, %v", len(ents), err)
+		}
+	}
+	if n != 4 {
+		t.Errorf("ReadDir(3) returned %d entries in all, want 4", n)
+	}
+
+	srv := httptest.NewServer(http.FileServerFS(fsfs))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "<p>hello</p>\n" {
+		t.Errorf("FileServerFS GET /index.html = %d, %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !plan9
+// +build !plan9
+
+package client
+
+import "encoding/json"
+
+// ReadJSON reads the named file and decodes its contents,
+// a JSON value, into v, as json.Unmarshal does.
+func (fs *Fsys) ReadJSON(name string, v interface{}) error {
+	data, err := fs.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteJSON writes the JSON encoding of v to the named file,
+// truncating it if it exists and otherwise creating it with
+// permissions 0666.
+func (fs *Fsys) WriteJSON(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return fs.WriteFile(name, data, 0666)
+}
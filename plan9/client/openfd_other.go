@@ -0,0 +1,15 @@
+//go:build !unix && !plan9
+
+package client
+
+import "io"
+
+// canPassFD reports that file descriptors cannot be passed
+// on this system.
+func canPassFD(rwc io.ReadWriteCloser) bool {
+	return false
+}
+
+func recvFD(rwc io.ReadWriteCloser) (int, error) {
+	return -1, ErrNotSupported
+}
@@ -0,0 +1,47 @@
+//go:build unix
+
+package client
+
+import (
+	"io"
+	"net"
+	"syscall"
+
+	"9fans.net/go/plan9"
+)
+
+// canPassFD reports whether file descriptors can be received over rwc.
+func canPassFD(rwc io.ReadWriteCloser) bool {
+	_, ok := rwc.(*net.UnixConn)
+	return ok
+}
+
+// recvFD receives the file descriptor that a plan9port server sends
+// after an Ropenfd message, attached to a single byte of data.
+func recvFD(rwc io.ReadWriteCloser) (int, error) {
+	uc, ok := rwc.(*net.UnixConn)
+	if !ok {
+		return -1, ErrNotSupported
+	}
+	var b [1]byte
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := uc.ReadMsgUnix(b[:], oob)
+	if err != nil {
+		return -1, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return -1, err
+	}
+	for i := range msgs {
+		fds, err := syscall.ParseUnixRights(&msgs[i])
+		if err != nil || len(fds) == 0 {
+			continue
+		}
+		for _, fd := range fds[1:] {
+			syscall.Close(fd)
+		}
+		return fds[0], nil
+	}
+	return -1, plan9.ProtocolError("Ropenfd without a file descriptor")
+}
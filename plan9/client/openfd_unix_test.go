@@ -0,0 +1,96 @@
+//go:build unix
+
+package client_test
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+)
+
+// TestOpenFD checks that OpenFD receives the descriptor a server
+// passes after Ropenfd and that it reads the file's data.
+func TestOpenFD(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "srv")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	var openfd *plan9.Fcall
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			f, err := plan9.ReadFcall(c)
+			if err != nil {
+				return
+			}
+			rx := &plan9.Fcall{Type: f.Type + 1, Tag: f.Tag}
+			var fd *os.File
+			switch f.Type {
+			case plan9.Tversion:
+				rx.Msize, rx.Version = f.Msize, f.Version
+			case plan9.Tattach:
+				rx.Qid = plan9.Qid{Type: plan9.QTDIR}
+			case plan9.Twalk:
+				rx.Wqid = make([]plan9.Qid, len(f.Wname))
+			case plan9.Topenfd:
+				openfd = f
+				r, w, err := os.Pipe()
+				if err != nil {
+					return
+				}
+				w.WriteString("hello, world\n")
+				w.Close()
+				fd = r
+			}
+			plan9.WriteFcall(c, rx)
+			if fd != nil {
+				c.(*net.UnixConn).WriteMsgUnix([]byte{0}, syscall.UnixRights(int(fd.Fd())), nil)
+				fd.Close()
+			}
+		}
+	}()
+
+	conn, err := client.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fs, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.OpenFD("dir/file", plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if openfd.Mode != plan9.OREAD {
+		t.Errorf("Topenfd mode %d, want OREAD", openfd.Mode)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello, world\n" {
+		t.Errorf("read %q from OpenFD file, want %q", data, "hello, world\n")
+	}
+}
+
+// TestOpenFDNotSupported checks that OpenFD needs a Unix domain socket.
+func TestOpenFDNotSupported(t *testing.T) {
+	if _, err := newRAMFsys(t).OpenFD("file", plan9.OREAD); err != client.ErrNotSupported {
+		t.Errorf("OpenFD over a pipe = %v, want ErrNotSupported", err)
+	}
+}
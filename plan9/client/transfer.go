@@ -0,0 +1,93 @@
+//go:build !plan9
+// +build !plan9
+
+package client
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"9fans.net/go/plan9"
+)
+
+// iounit returns the largest amount of data that fits in one
+// Tread or Twrite on fs's connection.
+func (fs *Fsys) iounit() int {
+	conn, err := fs.root.conn()
+	if err != nil {
+		return 8192
+	}
+	return int(conn.msize - plan9.IOHDRSZ)
+}
+
+// Upload copies the local file localPath to remotePath in fs,
+// truncating remotePath if it exists and otherwise creating it with
+// the local file's permission bits. The data is sent in writes of
+// the largest size the connection allows. Upload then tries to set
+// the remote modification time to the local one, ignoring any
+// error, since not all servers allow it.
+func (fs *Fsys) Upload(localPath, remotePath string) error {
+	lf, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+	info, err := lf.Stat()
+	if err != nil {
+		return err
+	}
+
+	rf, err := fs.OpenTrunc(remotePath)
+	if errors.Is(err, ErrNotExist) {
+		rf, err = fs.Create(remotePath, plan9.OWRITE, plan9.Perm(info.Mode().Perm()))
+	}
+	if err != nil {
+		return err
+	}
+	// Hide lf's WriteTo so that CopyBuffer uses buf.
+	_, err = io.CopyBuffer(rf, struct{ io.Reader }{lf}, make([]byte, fs.iounit()))
+	if cerr := rf.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	var d plan9.Dir
+	d.Null()
+	d.Mtime = uint32(info.ModTime().Unix())
+	fs.Wstat(remotePath, &d)
+	return nil
+}
+
+// Download copies remotePath in fs to the local file localPath,
+// creating or truncating it, and sets its modification time
+// to the remote one.
+func (fs *Fsys) Download(remotePath, localPath string) error {
+	rf, err := fs.Open(remotePath, plan9.OREAD)
+	if err != nil {
+		return err
+	}
+	defer rf.Close()
+	d, err := rf.Stat()
+	if err != nil {
+		return err
+	}
+
+	lf, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	// Hide lf's ReadFrom so that CopyBuffer uses buf.
+	_, err = io.CopyBuffer(struct{ io.Writer }{lf}, rf, make([]byte, fs.iounit()))
+	if cerr := lf.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	mtime := time.Unix(int64(d.Mtime), 0)
+	return os.Chtimes(localPath, mtime, mtime)
+}
@@ -0,0 +1,221 @@
+//go:build !plan9
+// +build !plan9
+
+package client
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"9fans.net/go/plan9"
+)
+
+// SkipDir and SkipAll are the io/fs values of the same name,
+// which a WalkFunc can return to direct Walk as described there.
+var (
+	SkipDir = fs.SkipDir
+	SkipAll = fs.SkipAll
+)
+
+// A WalkFunc is the type of the function called by Walk for each file
+// or directory, with the same meaning as filepath.WalkFunc: path is
+// the root argument of Walk joined with the file's path below it,
+// and err reports a problem stating the root or reading a directory.
+//
+// If the function returns SkipDir when invoked on a directory,
+// Walk skips the directory's contents; when invoked on a file,
+// Walk skips the remaining files in the containing directory.
+// If it returns SkipAll, Walk stops without error.
+// Any other non-nil error stops Walk, which then returns that error.
+type WalkFunc func(path string, d *plan9.Dir, err error) error
+
+// Walk walks the file tree of fsys rooted at root, depth first,
+// calling fn for each file or directory in the tree, including root.
+// Directory entries are visited in lexical order.
+// Each directory is read completely, and its fid clunked,
+// before Walk descends into it, so Walk holds at most one
+// directory fid at a time however deep the tree.
+func Walk(fsys *Fsys, root string, fn WalkFunc) error {
+	d, err := fsys.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walk(fsys, root, d, fn)
+	}
+	if err == SkipDir || err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walk(fsys *Fsys, name string, d *plan9.Dir, fn WalkFunc) error {
+	if d.Qid.Type&plan9.QTDIR == 0 {
+		return fn(name, d, nil)
+	}
+	dirs, err := fsys.ReadDir(name)
+	err1 := fn(name, d, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Name < dirs[j].Name })
+	for _, d := range dirs {
+		err := walk(fsys, path.Join(name, d.Name), d, fn)
+		if err != nil && (err != SkipDir || d.Qid.Type&plan9.QTDIR == 0) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Find walks the tree rooted at the directory root, depth first,
+// and returns the paths, relative to root, of the entries d for which
+// pred(d) returns true. The root itself is not passed to pred.
+// If an error occurs, Find returns the paths found so far and the error.
+func (fs *Fsys) Find(root string, pred func(plan9.Dir) bool) ([]string, error) {
+	root = path.Clean(root)
+	var paths []string
+	err := Walk(fs, root, func(p string, d *plan9.Dir, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		if pred(*d) {
+			paths = append(paths, strings.TrimPrefix(p[len(root):], "/"))
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// A WalkOption configures ParallelWalkDir.
+type WalkOption func(*walkOptions)
+
+type walkOptions struct {
+	maxDepth int // < 0 for no limit
+}
+
+// WithMaxDepth limits ParallelWalkDir to entries at most n levels
+// below the root. Directories at depth n are passed to the WalkDirFunc
+// but not read, so WithMaxDepth(0) visits only the root itself.
+// Without it, a server presenting a cyclic tree is walked forever.
+func WithMaxDepth(n int) WalkOption {
+	return func(o *walkOptions) { o.maxDepth = n }
+}
+
+// ParallelWalkDir is like fs.WalkDir but reads up to parallelism
+// directories at once, so that deep trees are not walked one network
+// round trip at a time. It calls fn concurrently from several
+// goroutines, and fn must be safe for that.
+//
+// The entries of each directory are passed to fn one at a time, in
+// lexical order; fn returning SkipDir for a file skips the remaining
+// entries of its directory, as with fs.WalkDir. A directory is read,
+// and its entries visited, only after fn has returned for the
+// directory itself, but otherwise calls for different directories
+// are not ordered. If fn returns SkipAll or an error, ParallelWalkDir
+// stops reading new directories and returns that error (or nil for
+// SkipAll) once calls already underway have finished.
+func (fs *Fsys) ParallelWalkDir(root string, parallelism int, fn fs.WalkDirFunc, opts ...WalkOption) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	w := &parallelWalker{
+		fsys: fs,
+		fn:   fn,
+		opt:  walkOptions{maxDepth: -1},
+		sem:  make(chan struct{}, parallelism),
+	}
+	for _, o := range opts {
+		o(&w.opt)
+	}
+
+	d, err := fs.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		de := dirInfo{d}
+		err = fn(root, de, nil)
+		if err == nil && de.IsDir() && w.opt.maxDepth != 0 {
+			w.wg.Add(1)
+			go w.walkDir(root, de, 0)
+			w.wg.Wait()
+			err = w.err
+		}
+	}
+	if err == SkipDir || err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+type parallelWalker struct {
+	fsys *Fsys
+	fn   fs.WalkDirFunc
+	opt  walkOptions
+	sem  chan struct{} // limits the number of directories being walked
+	wg   sync.WaitGroup
+
+	mu  sync.Mutex
+	err error // first error, or SkipAll, returned by fn
+}
+
+func (w *parallelWalker) stopped() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err != nil
+}
+
+func (w *parallelWalker) stop(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// walkDir reads the directory name, at the given depth below the
+// root, and passes its entries to fn, starting a new walkDir for each
+// subdirectory that fn does not skip.
+func (w *parallelWalker) walkDir(name string, d fs.DirEntry, depth int) {
+	defer w.wg.Done()
+	w.sem <- struct{}{}
+	defer func() { <-w.sem }()
+
+	if w.stopped() {
+		return
+	}
+	dirs, err := w.fsys.ReadDir(name)
+	if err != nil {
+		// As in fs.WalkDir, fn is called a second time for the directory.
+		if err := w.fn(name, d, err); err != nil && err != SkipDir {
+			w.stop(err)
+		}
+		return
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Name < dirs[j].Name })
+	for _, dir := range dirs {
+		if w.stopped() {
+			return
+		}
+		p := path.Join(name, dir.Name)
+		e := dirInfo{dir}
+		switch err := w.fn(p, e, nil); {
+		case err == SkipDir && e.IsDir():
+			continue
+		case err == SkipDir:
+			return
+		case err != nil:
+			w.stop(err)
+			return
+		}
+		if e.IsDir() && (w.opt.maxDepth < 0 || depth+1 < w.opt.maxDepth) {
+			w.wg.Add(1)
+			go w.walkDir(p, e, depth+1)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+//go:build !plan9
+
+package client
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/srv9p"
+)
+
+// BenchmarkWalkCached compares stats of the files in one directory
+// walked from the root each time, as Fsys.Stat does, with stats walked
+// from a fid held on the directory, which a fid-caching Fsys would do.
+// Each stat uses a different file name, as a build tool probing for
+// files would. Both ways send one Twalk per stat, so the difference is
+// only the cost of the path elements resolved again; running with
+// several directory sizes shows whether that depends on the file count.
+func BenchmarkWalkCached(b *testing.B) {
+	const dir = "a/b/c/d"
+	for _, nfile := range []int{10, 100, 1000} {
+		tree := srv9p.NewTree("bench", "bench", plan9.DMDIR|0777, nil)
+		f := tree.Root
+		for _, elem := range []string{"a", "b", "c", "d"} {
+			var err error
+			if f, err = f.Create(elem, "bench", plan9.DMDIR|0777, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+		names := make([]string, nfile)
+		for i := range names {
+			names[i] = fmt.Sprintf("file%d", i)
+			if _, err := f.Create(names[i], "bench", 0666, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		c1, c2 := net.Pipe()
+		go (&srv9p.Server{Tree: tree}).Serve(c2, c2)
+		conn, err := NewConn(c1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		fs, err := conn.Attach(nil, "bench", "")
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(fmt.Sprintf("uncached/files=%d", nfile), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := fs.Stat(path.Join(dir, names[i%nfile])); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("cached/files=%d", nfile), func(b *testing.B) {
+			dfid, err := fs.root.Walk(dir)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer dfid.Close()
+			for i := 0; i < b.N; i++ {
+				fid, err := dfid.Walk(names[i%nfile])
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, err = fid.Stat()
+				fid.Close()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		c1.Close()
+		c2.Close()
+	}
+}
@@ -0,0 +1,209 @@
+package client_test
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+)
+
+// makeTree creates the named files in fs.
+// Names ending in a slash are created as directories.
+func makeTree(t *testing.T, fs *client.Fsys, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		var perm plan9.Perm = 0666
+		mode := uint8(plan9.OWRITE)
+		if strings.HasSuffix(name, "/") {
+			name = strings.TrimSuffix(name, "/")
+			perm = plan9.DMDIR | 0777
+			mode = plan9.OREAD
+		}
+		fid, err := fs.Create(name, mode, perm)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		fid.Close()
+	}
+}
+
+func TestFind(t *testing.T) {
+	fs := newRAMFsys(t)
+	makeTree(t, fs, "a.go", "b.txt", "src/", "src/c.go", "src/lib/", "src/lib/d.go", "src/lib/e.c")
+
+	paths, err := fs.Find("/", func(d plan9.Dir) bool {
+		return strings.HasSuffix(d.Name, ".go")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	if want := []string{"a.go", "src/c.go", "src/lib/d.go"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("Find = %q, want %q", paths, want)
+	}
+
+	paths, err = fs.Find("src", func(d plan9.Dir) bool { return d.Mode&plan9.DMDIR != 0 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"lib"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("Find(src, isdir) = %q, want %q", paths, want)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	conn := newRAMConn(t)
+	fs, err := conn.Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeTree(t, fs, "a", "b/", "b/c", "b/d/", "b/d/e", "skip/", "skip/f", "z")
+
+	var visited []string
+	err = client.Walk(fs, "/", func(path string, d *plan9.Dir, err error) error {
+		if err != nil {
+			return err
+		}
+		visited = append(visited, path)
+		if d.Name == "skip" {
+			return client.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/", "/a", "/b", "/b/c", "/b/d", "/b/d/e", "/skip", "/z"}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("Walk visited %q, want %q", visited, want)
+	}
+
+	if n := conn.CurrentFidCount(); n != 1 {
+		t.Errorf("%d fids allocated after Walk, want 1", n)
+	}
+
+	err = client.Walk(fs, "missing", func(path string, d *plan9.Dir, err error) error {
+		return err
+	})
+	if err == nil {
+		t.Errorf("Walk(missing) succeeded")
+	}
+}
+
+func TestParallelWalkDir(t *testing.T) {
+	fsys := newRAMFsys(t)
+	makeTree(t, fsys, "a", "b/", "b/c", "b/d/", "b/d/e", "b/d/f/", "b/d/f/g", "skip/", "skip/h", "z")
+
+	walk := func(opts ...client.WalkOption) []string {
+		var mu sync.Mutex
+		seen := make(map[string]bool)
+		var visited []string
+		err := fsys.ParallelWalkDir("/", 4, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if p != "/" && !seen[path.Dir(p)] {
+				t.Errorf("visited %s before its parent", p)
+			}
+			seen[p] = true
+			visited = append(visited, p)
+			if d.Name() == "skip" {
+				return client.SkipDir
+			}
+			return nil
+		}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(visited)
+		return visited
+	}
+
+	want := []string{"/", "/a", "/b", "/b/c", "/b/d", "/b/d/e", "/b/d/f", "/b/d/f/g", "/skip", "/z"}
+	if visited := walk(); !reflect.DeepEqual(visited, want) {
+		t.Errorf("ParallelWalkDir visited %q, want %q", visited, want)
+	}
+	want = []string{"/", "/a", "/b", "/b/c", "/b/d", "/skip", "/z"}
+	if visited := walk(client.WithMaxDepth(2)); !reflect.DeepEqual(visited, want) {
+		t.Errorf("ParallelWalkDir with max depth 2 visited %q, want %q", visited, want)
+	}
+}
+
+func TestWalkError(t *testing.T) {
+	conn := newRAMConn(t)
+	if err := conn.SetMaxWalkElem(2); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := conn.Attach(nil, "ram", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeTree(t, fsys, "a/", "a/b/", "a/b/c/", "a/b/c/d")
+
+	if _, err := fsys.Stat("a/b/c/d"); err != nil {
+		t.Fatalf("Stat in two walks: %v", err)
+	}
+	for _, tt := range []struct {
+		name   string
+		walked int
+	}{
+		{"x/y", 0},
+		{"a/x", 1},
+		{"a/b/c/x", 3},
+		{"a/b/c/d/x", 4},
+	} {
+		name := tt.name
+		_, err := fsys.Stat(name)
+		var werr *client.WalkError
+		if !errors.As(err, &werr) {
+			t.Errorf("Stat(%s) = %v, want *WalkError", name, err)
+			continue
+		}
+		if werr.Walked != tt.walked {
+			t.Errorf("Stat(%s) walked %d elements, want %d", name, werr.Walked, tt.walked)
+		}
+	}
+	if n := conn.CurrentFidCount(); n != 1 {
+		t.Errorf("%d fids allocated after failed walks, want 1", n)
+	}
+}
+
+// TestDeepWalk checks that a path longer than MAXWELEM elements is
+// walked in several Twalks, which the server would reject as
+// malformed otherwise, and that the resulting fid is the file's.
+func TestDeepWalk(t *testing.T) {
+	fs := newRAMFsys(t)
+	elems := make([]string, 2*plan9.MAXWELEM)
+	for i := range elems {
+		elems[i] = fmt.Sprintf("d%d", i)
+	}
+	for i := 1; i < len(elems); i++ {
+		makeTree(t, fs, strings.Join(elems[:i], "/")+"/")
+	}
+	name := strings.Join(elems, "/")
+	fid, err := fs.Create(name, plan9.OWRITE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fid.Qid()
+	fid.Close()
+
+	fid, err = fs.Open(name, plan9.OREAD)
+	if err != nil {
+		t.Fatalf("Open %d-element path: %v", len(elems), err)
+	}
+	defer fid.Close()
+	if got := fid.Qid(); got != want {
+		t.Errorf("Open %d-element path: qid %v, want %v", len(elems), got, want)
+	}
+}
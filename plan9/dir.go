@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type ProtocolError string
@@ -14,9 +16,17 @@ func (e ProtocolError) Error() string {
 }
 
 const (
-	STATMAX = 65535
+	// MaxStatSize is the size in bytes of the largest stat record,
+	// counting its leading two-byte size, that the stat[n] field of
+	// a Twstat or Rstat message can carry.
+	MaxStatSize = 65535
+
+	STATMAX = MaxStatSize
 )
 
+// statFixLen is the size of an encoded Dir with empty strings.
+const statFixLen = 2 + 2 + 4 + 13 + 4 + 4 + 4 + 8 + 4*2
+
 type Dir struct {
 	Type   uint16
 	Dev    uint32
@@ -29,6 +39,13 @@ type Dir struct {
 	Uid    string
 	Gid    string
 	Muid   string
+
+	// 9P2000.u extensions. A Dir decoded from a plain 9P2000
+	// stat record has NOUID for each numeric id.
+	Extension string // symlink target or device numbers of a special file
+	Uidnum    uint32 // numeric Uid
+	Gidnum    uint32 // numeric Gid
+	Muidnum   uint32 // numeric Muid
 }
 
 var nullDir = Dir{
@@ -43,6 +60,10 @@ var nullDir = Dir{
 	"",
 	"",
 	"",
+	"",
+	^uint32(0),
+	^uint32(0),
+	^uint32(0),
 }
 
 func (d *Dir) IsNull() bool {
@@ -53,7 +74,7 @@ func (d *Dir) Null() {
 	*d = nullDir
 }
 
-func pdir(b []byte, d *Dir) []byte {
+func pdir(b []byte, d *Dir, dialect Dialect) []byte {
 	n := len(b)
 	b = pbit16(b, 0) // length, filled in later
 	b = pbit16(b, d.Type)
@@ -67,15 +88,42 @@ func pdir(b []byte, d *Dir) []byte {
 	b = pstring(b, d.Uid)
 	b = pstring(b, d.Gid)
 	b = pstring(b, d.Muid)
+	if dialect == Dialect9P2000u {
+		b = pstring(b, d.Extension)
+		b = pbit32(b, d.Uidnum)
+		b = pbit32(b, d.Gidnum)
+		b = pbit32(b, d.Muidnum)
+	}
 	pbit16(b[0:n], uint16(len(b)-(n+2)))
 	return b
 }
 
+// Bytes returns the stat record for d.
+// It returns an error if the record would be larger than MaxStatSize.
 func (d *Dir) Bytes() ([]byte, error) {
-	return pdir(nil, d), nil
+	return d.BytesDialect(Dialect9P2000)
+}
+
+// BytesDialect is like Bytes but encodes d in the given dialect.
+// Only in Dialect9P2000u are the 9P2000.u fields included.
+func (d *Dir) BytesDialect(dialect Dialect) ([]byte, error) {
+	n := statFixLen + len(d.Name) + len(d.Uid) + len(d.Gid) + len(d.Muid)
+	if dialect == Dialect9P2000u {
+		n += 2 + len(d.Extension) + 3*4
+	}
+	if n > MaxStatSize {
+		return nil, ProtocolError(fmt.Sprintf("stat record too large: %d bytes", n))
+	}
+	return pdir(nil, d, dialect), nil
 }
 
 func UnmarshalDir(b []byte) (d *Dir, err error) {
+	return UnmarshalDirDialect(b, Dialect9P2000)
+}
+
+// UnmarshalDirDialect is like UnmarshalDir but decodes a stat record
+// in the given dialect.
+func UnmarshalDirDialect(b []byte, dialect Dialect) (d *Dir, err error) {
 	defer func() {
 		if v := recover(); v != nil {
 			d = nil
@@ -83,6 +131,9 @@ func UnmarshalDir(b []byte) (d *Dir, err error) {
 		}
 	}()
 
+	if len(b) > MaxStatSize {
+		return nil, ProtocolError(fmt.Sprintf("stat record too large: %d bytes", len(b)))
+	}
 	n, b := gbit16(b)
 	if int(n) != len(b) {
 		panic(1)
@@ -100,6 +151,14 @@ func UnmarshalDir(b []byte) (d *Dir, err error) {
 	d.Uid, b = gstring(b)
 	d.Gid, b = gstring(b)
 	d.Muid, b = gstring(b)
+	if dialect == Dialect9P2000u {
+		d.Extension, b = gstring(b)
+		d.Uidnum, b = gbit32(b)
+		d.Gidnum, b = gbit32(b)
+		d.Muidnum, b = gbit32(b)
+	} else {
+		d.Uidnum, d.Gidnum, d.Muidnum = NOUID, NOUID, NOUID
+	}
 
 	if len(b) != 0 {
 		panic(1)
@@ -192,7 +251,16 @@ func parseDir(s string) (*Dir, error) {
 	return d, nil
 }
 
+// dumpsome formats up to 64 bytes of b for Fcall.String,
+// in a form that ParseFcall reads back: directory entries,
+// a quoted string if the bytes are text, or hex. Text that
+// needs no escapes is quoted with single quotes; other text,
+// such as text containing newlines or quotes, is quoted as by
+// strconv.Quote.
 func dumpsome(b []byte) string {
+	if len(b) == 0 {
+		return "''"
+	}
 	// Is this all directories?
 	if s, ok := dumpDirs(b); ok {
 		return s
@@ -200,20 +268,33 @@ func dumpsome(b []byte) string {
 
 	if len(b) > 64 {
 		b = b[0:64]
+		// Drop a rune cut short, so that text stays text.
+		for i := len(b) - 1; i >= 0 && i > len(b)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(b[i]) {
+				if !utf8.FullRune(b[i:]) {
+					b = b[:i]
+				}
+				break
+			}
+		}
 	}
 
-	printable := true
-	for _, c := range b {
-		if (c != 0 && c != '\n' && c != '\t' && c < ' ') || c > 127 {
-			printable = false
-			break
+	if !utf8.Valid(b) {
+		return fmt.Sprintf("%x", b)
+	}
+	plain := true
+	for _, r := range string(b) {
+		switch {
+		case r == '\'' || r == '\n' || r == '\t' || r == '\r':
+			plain = false
+		case !unicode.IsPrint(r):
+			return fmt.Sprintf("%x", b)
 		}
 	}
-
-	if printable {
-		return strconv.Quote(string(b))
+	if plain {
+		return "'" + string(b) + "'"
 	}
-	return fmt.Sprintf("%x", b)
+	return strconv.Quote(string(b))
 }
 
 func dumpDirs(b []byte) (string, bool) {
@@ -359,12 +440,38 @@ func (q Qid) String() string {
 	if q.Type&QTAUTH != 0 {
 		t += "A"
 	}
+	if q.Type&QTMOUNT != 0 {
+		t += "m"
+	}
+	if q.Type&QTTMP != 0 {
+		t += "t"
+	}
+	if q.Type&QTSYMLINK != 0 {
+		t += "L"
+	}
 	if t != "" {
 		t = "." + t
 	}
 	return fmt.Sprintf("%#x.%d%s", q.Path, q.Vers, t)
 }
 
+// MarshalText implements encoding.TextMarshaler, so that Qids can be
+// JSON object keys. The text is that of String, such as 0x2a.3.d.
+func (q Qid) MarshalText() ([]byte, error) {
+	return []byte(q.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler,
+// parsing the text of MarshalText.
+func (q *Qid) UnmarshalText(text []byte) error {
+	qq, err := parseQid(string(text))
+	if err != nil {
+		return err
+	}
+	*q = qq
+	return nil
+}
+
 func parseQid(s string) (Qid, error) {
 	orig := s
 	var q Qid
@@ -385,6 +492,15 @@ func parseQid(s string) (Qid, error) {
 	if ts, ok = strings.CutPrefix(ts, "A"); ok {
 		q.Type |= QTAUTH
 	}
+	if ts, ok = strings.CutPrefix(ts, "m"); ok {
+		q.Type |= QTMOUNT
+	}
+	if ts, ok = strings.CutPrefix(ts, "t"); ok {
+		q.Type |= QTTMP
+	}
+	if ts, ok = strings.CutPrefix(ts, "L"); ok {
+		q.Type |= QTSYMLINK
+	}
 	if err1 != nil || err2 != nil || ts != "" {
 		return Qid{}, fmt.Errorf("invalid qid %q", orig)
 	}
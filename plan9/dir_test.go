@@ -0,0 +1,84 @@
+package plan9_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"9fans.net/go/plan9"
+)
+
+func TestDirMaxStatSize(t *testing.T) {
+	var d plan9.Dir
+	d.Uid = "glenda"
+	b, err := d.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed := len(b) - len(d.Uid)
+
+	d.Name = strings.Repeat("x", plan9.MaxStatSize-fixed-len(d.Uid))
+	b, err = d.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes with %d-byte record: %v", plan9.MaxStatSize, err)
+	}
+	if len(b) != plan9.MaxStatSize {
+		t.Fatalf("len(Bytes()) = %d, want %d", len(b), plan9.MaxStatSize)
+	}
+	if _, err := plan9.UnmarshalDir(b); err != nil {
+		t.Errorf("UnmarshalDir of %d-byte record: %v", len(b), err)
+	}
+	f := &plan9.Fcall{Type: plan9.Twstat, Stat: b}
+	if _, err := f.Bytes(); err != nil {
+		t.Errorf("Twstat with %d-byte record: %v", len(b), err)
+	}
+
+	d.Name += "x"
+	if _, err := d.Bytes(); err == nil {
+		t.Errorf("Bytes with %d-byte record succeeded", plan9.MaxStatSize+1)
+	}
+	f.Stat = append(b, 0)
+	if _, err := f.Bytes(); err == nil {
+		t.Errorf("Twstat with %d-byte record succeeded", len(f.Stat))
+	}
+}
+
+func TestQidText(t *testing.T) {
+	for _, q := range []plan9.Qid{
+		{},
+		{Path: 1, Type: plan9.QTDIR},
+		{Path: 42, Vers: 3},
+		{Path: 1<<64 - 1, Vers: 1<<32 - 1, Type: 0xFE},
+	} {
+		text, err := q.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var q1 plan9.Qid
+		if err := q1.UnmarshalText(text); err != nil || q1 != q {
+			t.Errorf("UnmarshalText(%q) = %v, %v, want %v", text, q1, err, q)
+		}
+	}
+	if got, want := (plan9.Qid{Path: 42, Vers: 3, Type: plan9.QTDIR}).String(), "0x2a.3.d"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+
+	m := map[plan9.Qid]string{{Path: 1, Type: plan9.QTDIR}: "dir", {Path: 2, Type: plan9.QTTMP}: "tmp"}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m1 map[plan9.Qid]string
+	if err := json.Unmarshal(b, &m1); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m1, m) {
+		t.Errorf("JSON round trip of %s = %v, want %v", b, m1, m)
+	}
+
+	var q plan9.Qid
+	if err := q.UnmarshalText([]byte("0x1.0.x")); err == nil {
+		t.Errorf("UnmarshalText(0x1.0.x) succeeded")
+	}
+}
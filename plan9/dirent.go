@@ -0,0 +1,53 @@
+package plan9
+
+// The 9P2000.L directory-reading messages. Treaddir has Fid, Offset
+// and Count; Rreaddir has Data, a sequence of entries in the format
+// read by UnmarshalDirents.
+const (
+	Treaddir = 40
+	Rreaddir = 41
+)
+
+// A Dirent is a directory entry in a 9P2000.L Rreaddir message.
+// Unlike a Dir, it holds only enough to list the directory.
+type Dirent struct {
+	Qid Qid
+
+	// Offset is the directory offset cookie that, passed to Treaddir,
+	// continues the listing after this entry. It is chosen by the
+	// server and need not be a byte count.
+	Offset uint64
+
+	Type uint8 // the file type, as in a Linux dirent's d_type
+	Name string
+}
+
+// AppendDirent appends the Rreaddir encoding of d to b:
+// qid[13] offset[8] type[1] name[s].
+func AppendDirent(b []byte, d Dirent) []byte {
+	b = pqid(b, d.Qid)
+	b = pbit64(b, d.Offset)
+	b = pbit8(b, d.Type)
+	b = pstring(b, d.Name)
+	return b
+}
+
+// UnmarshalDirents decodes the data of an Rreaddir message.
+func UnmarshalDirents(b []byte) (ents []Dirent, err error) {
+	defer func() {
+		if recover() != nil {
+			ents = nil
+			err = ProtocolError("malformed Rreaddir")
+		}
+	}()
+
+	for len(b) > 0 {
+		var d Dirent
+		d.Qid, b = gqid(b)
+		d.Offset, b = gbit64(b)
+		d.Type, b = gbit8(b)
+		d.Name, b = gstring(b)
+		ents = append(ents, d)
+	}
+	return ents, nil
+}
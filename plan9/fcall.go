@@ -2,6 +2,7 @@ package plan9
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -41,6 +42,29 @@ type Fcall struct {
 	Errno     uint32 // Rerror
 	Uid       uint32 // Tattach, Tauth
 	Extension string // Tcreate
+
+	// plan9port extensions
+	Unixfd uint32 // Ropenfd
+}
+
+// A Dialect is a variant of the 9P2000 wire format,
+// chosen by the version negotiated in Tversion.
+type Dialect int
+
+const (
+	Dialect9P2000  Dialect = iota // 9P2000
+	Dialect9P2000u                // 9P2000.u, which adds the Fcall and Dir extension fields
+)
+
+// DialectOf returns the dialect spoken after negotiating version.
+// There is no dialect for 9P2000.L, whose attach, error and file
+// messages differ from 9P2000's; only its Treaddir message, which
+// frames like 9P2000, can be encoded.
+func DialectOf(version string) Dialect {
+	if version == "9P2000.u" {
+		return Dialect9P2000u
+	}
+	return Dialect9P2000
 }
 
 const (
@@ -75,7 +99,39 @@ const (
 	Tmax
 )
 
+// A MsgType is a 9P message type, such as Tversion or Rerror,
+// as found in Fcall.Type.
+type MsgType uint8
+
+// IsError reports whether t is Rerror.
+func (t MsgType) IsError() bool {
+	return t == Rerror
+}
+
+// IsResponse reports whether t is a response (R-message) type,
+// including Rerror and the responses of the extension messages
+// such as Rreaddir and Ropenfd.
+func IsResponse(t MsgType) bool {
+	return responses[t]
+}
+
+// responses holds the R-message types, found by name in types.
+var responses = func() (r [256]bool) {
+	for name, t := range types {
+		if name[0] == 'R' {
+			r[t] = true
+		}
+	}
+	return r
+}()
+
 func (f *Fcall) Bytes() ([]byte, error) {
+	return f.BytesDialect(Dialect9P2000)
+}
+
+// BytesDialect is like Bytes but encodes f in the given dialect.
+// Only in Dialect9P2000u are the 9P2000.u fields sent.
+func (f *Fcall) BytesDialect(dialect Dialect) ([]byte, error) {
 	b := pbit32(nil, 0) // length: fill in later
 	b = pbit8(b, f.Type)
 	b = pbit16(b, f.Tag)
@@ -94,12 +150,18 @@ func (f *Fcall) Bytes() ([]byte, error) {
 		b = pbit32(b, f.Afid)
 		b = pstring(b, f.Uname)
 		b = pstring(b, f.Aname)
+		if dialect == Dialect9P2000u {
+			b = pbit32(b, f.Uid)
+		}
 
 	case Tattach:
 		b = pbit32(b, f.Fid)
 		b = pbit32(b, f.Afid)
 		b = pstring(b, f.Uname)
 		b = pstring(b, f.Aname)
+		if dialect == Dialect9P2000u {
+			b = pbit32(b, f.Uid)
+		}
 
 	case Twalk:
 		b = pbit32(b, f.Fid)
@@ -112,7 +174,7 @@ func (f *Fcall) Bytes() ([]byte, error) {
 			b = pstring(b, f.Wname[i])
 		}
 
-	case Topen:
+	case Topen, Topenfd:
 		b = pbit32(b, f.Fid)
 		b = pbit8(b, f.Mode)
 
@@ -121,8 +183,11 @@ func (f *Fcall) Bytes() ([]byte, error) {
 		b = pstring(b, f.Name)
 		b = pperm(b, f.Perm)
 		b = pbit8(b, f.Mode)
+		if dialect == Dialect9P2000u {
+			b = pstring(b, f.Extension)
+		}
 
-	case Tread:
+	case Tread, Treaddir:
 		b = pbit32(b, f.Fid)
 		b = pbit64(b, f.Offset)
 		b = pbit32(b, f.Count)
@@ -138,6 +203,9 @@ func (f *Fcall) Bytes() ([]byte, error) {
 
 	case Twstat:
 		b = pbit32(b, f.Fid)
+		if len(f.Stat) > MaxStatSize {
+			return nil, ProtocolError("stat record too large")
+		}
 		b = pbit16(b, uint16(len(f.Stat)))
 		b = append(b, f.Stat...)
 
@@ -147,6 +215,9 @@ func (f *Fcall) Bytes() ([]byte, error) {
 
 	case Rerror:
 		b = pstring(b, f.Ename)
+		if dialect == Dialect9P2000u {
+			b = pbit32(b, f.Errno)
+		}
 
 	case Rflush, Rclunk, Rremove, Rwstat:
 		// nothing
@@ -170,7 +241,12 @@ func (f *Fcall) Bytes() ([]byte, error) {
 		b = pqid(b, f.Qid)
 		b = pbit32(b, f.Iounit)
 
-	case Rread:
+	case Ropenfd:
+		b = pqid(b, f.Qid)
+		b = pbit32(b, f.Iounit)
+		b = pbit32(b, f.Unixfd)
+
+	case Rread, Rreaddir:
 		b = pbit32(b, uint32(len(f.Data)))
 		b = append(b, f.Data...)
 
@@ -178,6 +254,9 @@ func (f *Fcall) Bytes() ([]byte, error) {
 		b = pbit32(b, f.Count)
 
 	case Rstat:
+		if len(f.Stat) > MaxStatSize {
+			return nil, ProtocolError("stat record too large")
+		}
 		b = pbit16(b, uint16(len(f.Stat)))
 		b = append(b, f.Stat...)
 	}
@@ -187,6 +266,30 @@ func (f *Fcall) Bytes() ([]byte, error) {
 }
 
 func UnmarshalFcall(b []byte) (f *Fcall, err error) {
+	return UnmarshalFcallDialect(b, Dialect9P2000)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, returning the
+// 9P2000 encoding of f, with its size prefix, as WriteFcall writes it.
+func (f *Fcall) MarshalBinary() ([]byte, error) {
+	return f.Bytes()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, setting f to
+// the message encoded in b, which must hold exactly one 9P2000 message
+// as ReadFcall reads it.
+func (f *Fcall) UnmarshalBinary(b []byte) error {
+	ff, err := UnmarshalFcall(b)
+	if err != nil {
+		return err
+	}
+	*f = *ff
+	return nil
+}
+
+// UnmarshalFcallDialect is like UnmarshalFcall but decodes a message
+// in the given dialect.
+func UnmarshalFcallDialect(b []byte, dialect Dialect) (f *Fcall, err error) {
 	defer func() {
 		if recover() != nil {
 			println("bad fcall at ", b)
@@ -219,12 +322,18 @@ func UnmarshalFcall(b []byte) (f *Fcall, err error) {
 		f.Afid, b = gbit32(b)
 		f.Uname, b = gstring(b)
 		f.Aname, b = gstring(b)
+		if dialect == Dialect9P2000u {
+			f.Uid, b = gbit32(b)
+		}
 
 	case Tattach:
 		f.Fid, b = gbit32(b)
 		f.Afid, b = gbit32(b)
 		f.Uname, b = gstring(b)
 		f.Aname, b = gstring(b)
+		if dialect == Dialect9P2000u {
+			f.Uid, b = gbit32(b)
+		}
 
 	case Twalk:
 		f.Fid, b = gbit32(b)
@@ -239,7 +348,7 @@ func UnmarshalFcall(b []byte) (f *Fcall, err error) {
 			f.Wname[i], b = gstring(b)
 		}
 
-	case Topen:
+	case Topen, Topenfd:
 		f.Fid, b = gbit32(b)
 		f.Mode, b = gbit8(b)
 
@@ -248,8 +357,11 @@ func UnmarshalFcall(b []byte) (f *Fcall, err error) {
 		f.Name, b = gstring(b)
 		f.Perm, b = gperm(b)
 		f.Mode, b = gbit8(b)
+		if dialect == Dialect9P2000u {
+			f.Extension, b = gstring(b)
+		}
 
-	case Tread:
+	case Tread, Treaddir:
 		f.Fid, b = gbit32(b)
 		f.Offset, b = gbit64(b)
 		f.Count, b = gbit32(b)
@@ -283,6 +395,9 @@ func UnmarshalFcall(b []byte) (f *Fcall, err error) {
 
 	case Rerror:
 		f.Ename, b = gstring(b)
+		if dialect == Dialect9P2000u {
+			f.Errno, b = gbit32(b)
+		}
 
 	case Rflush, Rclunk, Rremove, Rwstat:
 		// nothing
@@ -310,7 +425,12 @@ func UnmarshalFcall(b []byte) (f *Fcall, err error) {
 		f.Qid, b = gqid(b)
 		f.Iounit, b = gbit32(b)
 
-	case Rread:
+	case Ropenfd:
+		f.Qid, b = gqid(b)
+		f.Iounit, b = gbit32(b)
+		f.Unixfd, b = gbit32(b)
+
+	case Rread, Rreaddir:
 		n, b = gbit32(b)
 		if len(b) != int(n) {
 			panic(1)
@@ -350,17 +470,17 @@ func (f *Fcall) String() string {
 		return fmt.Sprintf("Rversion tag %d msize %d version '%s'",
 			f.Tag, f.Msize, f.Version)
 	case Tauth:
-		return fmt.Sprintf("Tauth tag %d afid %d uname %s aname %s",
+		return fmt.Sprintf("Tauth tag %d afid %d uname '%s' aname '%s'",
 			f.Tag, f.Afid, f.Uname, f.Aname)
 	case Rauth:
-		return fmt.Sprintf("Rauth tag %d qid %v", f.Tag, f.Qid)
+		return fmt.Sprintf("Rauth tag %d aqid %v", f.Tag, f.Aqid)
 	case Tattach:
-		return fmt.Sprintf("Tattach tag %d fid %d afid %d uname %s aname %s",
+		return fmt.Sprintf("Tattach tag %d fid %d afid %d uname '%s' aname '%s'",
 			f.Tag, f.Fid, f.Afid, f.Uname, f.Aname)
 	case Rattach:
 		return fmt.Sprintf("Rattach tag %d qid %v", f.Tag, f.Qid)
 	case Rerror:
-		return fmt.Sprintf("Rerror tag %d ename %s", f.Tag, f.Ename)
+		return fmt.Sprintf("Rerror tag %d ename '%s'", f.Tag, f.Ename)
 	case Tflush:
 		return fmt.Sprintf("Tflush tag %d oldtag %d", f.Tag, f.Oldtag)
 	case Rflush:
@@ -375,7 +495,7 @@ func (f *Fcall) String() string {
 	case Ropen:
 		return fmt.Sprintf("Ropen tag %d qid %v iounit %d", f.Tag, f.Qid, f.Iounit)
 	case Tcreate:
-		return fmt.Sprintf("Tcreate tag %d fid %d name %s perm %v mode %d",
+		return fmt.Sprintf("Tcreate tag %d fid %d name '%s' perm %v mode %d",
 			f.Tag, f.Fid, f.Name, f.Perm, f.Mode)
 	case Rcreate:
 		return fmt.Sprintf("Rcreate tag %d qid %v iounit %d", f.Tag, f.Qid, f.Iounit)
@@ -383,10 +503,10 @@ func (f *Fcall) String() string {
 		return fmt.Sprintf("Tread tag %d fid %d offset %d count %d",
 			f.Tag, f.Fid, f.Offset, f.Count)
 	case Rread:
-		return fmt.Sprintf("Rread tag %d count %d %s",
+		return fmt.Sprintf("Rread tag %d count %d data %s",
 			f.Tag, len(f.Data), dumpsome(f.Data))
 	case Twrite:
-		return fmt.Sprintf("Twrite tag %d fid %d offset %d count %d %s",
+		return fmt.Sprintf("Twrite tag %d fid %d offset %d count %d data %s",
 			f.Tag, f.Fid, f.Offset, len(f.Data), dumpsome(f.Data))
 	case Rwrite:
 		return fmt.Sprintf("Rwrite tag %d count %d", f.Tag, f.Count)
@@ -406,21 +526,36 @@ func (f *Fcall) String() string {
 			return fmt.Sprintf("Rstat tag %d stat (%d bytes; %v)",
 				f.Tag, len(f.Stat), err)
 		}
-		return fmt.Sprintf("Rstat tag %d stat %v", f.Tag, d)
+		return fmt.Sprintf("Rstat tag %d stat (%v)", f.Tag, d)
 	case Twstat:
 		d, err := UnmarshalDir(f.Stat)
-		if err == nil {
-			return fmt.Sprintf("Twstat tag %d fid %d stat(%d bytes)",
-				f.Tag, f.Fid, len(f.Stat))
+		if err != nil {
+			return fmt.Sprintf("Twstat tag %d fid %d stat (%d bytes; %v)",
+				f.Tag, f.Fid, len(f.Stat), err)
 		}
-		return fmt.Sprintf("Twstat tag %d fid %d stat %v", f.Tag, f.Fid, d)
+		return fmt.Sprintf("Twstat tag %d fid %d stat (%v)", f.Tag, f.Fid, d)
 	case Rwstat:
 		return fmt.Sprintf("Rwstat tag %d", f.Tag)
+	case Treaddir:
+		return fmt.Sprintf("Treaddir tag %d fid %d offset %d count %d",
+			f.Tag, f.Fid, f.Offset, f.Count)
+	case Rreaddir:
+		return fmt.Sprintf("Rreaddir tag %d count %d", f.Tag, len(f.Data))
+	case Topenfd:
+		return fmt.Sprintf("Topenfd tag %d fid %d mode %d", f.Tag, f.Fid, f.Mode)
+	case Ropenfd:
+		return fmt.Sprintf("Ropenfd tag %d qid %v iounit %d unixfd %d", f.Tag, f.Qid, f.Iounit, f.Unixfd)
 	}
 	return fmt.Sprintf("unknown type %d", f.Type)
 }
 
 func ReadFcall(r io.Reader) (*Fcall, error) {
+	return ReadFcallDialect(r, Dialect9P2000)
+}
+
+// ReadFcallDialect is like ReadFcall but decodes the message
+// in the given dialect.
+func ReadFcallDialect(r io.Reader, dialect Dialect) (*Fcall, error) {
 	// 128 bytes should be enough for most messages
 	buf := make([]byte, 128)
 	_, err := io.ReadFull(r, buf[0:4])
@@ -445,11 +580,17 @@ func ReadFcall(r io.Reader) (*Fcall, error) {
 	if err != nil {
 		return nil, err
 	}
-	return UnmarshalFcall(buf)
+	return UnmarshalFcallDialect(buf, dialect)
 }
 
 func WriteFcall(w io.Writer, f *Fcall) error {
-	b, err := f.Bytes()
+	return WriteFcallDialect(w, f, Dialect9P2000)
+}
+
+// WriteFcallDialect is like WriteFcall but encodes f
+// in the given dialect.
+func WriteFcallDialect(w io.Writer, f *Fcall, dialect Dialect) error {
+	b, err := f.BytesDialect(dialect)
 	if err != nil {
 		return err
 	}
@@ -457,6 +598,81 @@ func WriteFcall(w io.Writer, f *Fcall) error {
 	return err
 }
 
+// ReadFcallTo reads an Rread message from r and copies its data
+// to w without holding the whole message in memory, returning the
+// number of data bytes copied. If the message is an Rerror, the error
+// has the text of its Ename; any other message is a protocol error.
+// The tag of the message is not returned, so ReadFcallTo suits
+// streams with one outstanding request at a time.
+// If writing to w fails, the rest of the message is left unread in r.
+func ReadFcallTo(r io.Reader, w io.Writer) (n int64, err error) {
+	var hdr [4 + 1 + 2 + 4]byte
+	if _, err := io.ReadFull(r, hdr[:7]); err != nil {
+		return 0, err
+	}
+	size, _ := gbit32(hdr[:])
+	if size < 7 {
+		return 0, ProtocolError("invalid length")
+	}
+	if hdr[4] != Rread {
+		buf := make([]byte, size)
+		copy(buf, hdr[:7])
+		if _, err := io.ReadFull(r, buf[7:]); err != nil {
+			return 0, err
+		}
+		f, err := UnmarshalFcall(buf)
+		if err != nil {
+			return 0, err
+		}
+		if f.Type == Rerror {
+			return 0, errors.New(f.Ename)
+		}
+		return 0, ProtocolError("unexpected message " + f.String())
+	}
+	if _, err := io.ReadFull(r, hdr[7:]); err != nil {
+		return 0, err
+	}
+	count, _ := gbit32(hdr[7:])
+	if size != uint32(len(hdr))+count {
+		return 0, ProtocolError("malformed Rread")
+	}
+	n, err = io.CopyN(w, r, int64(count))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// WriteFcallFrom writes the Twrite or Rread message header to w followed
+// by count bytes of data read from data, without holding the data in memory.
+// Any header.Data is ignored. If data ends before count bytes, the message
+// written is incomplete and WriteFcallFrom returns io.ErrUnexpectedEOF.
+func WriteFcallFrom(w io.Writer, header *Fcall, data io.Reader, count uint32) error {
+	if header.Type != Twrite && header.Type != Rread {
+		return ProtocolError("WriteFcallFrom of non-data message")
+	}
+	h := *header
+	h.Data = nil
+	b, err := h.Bytes()
+	if err != nil {
+		return err
+	}
+	size := uint64(len(b)) + uint64(count)
+	if size > 1<<32-1 {
+		return ProtocolError("message too long")
+	}
+	pbit32(b[0:0], uint32(size))
+	pbit32(b[len(b)-4:len(b)-4], count)
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	_, err = io.CopyN(w, data, int64(count))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
 var types = map[string]uint8{
 	"Tversion": Tversion,
 	"Rversion": Rversion,
@@ -485,6 +701,11 @@ var types = map[string]uint8{
 	"Rstat":    Rstat,
 	"Twstat":   Twstat,
 	"Rwstat":   Rwstat,
+	"Treaddir": Treaddir,
+	"Rreaddir": Rreaddir,
+
+	"Topenfd": Topenfd,
+	"Ropenfd": Ropenfd,
 }
 
 var modes = map[string]uint8{
@@ -515,6 +736,7 @@ func ParseFcall(s string) (*Fcall, error) {
 		name, s, _ = strings.Cut(s, " ")
 		s = strings.TrimSpace(s)
 		var arg string
+		quoted := false
 		if strings.HasPrefix(s, "(") {
 			i := strings.Index(s, ")")
 			if i < 0 {
@@ -533,6 +755,15 @@ func ParseFcall(s string) (*Fcall, error) {
 				return nil, fmt.Errorf("missing closing quote")
 			}
 			arg, s = s[1:1+i], s[1+i+1:]
+			quoted = true
+		} else if strings.HasPrefix(s, `"`) {
+			q, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string: %v", err)
+			}
+			arg, _ = strconv.Unquote(q)
+			s = s[len(q):]
+			quoted = true
 		} else {
 			arg, s, _ = strings.Cut(s, " ")
 		}
@@ -564,6 +795,10 @@ func ParseFcall(s string) (*Fcall, error) {
 			}
 			f.Count = uint32(n)
 		case "data":
+			if quoted {
+				f.Data = []byte(arg)
+				break
+			}
 			f.Data = parseData(arg)
 		case "ename":
 			f.Ename = arg
@@ -656,7 +891,9 @@ func ParseFcall(s string) (*Fcall, error) {
 			f.Version = arg
 		case "wname":
 			if strings.HasPrefix(arg, "[") {
-				f.Wname = strings.Fields(arg[1 : len(arg)-1])
+				if w := strings.Fields(arg[1 : len(arg)-1]); len(w) > 0 {
+					f.Wname = w
+				}
 			} else {
 				f.Wname = []string{arg}
 			}
@@ -678,6 +915,10 @@ func ParseFcall(s string) (*Fcall, error) {
 			}
 		}
 	}
+	if (f.Type == Rread || f.Type == Twrite) && f.Count == uint32(len(f.Data)) {
+		// String prints the length of the data as a count.
+		f.Count = 0
+	}
 	return f, nil
 }
 
@@ -0,0 +1,354 @@
+package plan9_test
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"9fans.net/go/plan9"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*plan9.Fcall)(nil)
+	_ encoding.BinaryUnmarshaler = (*plan9.Fcall)(nil)
+)
+
+// testFcalls returns a message of each 9P2000 type.
+func testFcalls(t *testing.T) []*plan9.Fcall {
+	qid := plan9.Qid{Path: 0x2a, Vers: 3, Type: plan9.QTDIR}
+	stat, err := (&plan9.Dir{Name: "x", Uid: "u", Gid: "g", Muid: "m", Qid: qid}).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*plan9.Fcall{
+		{Type: plan9.Tversion, Tag: plan9.NOTAG, Msize: 8192, Version: "9P2000"},
+		{Type: plan9.Rversion, Tag: plan9.NOTAG, Msize: 8192, Version: "9P2000"},
+		{Type: plan9.Tauth, Tag: 1, Afid: 2, Uname: "glenda", Aname: "main"},
+		{Type: plan9.Rauth, Tag: 1, Aqid: plan9.Qid{Path: 7, Type: plan9.QTAUTH}},
+		{Type: plan9.Tattach, Tag: 1, Fid: 1, Afid: plan9.NOFID, Uname: "glenda"},
+		{Type: plan9.Rattach, Tag: 1, Qid: qid},
+		{Type: plan9.Rerror, Tag: 1, Ename: "file does not exist"},
+		{Type: plan9.Tflush, Tag: 2, Oldtag: 1},
+		{Type: plan9.Rflush, Tag: 2},
+		{Type: plan9.Twalk, Tag: 1, Fid: 1, Newfid: 2, Wname: []string{"a", "b"}},
+		{Type: plan9.Rwalk, Tag: 1, Wqid: []plan9.Qid{qid, qid}},
+		{Type: plan9.Topen, Tag: 1, Fid: 2, Mode: plan9.ORDWR},
+		{Type: plan9.Ropen, Tag: 1, Qid: qid, Iounit: 8168},
+		{Type: plan9.Tcreate, Tag: 1, Fid: 2, Name: "f", Perm: 0644, Mode: plan9.OWRITE},
+		{Type: plan9.Rcreate, Tag: 1, Qid: qid, Iounit: 8168},
+		{Type: plan9.Tread, Tag: 1, Fid: 2, Offset: 1 << 40, Count: 100},
+		{Type: plan9.Rread, Tag: 1, Data: []byte("hello")},
+		{Type: plan9.Twrite, Tag: 1, Fid: 2, Offset: 5, Data: []byte("world")},
+		{Type: plan9.Rread, Tag: 1, Data: []byte("del\n")},
+		{Type: plan9.Twrite, Tag: 1, Fid: 2, Data: []byte("it's \"x\"\t")},
+		{Type: plan9.Rwrite, Tag: 1, Count: 5},
+		{Type: plan9.Tclunk, Tag: 1, Fid: 2},
+		{Type: plan9.Rclunk, Tag: 1},
+		{Type: plan9.Tremove, Tag: 1, Fid: 2},
+		{Type: plan9.Rremove, Tag: 1},
+		{Type: plan9.Tstat, Tag: 1, Fid: 2},
+		{Type: plan9.Rstat, Tag: 1, Stat: stat},
+		{Type: plan9.Twstat, Tag: 1, Fid: 2, Stat: stat},
+		{Type: plan9.Rwstat, Tag: 1},
+	}
+}
+
+func TestFcallBinary(t *testing.T) {
+	for _, f := range testFcalls(t) {
+		b, err := f.MarshalBinary()
+		if err != nil {
+			t.Errorf("%v: MarshalBinary: %v", f, err)
+			continue
+		}
+		var w bytes.Buffer
+		if err := plan9.WriteFcall(&w, f); err != nil || !bytes.Equal(w.Bytes(), b) {
+			t.Errorf("%v: MarshalBinary = %x, WriteFcall wrote %x, %v", f, b, w.Bytes(), err)
+		}
+		var g plan9.Fcall
+		if err := g.UnmarshalBinary(b); err != nil {
+			t.Errorf("%v: UnmarshalBinary: %v", f, err)
+			continue
+		}
+		if !reflect.DeepEqual(&g, f) {
+			t.Errorf("UnmarshalBinary(MarshalBinary(%v)) = %v", f, &g)
+		}
+
+		// With its 9P2000.u fields zero, f round-trips in 9P2000.u too.
+		b, err = f.BytesDialect(plan9.Dialect9P2000u)
+		if err != nil {
+			t.Errorf("%v: BytesDialect(9P2000.u): %v", f, err)
+			continue
+		}
+		if u, err := plan9.UnmarshalFcallDialect(b, plan9.Dialect9P2000u); err != nil || !reflect.DeepEqual(u, f) {
+			t.Errorf("%v: 9P2000.u round trip = %v, %v", f, u, err)
+		}
+	}
+
+	// The size prefix must match the bytes that follow.
+	b, _ := (&plan9.Fcall{Type: plan9.Tclunk, Tag: 1, Fid: 2}).MarshalBinary()
+	var g plan9.Fcall
+	if err := g.UnmarshalBinary(append(b, 0)); err == nil {
+		t.Error("UnmarshalBinary accepted a trailing byte")
+	}
+	if err := g.UnmarshalBinary(b[:len(b)-1]); err == nil {
+		t.Error("UnmarshalBinary accepted a truncated message")
+	}
+}
+
+// TestFcallDialects round-trips the messages with 9P2000.u fields in
+// both dialects: 9P2000.u keeps the fields, and 9P2000 drops them.
+func TestFcallDialects(t *testing.T) {
+	dir := &plan9.Dir{
+		Name: "link", Uid: "u", Gid: "g", Muid: "m", Mode: plan9.DMSYMLINK | 0777,
+		Extension: "target", Uidnum: 1000, Gidnum: 100, Muidnum: 1000,
+	}
+	for _, dialect := range []plan9.Dialect{plan9.Dialect9P2000, plan9.Dialect9P2000u} {
+		stat, err := dir.BytesDialect(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := plan9.UnmarshalDirDialect(stat, dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantDir := *dir
+		if dialect == plan9.Dialect9P2000 {
+			wantDir.Extension = ""
+			wantDir.Uidnum, wantDir.Gidnum, wantDir.Muidnum = plan9.NOUID, plan9.NOUID, plan9.NOUID
+		}
+		if *d != wantDir {
+			t.Errorf("dialect %d: Dir decoded as %+v, want %+v", dialect, d, &wantDir)
+		}
+
+		for _, tt := range []struct {
+			f    *plan9.Fcall
+			base plan9.Fcall // f as it survives 9P2000
+		}{
+			{
+				&plan9.Fcall{Type: plan9.Tauth, Tag: 1, Afid: 2, Uname: "glenda", Aname: "main", Uid: 1000},
+				plan9.Fcall{Type: plan9.Tauth, Tag: 1, Afid: 2, Uname: "glenda", Aname: "main"},
+			},
+			{
+				&plan9.Fcall{Type: plan9.Tattach, Tag: 1, Fid: 1, Afid: plan9.NOFID, Uname: "glenda", Uid: 1000},
+				plan9.Fcall{Type: plan9.Tattach, Tag: 1, Fid: 1, Afid: plan9.NOFID, Uname: "glenda"},
+			},
+			{
+				&plan9.Fcall{Type: plan9.Rerror, Tag: 1, Ename: "no such file", Errno: 2},
+				plan9.Fcall{Type: plan9.Rerror, Tag: 1, Ename: "no such file"},
+			},
+			{
+				&plan9.Fcall{Type: plan9.Tcreate, Tag: 1, Fid: 2, Name: "l", Perm: plan9.DMSYMLINK | 0777, Extension: "target"},
+				plan9.Fcall{Type: plan9.Tcreate, Tag: 1, Fid: 2, Name: "l", Perm: plan9.DMSYMLINK | 0777},
+			},
+			{
+				&plan9.Fcall{Type: plan9.Rstat, Tag: 1, Stat: stat},
+				plan9.Fcall{Type: plan9.Rstat, Tag: 1, Stat: stat},
+			},
+		} {
+			b, err := tt.f.BytesDialect(dialect)
+			if err != nil {
+				t.Errorf("dialect %d: %v: %v", dialect, tt.f, err)
+				continue
+			}
+			g, err := plan9.UnmarshalFcallDialect(b, dialect)
+			if err != nil {
+				t.Errorf("dialect %d: %v: %v", dialect, tt.f, err)
+				continue
+			}
+			want := tt.f
+			if dialect == plan9.Dialect9P2000 {
+				want = &tt.base
+			}
+			if !reflect.DeepEqual(g, want) {
+				t.Errorf("dialect %d: %v decoded as %v, want %v", dialect, tt.f, g, want)
+			}
+		}
+	}
+}
+
+func TestFcallString(t *testing.T) {
+	for _, f := range testFcalls(t) {
+		s := f.String()
+		g, err := plan9.ParseFcall(s)
+		if err != nil {
+			t.Errorf("ParseFcall(%q): %v", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(g, f) {
+			t.Errorf("ParseFcall(%q) = %v, want %v", s, g, f)
+		}
+	}
+
+	// Binary data prints as hex; long data is cut short.
+	for _, tt := range []struct {
+		f    *plan9.Fcall
+		want string
+	}{
+		{&plan9.Fcall{Type: plan9.Rread, Tag: 3, Data: []byte{0, 1, 0xff}}, "Rread tag 3 count 3 data 0001ff"},
+		{&plan9.Fcall{Type: plan9.Rread, Tag: 3, Data: []byte("cafe")}, "Rread tag 3 count 4 data 'cafe'"},
+		{&plan9.Fcall{Type: plan9.Rread, Tag: 3, Data: bytes.Repeat([]byte("x"), 100)}, "Rread tag 3 count 100 data '" + strings.Repeat("x", 64) + "'"},
+		{&plan9.Fcall{Type: plan9.Rread, Tag: 3, Data: []byte("del\n")}, `Rread tag 3 count 4 data "del\n"`},
+		{&plan9.Fcall{Type: plan9.Rread, Tag: 3, Data: []byte("it's")}, `Rread tag 3 count 4 data "it's"`},
+		{&plan9.Fcall{Type: plan9.Rread, Tag: 3, Data: []byte("ünïcödé")}, "Rread tag 3 count 11 data 'ünïcödé'"},
+		{&plan9.Fcall{Type: plan9.Rread, Tag: 3, Data: []byte(strings.Repeat("x", 63) + "ü")}, "Rread tag 3 count 65 data '" + strings.Repeat("x", 63) + "'"},
+		{&plan9.Fcall{Type: plan9.Rerror, Tag: 3, Ename: "file does not exist"}, "Rerror tag 3 ename 'file does not exist'"},
+	} {
+		if s := tt.f.String(); s != tt.want {
+			t.Errorf("String = %q, want %q", s, tt.want)
+		}
+	}
+
+	// Every known type has its own form.
+	for typ := uint8(0); typ < 255; typ++ {
+		if name := plan9.TypeName(typ); strings.HasPrefix(name, "type ") {
+			continue
+		}
+		if s := (&plan9.Fcall{Type: typ}).String(); strings.HasPrefix(s, "unknown") {
+			t.Errorf("String of empty %s message = %q", plan9.TypeName(typ), s)
+		}
+	}
+}
+
+func TestNewTracer(t *testing.T) {
+	var buf bytes.Buffer
+	trace := plan9.NewTracer(&buf)
+	trace(&plan9.Fcall{Type: plan9.Tread, Tag: 3, Fid: 5, Count: 8192})
+	trace(&plan9.Fcall{Type: plan9.Rread, Tag: 3, Data: []byte("hi")})
+	want := "Tread tag 3 fid 5 offset 0 count 8192\nRread tag 3 count 2 data 'hi'\n"
+	if buf.String() != want {
+		t.Errorf("trace = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestMsgTypeKind(t *testing.T) {
+	responses := map[plan9.MsgType]bool{
+		plan9.Rversion: true,
+		plan9.Rauth:    true,
+		plan9.Rattach:  true,
+		plan9.Rerror:   true,
+		plan9.Rflush:   true,
+		plan9.Rwalk:    true,
+		plan9.Ropen:    true,
+		plan9.Rcreate:  true,
+		plan9.Rread:    true,
+		plan9.Rwrite:   true,
+		plan9.Rclunk:   true,
+		plan9.Rremove:  true,
+		plan9.Rstat:    true,
+		plan9.Rwstat:   true,
+		plan9.Rreaddir: true,
+		plan9.Ropenfd:  true,
+	}
+	for i := 0; i < 256; i++ {
+		typ := plan9.MsgType(i)
+		if got, want := plan9.IsResponse(typ), responses[typ]; got != want {
+			t.Errorf("IsResponse(%d) = %v, want %v", i, got, want)
+		}
+		if got, want := typ.IsError(), typ == plan9.Rerror; got != want {
+			t.Errorf("MsgType(%d).IsError() = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestFcallFromTo(t *testing.T) {
+	// Messages written and read one after another share
+	// the buffer they pass through and the one they are read into.
+	var wire, out bytes.Buffer
+	for _, tt := range []struct {
+		f    *plan9.Fcall
+		data string
+	}{
+		{&plan9.Fcall{Type: plan9.Rread, Tag: 1}, "hello, world"},
+		{&plan9.Fcall{Type: plan9.Rread, Tag: 2}, ""},
+		{&plan9.Fcall{Type: plan9.Rread, Tag: 3, Data: []byte("ignored")}, strings.Repeat("x", 10000)},
+		{&plan9.Fcall{Type: plan9.Twrite, Tag: 4, Fid: 5, Offset: 6}, "twrite"},
+	} {
+		wire.Reset()
+		if err := plan9.WriteFcallFrom(&wire, tt.f, iotest.HalfReader(strings.NewReader(tt.data)), uint32(len(tt.data))); err != nil {
+			t.Fatalf("WriteFcallFrom(%v): %v", tt.f, err)
+		}
+		want := *tt.f
+		want.Data = []byte(tt.data)
+		b, err := want.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wire.Bytes(), b) {
+			t.Errorf("WriteFcallFrom(%v, %q) wrote %x, want %x", tt.f, tt.data, wire.Bytes(), b)
+		}
+		if tt.f.Type != plan9.Rread {
+			continue
+		}
+		out.Reset()
+		n, err := plan9.ReadFcallTo(iotest.OneByteReader(&wire), &out)
+		if err != nil || n != int64(len(tt.data)) || out.String() != tt.data {
+			t.Errorf("ReadFcallTo = %d, %v, data %q, want %d, nil, %q", n, err, out.String(), len(tt.data), tt.data)
+		}
+		if wire.Len() != 0 {
+			t.Errorf("ReadFcallTo left %d bytes unread", wire.Len())
+		}
+	}
+}
+
+func TestReadFcallToErrors(t *testing.T) {
+	rread, err := (&plan9.Fcall{Type: plan9.Rread, Tag: 1, Data: []byte("hello")}).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rerror, err := (&plan9.Fcall{Type: plan9.Rerror, Tag: 1, Ename: "permission denied"}).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rclunk, err := (&plan9.Fcall{Type: plan9.Rclunk, Tag: 1}).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := bytes.Clone(rread)
+	long[0]++ // size claims a byte more than count
+	short := bytes.Clone(rread)
+	short[7]++ // count claims a byte more than size
+
+	for _, tt := range []struct {
+		name string
+		msg  []byte
+		want string
+	}{
+		{"empty", nil, "EOF"},
+		{"truncated header", rread[:5], "unexpected EOF"},
+		{"truncated data", rread[:len(rread)-1], "unexpected EOF"},
+		{"oversized size", long, "malformed Rread"},
+		{"oversized count", short, "malformed Rread"},
+		{"Rerror", rerror, "permission denied"},
+		{"Rclunk", rclunk, "unexpected message"},
+	} {
+		n, err := plan9.ReadFcallTo(iotest.OneByteReader(bytes.NewReader(tt.msg)), io.Discard)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: ReadFcallTo = %d, %v, want error %q", tt.name, n, err, tt.want)
+		}
+	}
+}
+
+func TestWriteFcallFromErrors(t *testing.T) {
+	var wire bytes.Buffer
+	f := &plan9.Fcall{Type: plan9.Rread, Tag: 1}
+	if err := plan9.WriteFcallFrom(&wire, f, strings.NewReader("abc"), 4); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("WriteFcallFrom of short data: %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	wire.Reset()
+	if err := plan9.WriteFcallFrom(&wire, f, strings.NewReader(""), 1<<32-1); err == nil {
+		t.Error("WriteFcallFrom of oversized message succeeded")
+	}
+	if wire.Len() != 0 {
+		t.Errorf("WriteFcallFrom of oversized message wrote %d bytes", wire.Len())
+	}
+
+	if err := plan9.WriteFcallFrom(&wire, &plan9.Fcall{Type: plan9.Rclunk}, strings.NewReader(""), 0); err == nil {
+		t.Error("WriteFcallFrom of Rclunk succeeded")
+	}
+}
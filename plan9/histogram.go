@@ -0,0 +1,216 @@
+package plan9
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"text/tabwriter"
+)
+
+// A SizeHistogram records the sizes in bytes of 9P messages,
+// by message type, for tuning Msize and for finding unexpectedly
+// large messages. It is an in-process profiler: it keeps a count
+// for every distinct size seen, so its memory use is bounded by
+// the negotiated Msize, not by the number of messages.
+//
+// A SizeHistogram is safe for concurrent use.
+type SizeHistogram struct {
+	mu     sync.Mutex
+	counts map[uint8]map[int]int // message type -> size -> count
+}
+
+// A SizeSummary summarizes the message sizes recorded by a SizeHistogram.
+// The percentiles are exact: P95 is the smallest size at least 95% of
+// the messages do not exceed. All fields are zero if no messages were
+// recorded.
+type SizeSummary struct {
+	Count         int
+	Min, Max      int
+	Mean          float64
+	P50, P95, P99 int
+}
+
+// NewSizeHistogram returns a new, empty SizeHistogram.
+func NewSizeHistogram() *SizeHistogram {
+	return &SizeHistogram{counts: make(map[uint8]map[int]int)}
+}
+
+// NewSizeRecorder returns a connection that behaves like conn but
+// also records the size of every 9P message passing through it,
+// in either direction, in h. Like NewRecorder, it can wrap the
+// connection given to a client or a server.
+func NewSizeRecorder(conn net.Conn, h *SizeHistogram) net.Conn {
+	return &sizeConn{Conn: conn, h: h}
+}
+
+// Record records the encoded size of f.
+// Messages that cannot be encoded are not recorded.
+func (h *SizeHistogram) Record(f *Fcall) {
+	b, err := f.Bytes()
+	if err != nil {
+		return
+	}
+	h.record(f.Type, len(b))
+}
+
+func (h *SizeHistogram) record(typ uint8, size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m := h.counts[typ]
+	if m == nil {
+		m = make(map[int]int)
+		h.counts[typ] = m
+	}
+	m[size]++
+}
+
+// Summary summarizes the sizes of all the messages recorded.
+func (h *SizeHistogram) Summary() SizeSummary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	all := make(map[int]int)
+	for _, m := range h.counts {
+		for size, n := range m {
+			all[size] += n
+		}
+	}
+	return summarize(all)
+}
+
+// TypeSummary summarizes the sizes of the messages of type typ,
+// such as Rread.
+func (h *SizeHistogram) TypeSummary(typ uint8) SizeSummary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return summarize(h.counts[typ])
+}
+
+func summarize(counts map[int]int) SizeSummary {
+	var s SizeSummary
+	if len(counts) == 0 {
+		return s
+	}
+	sizes := make([]int, 0, len(counts))
+	total := 0
+	for size, n := range counts {
+		sizes = append(sizes, size)
+		s.Count += n
+		total += size * n
+	}
+	slices.Sort(sizes)
+	s.Min, s.Max = sizes[0], sizes[len(sizes)-1]
+	s.Mean = float64(total) / float64(s.Count)
+
+	// Walk the sizes in order, noting where each percentile falls.
+	seen := 0
+	for _, size := range sizes {
+		seen += counts[size]
+		for _, p := range []struct {
+			pct int
+			dst *int
+		}{{50, &s.P50}, {95, &s.P95}, {99, &s.P99}} {
+			if *p.dst == 0 && seen*100 >= p.pct*s.Count {
+				*p.dst = size
+			}
+		}
+	}
+	return s
+}
+
+// WriteTo writes a text report of the recorded sizes to w,
+// one line per message type seen and a final line for all of them.
+func (h *SizeHistogram) WriteTo(w io.Writer) (int64, error) {
+	h.mu.Lock()
+	types := make([]uint8, 0, len(h.counts))
+	for typ := range h.counts {
+		types = append(types, typ)
+	}
+	h.mu.Unlock()
+	slices.Sort(types)
+
+	cw := &countWriter{w: w}
+	tw := tabwriter.NewWriter(cw, 0, 8, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "type\tcount\tmin\tmean\tp50\tp95\tp99\tmax\t\n")
+	line := func(name string, s SizeSummary) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%d\t%d\t%d\t%d\t\n",
+			name, s.Count, s.Min, s.Mean, s.P50, s.P95, s.P99, s.Max)
+	}
+	for _, typ := range types {
+		line(TypeName(typ), h.TypeSummary(typ))
+	}
+	line("all", h.Summary())
+	err := tw.Flush()
+	return cw.n, err
+}
+
+// A countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+type sizeConn struct {
+	net.Conn
+	h *SizeHistogram
+
+	rmu  sync.Mutex
+	rbuf []byte // incomplete message header read so far
+	rn   int    // bytes of the current read message still to come
+	smu  sync.Mutex
+	sbuf []byte
+	sn   int
+}
+
+func (c *sizeConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.rmu.Lock()
+	c.rbuf, c.rn = c.record(c.rbuf, c.rn, b[:n])
+	c.rmu.Unlock()
+	return n, err
+}
+
+func (c *sizeConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.smu.Lock()
+	c.sbuf, c.sn = c.record(c.sbuf, c.sn, b[:n])
+	c.smu.Unlock()
+	return n, err
+}
+
+// record records each message whose header appears in data, given the
+// header bytes hdr seen so far and the count skip of bytes still to come
+// in the message before last, returning the new hdr and skip.
+// It holds only message headers, not whole messages.
+func (c *sizeConn) record(hdr []byte, skip int, data []byte) ([]byte, int) {
+	for len(data) > 0 {
+		if skip > 0 {
+			n := min(skip, len(data))
+			skip -= n
+			data = data[n:]
+			continue
+		}
+		n := min(5-len(hdr), len(data))
+		hdr = append(hdr, data[:n]...)
+		data = data[n:]
+		if len(hdr) < 5 {
+			break
+		}
+		size, _ := gbit32(hdr)
+		if size < 5 {
+			// Not 9P; nothing more can be recorded.
+			return nil, 1 << 62
+		}
+		c.h.record(hdr[4], int(size))
+		skip = int(size) - 5
+		hdr = hdr[:0]
+	}
+	return hdr, skip
+}
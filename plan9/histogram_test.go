@@ -0,0 +1,72 @@
+package plan9_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"9fans.net/go/plan9"
+)
+
+func TestSizeHistogram(t *testing.T) {
+	h := plan9.NewSizeHistogram()
+	for i := 1; i <= 100; i++ {
+		h.Record(&plan9.Fcall{Type: plan9.Rread, Data: make([]byte, i)})
+	}
+	h.Record(&plan9.Fcall{Type: plan9.Tclunk, Fid: 1})
+
+	// An Rread is 11 bytes plus its data.
+	s := h.TypeSummary(plan9.Rread)
+	want := plan9.SizeSummary{Count: 100, Min: 12, Max: 111, Mean: 61.5, P50: 61, P95: 106, P99: 110}
+	if s != want {
+		t.Errorf("Rread summary = %+v, want %+v", s, want)
+	}
+	if s := h.Summary(); s.Count != 101 || s.Min != 11 {
+		t.Errorf("summary = %+v, want count 101, min 11", s)
+	}
+
+	var b strings.Builder
+	if _, err := h.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"Tclunk", "Rread", "all"} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("report missing %s:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestSizeRecorder(t *testing.T) {
+	h := plan9.NewSizeHistogram()
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := plan9.NewSizeRecorder(c1, h)
+	defer c.Close()
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		// Split the messages across writes to exercise the framing.
+		msg, _ := (&plan9.Fcall{Type: plan9.Twrite, Data: make([]byte, 100)}).Bytes()
+		msg2, _ := (&plan9.Fcall{Type: plan9.Tclunk}).Bytes()
+		all := append(msg, msg2...)
+		c.Write(all[:3])
+		c.Write(all[3:50])
+		c.Write(all[50:])
+	}()
+	buf := make([]byte, 200)
+	for n := 0; n < 23+100+11; {
+		m, err := c2.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += m
+	}
+	<-done
+	if s := h.TypeSummary(plan9.Twrite); s.Count != 1 || s.Max != 123 {
+		t.Errorf("Twrite summary = %+v, want one of 123 bytes", s)
+	}
+	if s := h.TypeSummary(plan9.Tclunk); s.Count != 1 || s.Max != 11 {
+		t.Errorf("Tclunk summary = %+v, want one of 11 bytes", s)
+	}
+}
@@ -0,0 +1,214 @@
+package plan9
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"net"
+	"sync"
+)
+
+// HMACSize is the number of bytes NewHMACConn adds to each message.
+const HMACSize = sha256.Size
+
+// NewHMACConn returns a connection that authenticates the 9P messages
+// sent over inner using HMAC-SHA256 with keys derived from the
+// pre-shared key. Both ends of the connection must use NewHMACConn
+// with the same key, at the same time: NewHMACConn does not return
+// until it has exchanged salts with the other end, and it closes inner
+// if the exchange fails.
+//
+// Each end sends a random 32-byte salt, and the MAC key for each
+// direction is derived from the key, both salts and the direction by
+// HKDF-SHA256, so that a message is valid only on the connection and
+// in the direction it was sent. Each message written, as delimited by
+// its 4-byte length prefix, is then sent unchanged followed by a
+// HMACSize-byte MAC of the message and its sequence number in that
+// direction, so that tampered, reordered, replayed or reflected
+// messages are detected. Read verifies and strips the MAC, returning
+// an error if verification fails; once that happens all further
+// reads fail.
+//
+// The messages are not encrypted. NewHMACConn is meant for networks
+// where setting up TLS is impractical, not as a replacement for it.
+func NewHMACConn(inner net.Conn, key []byte) (net.Conn, error) {
+	if len(key) == 0 {
+		return nil, errors.New("plan9: empty HMAC key")
+	}
+	wkey, rkey, err := exchangeSalts(inner, key, "9P2000 HMAC-SHA256")
+	if err != nil {
+		return nil, err
+	}
+	return &hmacConn{
+		Conn: inner,
+		wmac: hmac.New(sha256.New, wkey),
+		rmac: hmac.New(sha256.New, rkey),
+	}, nil
+}
+
+// saltSize is the size of the salt each end of a connection made by
+// NewHMACConn or NewAEADConn sends before its first message.
+const saltSize = 32
+
+// exchangeSalts sends a random salt over c while reading the peer's,
+// and returns the keys for writing and reading derived from secret,
+// both salts and label. The end whose salt sorts first writes with the
+// key labeled "c2s" (client to server) and reads with the one labeled
+// "s2c"; the other end does the reverse. A peer salt equal to our own,
+// as when our messages are reflected back, is rejected.
+// If the exchange fails, exchangeSalts closes c.
+func exchangeSalts(c net.Conn, secret []byte, label string) (wkey, rkey []byte, err error) {
+	var mine, peer [saltSize]byte
+	if _, err := io.ReadFull(rand.Reader, mine[:]); err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Write(mine[:])
+		errc <- err
+	}()
+	_, err = io.ReadFull(c, peer[:])
+	if err != nil {
+		c.Close()
+		<-errc
+		return nil, nil, err
+	}
+	if err := <-errc; err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	cmp := bytes.Compare(mine[:], peer[:])
+	if cmp == 0 {
+		c.Close()
+		return nil, nil, ProtocolError("peer sent our own salt")
+	}
+	lo, hi := mine[:], peer[:]
+	if cmp > 0 {
+		lo, hi = hi, lo
+	}
+	salt := append(append([]byte(nil), lo...), hi...)
+	c2s := hkdf(secret, salt, label+" c2s")
+	s2c := hkdf(secret, salt, label+" s2c")
+	if cmp < 0 {
+		return c2s, s2c, nil
+	}
+	return s2c, c2s, nil
+}
+
+// hkdf returns the 32-byte key derived from secret, salt and info
+// by HKDF-SHA256 (RFC 5869).
+func hkdf(secret, salt []byte, info string) []byte {
+	prk := hmac.New(sha256.New, salt)
+	prk.Write(secret)
+	okm := hmac.New(sha256.New, prk.Sum(nil))
+	okm.Write([]byte(info))
+	okm.Write([]byte{1})
+	return okm.Sum(nil)
+}
+
+type hmacConn struct {
+	net.Conn
+
+	wmu  sync.Mutex
+	wmac hash.Hash
+	wseq uint64
+	wbuf []byte // incomplete message written so far
+
+	rmu  sync.Mutex
+	rmac hash.Hash
+	rseq uint64
+	rbuf []byte // verified message data not yet returned
+	rerr error
+}
+
+var errHMAC = ProtocolError("message failed HMAC verification")
+
+func sumHMAC(h hash.Hash, seq uint64, msg []byte) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], seq)
+	h.Reset()
+	h.Write(b[:])
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+func (c *hmacConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.wbuf = append(c.wbuf, b...)
+	for len(c.wbuf) >= 4 {
+		n, _ := gbit32(c.wbuf)
+		if n < 4 {
+			c.wbuf = nil
+			return 0, ProtocolError("invalid length")
+		}
+		if uint32(len(c.wbuf)) < n {
+			break
+		}
+		msg := c.wbuf[:n]
+		out := append(msg[:n:n], sumHMAC(c.wmac, c.wseq, msg)...)
+		if _, err := c.Conn.Write(out); err != nil {
+			return 0, err
+		}
+		c.wseq++
+		c.wbuf = c.wbuf[n:]
+	}
+	if len(c.wbuf) == 0 {
+		c.wbuf = nil
+	}
+	return len(b), nil
+}
+
+func (c *hmacConn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	if len(c.rbuf) == 0 {
+		if c.rerr != nil {
+			return 0, c.rerr
+		}
+		msg, err := c.readMsg()
+		if err != nil {
+			c.rerr = err
+			return 0, err
+		}
+		c.rbuf = msg
+	}
+	n := copy(b, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+// readMsg reads the next message from the underlying connection
+// and returns it after verifying its MAC.
+func (c *hmacConn) readMsg() ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(c.Conn, hdr[:]); err != nil {
+		return nil, err
+	}
+	n, _ := gbit32(hdr[:])
+	if n < 4 {
+		return nil, ProtocolError("invalid length")
+	}
+	buf := make([]byte, int(n)+HMACSize)
+	copy(buf, hdr[:])
+	if _, err := io.ReadFull(c.Conn, buf[4:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	msg, mac := buf[:n], buf[n:]
+	if !hmac.Equal(mac, sumHMAC(c.rmac, c.rseq, msg)) {
+		return nil, errHMAC
+	}
+	c.rseq++
+	return msg, nil
+}
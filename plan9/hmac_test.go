@@ -0,0 +1,173 @@
+package plan9_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"9fans.net/go/plan9"
+)
+
+func TestHMACConn(t *testing.T) {
+	testSecureConn(t, func(c net.Conn) (net.Conn, error) {
+		return plan9.NewHMACConn(c, []byte("key"))
+	})
+}
+
+func TestHMACConnEmptyKey(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	for _, key := range [][]byte{nil, {}} {
+		if c, err := plan9.NewHMACConn(c1, key); err == nil {
+			t.Errorf("NewHMACConn with key %q = %v, want error", key, c)
+		}
+	}
+}
+
+// testSecureConn tests newConn, which wraps a connection in
+// NewHMACConn or NewAEADConn, against tampering, replay and reflection.
+func testSecureConn(t *testing.T, newConn func(net.Conn) (net.Conn, error)) {
+	tversion := &plan9.Fcall{Type: plan9.Tversion, Tag: plan9.NOTAG, Msize: 8192, Version: "9P2000"}
+	rversion := &plan9.Fcall{Type: plan9.Rversion, Tag: plan9.NOTAG, Msize: 8192, Version: "9P2000"}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		a, b, _ := securePair(t, newConn, nil)
+		go plan9.WriteFcall(a, tversion)
+		if f, err := plan9.ReadFcall(b); err != nil || !reflect.DeepEqual(f, tversion) {
+			t.Fatalf("ReadFcall = %v, %v, want %v", f, err, tversion)
+		}
+		go plan9.WriteFcall(b, rversion)
+		if f, err := plan9.ReadFcall(a); err != nil || !reflect.DeepEqual(f, rversion) {
+			t.Fatalf("ReadFcall = %v, %v, want %v", f, err, rversion)
+		}
+	})
+
+	t.Run("Tamper", func(t *testing.T) {
+		a, b, _ := securePair(t, newConn, func(i int, b []byte) []byte {
+			if i == 1 { // the first message after the salt
+				b[len(b)-1] ^= 1
+			}
+			return b
+		})
+		go func() {
+			plan9.WriteFcall(a, tversion)
+			plan9.WriteFcall(a, tversion)
+		}()
+		if f, err := plan9.ReadFcall(b); err == nil {
+			t.Fatalf("ReadFcall of tampered message = %v", f)
+		}
+		if f, err := plan9.ReadFcall(b); err == nil {
+			t.Fatalf("ReadFcall after tampered message = %v", f)
+		}
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		// Record what one end sends on a connection
+		// and replay it to a new one.
+		a, b, sent := securePair(t, newConn, nil)
+		go plan9.WriteFcall(a, tversion)
+		if _, err := plan9.ReadFcall(b); err != nil {
+			t.Fatal(err)
+		}
+		c1, c2 := net.Pipe()
+		t.Cleanup(func() { c1.Close(); c2.Close() })
+		go func() {
+			for _, b := range sent() {
+				if _, err := c1.Write(b); err != nil {
+					return
+				}
+			}
+		}()
+		go io.Copy(io.Discard, c1)
+		c, err := newConn(c2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f, err := plan9.ReadFcall(c); err == nil {
+			t.Fatalf("ReadFcall of replayed message = %v", f)
+		}
+	})
+
+	t.Run("Reflect", func(t *testing.T) {
+		// A peer that echoes everything, even our salt, is rejected.
+		c1, c2 := net.Pipe()
+		t.Cleanup(func() { c1.Close(); c2.Close() })
+		go io.Copy(c1, c1)
+		if _, err := newConn(c2); err == nil {
+			t.Fatal("handshake with a reflected salt succeeded")
+		}
+
+		// So is one that sends its own salt and then echoes.
+		c1, c2 = net.Pipe()
+		t.Cleanup(func() { c1.Close(); c2.Close() })
+		go func() {
+			salt := make([]byte, 32)
+			rand.Read(salt)
+			go c1.Write(salt)
+			io.ReadFull(c1, make([]byte, 32))
+			io.Copy(c1, c1)
+		}()
+		c, err := newConn(c2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go plan9.WriteFcall(c, tversion)
+		if f, err := plan9.ReadFcall(c); err == nil {
+			t.Fatalf("ReadFcall of reflected message = %v", f)
+		}
+	})
+}
+
+// securePair returns the two ends a and b of a connection wrapped by
+// newConn, with a relay in between that passes each write from a to b
+// through edit, if it is not nil, as the i'th write from a. It also
+// returns a function that returns the writes from a as sent to b.
+func securePair(t *testing.T, newConn func(net.Conn) (net.Conn, error), edit func(i int, b []byte) []byte) (a, b net.Conn, sent func() [][]byte) {
+	a1, a2 := net.Pipe()
+	b1, b2 := net.Pipe()
+	t.Cleanup(func() { a1.Close(); a2.Close(); b1.Close(); b2.Close() })
+	done := make(chan [][]byte, 1)
+	go func() {
+		var writes [][]byte
+		defer func() { done <- writes }()
+		buf := make([]byte, 1<<16)
+		for i := 0; ; i++ {
+			n, err := a2.Read(buf)
+			if err != nil {
+				return
+			}
+			w := bytes.Clone(buf[:n])
+			if edit != nil {
+				w = edit(i, w)
+			}
+			writes = append(writes, w)
+			if _, err := b1.Write(w); err != nil {
+				return
+			}
+		}
+	}()
+	go io.Copy(a2, b1)
+
+	errc := make(chan error, 1)
+	go func() {
+		var err error
+		a, err = newConn(a1)
+		errc <- err
+	}()
+	b, err := newConn(b2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	sent = func() [][]byte {
+		a1.Close()
+		return <-done
+	}
+	return a, b, sent
+}
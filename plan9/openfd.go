@@ -0,0 +1,12 @@
+package plan9
+
+// The plan9port message for opening a file as a Unix file descriptor.
+// Topenfd has Fid and Mode, like Topen; Ropenfd has Qid, Iounit and
+// Unixfd. The server follows Ropenfd with the descriptor itself,
+// passed over the Unix domain socket carrying the conversation, and
+// the reader of the message replaces Unixfd with the number of the
+// descriptor it received.
+const (
+	Topenfd = 98
+	Ropenfd = 99
+)
@@ -0,0 +1,181 @@
+package plan9
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewRecorder returns a connection that behaves like conn but also
+// writes a transcript of every 9P message passing through it to w,
+// one message per line:
+//
+//	2006-01-02T15:04:05.999999999Z07:00 -> 13000000...	# Tversion tag 65535 msize 8192 version '9P2000'
+//
+// The fields are the time the message was complete, its direction
+// ("->" for messages written to conn, "<-" for messages read from it),
+// and the message bytes in hex, followed by a comment giving the
+// message as formatted by Fcall.String.
+// NewReplayer reads this format back.
+func NewRecorder(conn net.Conn, w io.Writer) net.Conn {
+	return &recordConn{Conn: conn, w: w}
+}
+
+// NewSanitizedRecorder is like NewRecorder but records each message
+// as sanitized by policy, with Fcall.Sanitize, in both its bytes and
+// its comment, so that the transcript replays with the redacted
+// values. The messages passing through the connection are unchanged.
+func NewSanitizedRecorder(conn net.Conn, w io.Writer, policy SanitizePolicy) net.Conn {
+	return &recordConn{Conn: conn, w: w, policy: policy}
+}
+
+type recordConn struct {
+	net.Conn
+
+	wmu    sync.Mutex // protects w
+	w      io.Writer
+	policy SanitizePolicy
+
+	rmu  sync.Mutex
+	rbuf []byte // incomplete message read so far
+	smu  sync.Mutex
+	sbuf []byte // incomplete message written so far
+}
+
+func (c *recordConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.rmu.Lock()
+	c.rbuf = c.record("<-", append(c.rbuf, b[:n]...))
+	c.rmu.Unlock()
+	return n, err
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.smu.Lock()
+	c.sbuf = c.record("->", append(c.sbuf, b[:n]...))
+	c.smu.Unlock()
+	return n, err
+}
+
+// record writes a transcript line for each complete message in buf
+// and returns the incomplete remainder.
+func (c *recordConn) record(dir string, buf []byte) []byte {
+	for len(buf) >= 4 {
+		n, _ := gbit32(buf)
+		if n < 4 {
+			// Not 9P; nothing more can be recorded.
+			return nil
+		}
+		if uint32(len(buf)) < n {
+			break
+		}
+		msg := buf[:n]
+		var desc string
+		if f, err := UnmarshalFcall(msg); err != nil {
+			desc = err.Error()
+		} else {
+			if c.policy != 0 {
+				f = f.Sanitize(c.policy)
+				if b, err := f.Bytes(); err == nil {
+					msg = b
+				}
+			}
+			desc = strings.ReplaceAll(f.String(), "\n", `\n`)
+		}
+		c.wmu.Lock()
+		fmt.Fprintf(c.w, "%s %s %x\t# %s\n", time.Now().Format(time.RFC3339Nano), dir, msg, desc)
+		c.wmu.Unlock()
+		buf = buf[n:]
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	return buf
+}
+
+// NewReplayer returns a connection that replays a transcript
+// written by NewRecorder. Reads from the connection return,
+// in order, the messages recorded as read ("<-");
+// writes to it are discarded without being checked.
+// After the last recorded message, reads return io.EOF.
+func NewReplayer(r io.Reader) (net.Conn, error) {
+	c := new(replayConn)
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<30)
+	for lineno := 1; s.Scan(); lineno++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) < 3 || f[1] != "->" && f[1] != "<-" {
+			return nil, fmt.Errorf("replay:%d: malformed transcript line", lineno)
+		}
+		msg, err := hex.DecodeString(f[2])
+		if err != nil {
+			return nil, fmt.Errorf("replay:%d: invalid message: %v", lineno, err)
+		}
+		if f[1] == "<-" {
+			c.data = append(c.data, msg...)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type replayConn struct {
+	mu     sync.Mutex
+	data   []byte // recorded messages not yet read
+	closed bool
+}
+
+var errReplayClosed = ProtocolError("replay connection closed")
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, errReplayClosed
+	}
+	if len(c.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, c.data)
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func (c *replayConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, errReplayClosed
+	}
+	return len(b), nil
+}
+
+func (c *replayConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+type replayAddr struct{}
+
+func (replayAddr) Network() string { return "replay" }
+func (replayAddr) String() string  { return "replay" }
+
+func (c *replayConn) LocalAddr() net.Addr                { return replayAddr{} }
+func (c *replayConn) RemoteAddr() net.Addr               { return replayAddr{} }
+func (c *replayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *replayConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,126 @@
+package plan9_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/srv9p"
+)
+
+func TestRecordReplay(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	srv := &srv9p.Server{Tree: srv9p.NewTree("ram", "ram", plan9.DMDIR|0777, nil)}
+	go srv.Serve(c2, c2)
+
+	// Record a session.
+	var transcript bytes.Buffer
+	rec := plan9.NewRecorder(c1, &transcript)
+	reqs := []*plan9.Fcall{
+		{Type: plan9.Tversion, Tag: plan9.NOTAG, Msize: 8192, Version: "9P2000"},
+		{Type: plan9.Tattach, Tag: 1, Fid: 1, Afid: plan9.NOFID, Uname: "glenda"},
+		{Type: plan9.Twalk, Tag: 1, Fid: 1, Newfid: 2},
+		{Type: plan9.Tstat, Tag: 1, Fid: 2},
+		{Type: plan9.Tclunk, Tag: 1, Fid: 2},
+	}
+	var resps []*plan9.Fcall
+	var want []string // direction and hex of each message
+	for _, req := range reqs {
+		if err := plan9.WriteFcall(rec, req); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := plan9.ReadFcall(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Type != req.Type+1 {
+			t.Fatalf("%v: got %v", req, resp)
+		}
+		resps = append(resps, resp)
+		for _, m := range []struct {
+			dir string
+			f   *plan9.Fcall
+		}{{"->", req}, {"<-", resp}} {
+			b, err := m.f.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, m.dir+" "+hex.EncodeToString(b))
+		}
+	}
+
+	// The transcript has each message, in order.
+	var got []string
+	for _, line := range strings.Split(strings.TrimSuffix(transcript.String(), "\n"), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 3 {
+			t.Fatalf("malformed transcript line %q", line)
+		}
+		got = append(got, f[1]+" "+f[2])
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("transcript:\n%s\nwant messages:\n%s", transcript.String(), strings.Join(want, "\n"))
+	}
+
+	// Replaying it returns the responses, in order, and then EOF.
+	c, err := plan9.NewReplayer(&transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, req := range reqs {
+		if err := plan9.WriteFcall(c, req); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := plan9.ReadFcall(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(resp, resps[i]) {
+			t.Errorf("replayed response %d = %v, want %v", i, resp, resps[i])
+		}
+	}
+	if f, err := plan9.ReadFcall(c); err != io.EOF {
+		t.Errorf("ReadFcall after end of transcript = %v, %v, want EOF", f, err)
+	}
+}
+
+func TestSanitizedRecorder(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	var transcript bytes.Buffer
+	rec := plan9.NewSanitizedRecorder(c1, &transcript, plan9.RedactCredentials)
+	tattach := &plan9.Fcall{Type: plan9.Tattach, Tag: 1, Fid: 1, Afid: plan9.NOFID, Uname: "glenda", Aname: "secret"}
+	errc := make(chan error, 1)
+	go func() { errc <- plan9.WriteFcall(rec, tattach) }()
+
+	// The message itself is unchanged.
+	f, err := plan9.ReadFcall(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f, tattach) {
+		t.Errorf("message sent = %v, want %v", f, tattach)
+	}
+
+	want, err := tattach.Sanitize(plan9.RedactCredentials).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := transcript.String()
+	if strings.Contains(line, "glenda") || strings.Contains(line, "secret") {
+		t.Errorf("transcript has credentials: %s", line)
+	}
+	if fields := strings.Fields(line); len(fields) < 3 || fields[2] != hex.EncodeToString(want) {
+		t.Errorf("transcript = %s, want message %x", line, want)
+	}
+}
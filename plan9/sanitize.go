@@ -0,0 +1,49 @@
+package plan9
+
+// A SanitizePolicy selects the fields of an Fcall that Sanitize redacts.
+type SanitizePolicy uint
+
+const (
+	RedactUname SanitizePolicy = 1 << iota // Uname and Uid of Tauth and Tattach
+	RedactAname                            // Aname of Tauth and Tattach
+	RedactName                             // Name of Tcreate
+	RedactData                             // Data of Twrite and Rread, such as an auth conversation
+
+	// RedactCredentials redacts the fields that identify the user.
+	RedactCredentials = RedactUname | RedactAname
+)
+
+// Redacted is the text Sanitize puts in place of redacted fields.
+const Redacted = "[REDACTED]"
+
+// Sanitize returns a copy of f, for logging, with the fields selected
+// by policy replaced by Redacted (or, for Uid, by NOUID).
+// Empty fields are left empty, so that the log still shows their absence.
+// The copy shares f's slices other than the ones it replaces.
+func (f *Fcall) Sanitize(policy SanitizePolicy) *Fcall {
+	g := *f
+	redact := func(s *string) {
+		if *s != "" {
+			*s = Redacted
+		}
+	}
+	switch f.Type {
+	case Tauth, Tattach:
+		if policy&RedactUname != 0 {
+			redact(&g.Uname)
+			g.Uid = NOUID
+		}
+		if policy&RedactAname != 0 {
+			redact(&g.Aname)
+		}
+	case Tcreate:
+		if policy&RedactName != 0 {
+			redact(&g.Name)
+		}
+	case Twrite, Rread:
+		if policy&RedactData != 0 && len(g.Data) > 0 {
+			g.Data = []byte(Redacted)
+		}
+	}
+	return &g
+}
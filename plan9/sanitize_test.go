@@ -0,0 +1,30 @@
+package plan9_test
+
+import (
+	"strings"
+	"testing"
+
+	"9fans.net/go/plan9"
+)
+
+func TestSanitize(t *testing.T) {
+	f := &plan9.Fcall{Type: plan9.Tattach, Fid: 1, Afid: plan9.NOFID, Uname: "glenda", Aname: "secret"}
+	g := f.Sanitize(plan9.RedactCredentials)
+	if g.Uname != plan9.Redacted || g.Aname != plan9.Redacted || g.Fid != 1 {
+		t.Errorf("Sanitize = %v", g)
+	}
+	if f.Uname != "glenda" {
+		t.Errorf("Sanitize modified its receiver")
+	}
+	if s := g.String(); strings.Contains(s, "glenda") || strings.Contains(s, "secret") {
+		t.Errorf("sanitized message prints as %q", s)
+	}
+
+	f = &plan9.Fcall{Type: plan9.Twrite, Data: []byte("password")}
+	if g := f.Sanitize(plan9.RedactCredentials); string(g.Data) != "password" {
+		t.Errorf("RedactCredentials redacted Twrite data")
+	}
+	if g := f.Sanitize(plan9.RedactData); string(g.Data) != plan9.Redacted {
+		t.Errorf("RedactData left Twrite data %q", g.Data)
+	}
+}
@@ -0,0 +1,320 @@
+// Package server provides ready-made 9P servers built on srv9p.
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/srv9p"
+)
+
+// A MemFS is a file system held in memory and served over 9P,
+// with the semantics of a Plan 9 disk file system:
+// writes increment the file's qid version,
+// DMAPPEND files are written only at their end,
+// a DMEXCL file can be open by only one fid at a time,
+// and a file opened with ORCLOSE is removed when its fid is clunked.
+// All files are owned by the MemFS's user.
+// A file can hold at most 1 GiB; writes and wstats that would make
+// it longer fail, rather than exhausting the server's memory.
+//
+// A MemFS is safe for concurrent use by any number of connections.
+type MemFS struct {
+	tree *srv9p.Tree
+	uid  string
+}
+
+// A memFile is the per-File storage, saved in the File's Aux field.
+type memFile struct {
+	mu    sync.Mutex
+	data  []byte
+	nopen int // number of fids open on the file, for DMEXCL
+}
+
+// An openFid records how a fid was opened, saved in the Fid's Aux field.
+type openFid struct {
+	file *srv9p.File
+	mode uint8
+}
+
+// maxLength is the maximum length of a file.
+const maxLength = 1 << 30
+
+var (
+	errBadName   = errors.New("bad file name")
+	errExclusive = errors.New("exclusive use file already open")
+	errDirLength = errors.New("cannot set length of directory")
+	errWstatUid  = errors.New("wstat cannot change uid")
+)
+
+// New returns a new, empty MemFS whose files are owned by uid.
+// If uid is empty, New uses $USER, as [srv9p.NewTree] does.
+func New(uid string) *MemFS {
+	tree := srv9p.NewTree(uid, "", 0777, nil)
+	tree.Root.Aux = new(memFile)
+	return &MemFS{tree: tree, uid: tree.Root.Stat.Uid}
+}
+
+// FromFS returns a new MemFS holding a copy of the files and
+// directories in fsys, owned by uid.
+// Each takes its permission bits and modification time from fsys,
+// except that a file or directory with no permission bits at all,
+// as in a [testing/fstest.MapFS] that does not set them,
+// gets permissions 0666 or 0777.
+// Entries that are neither regular files nor directories are skipped.
+func FromFS(fsys fs.FS, uid string) (*MemFS, error) {
+	m := New(uid)
+	dirs := map[string]*srv9p.File{".": m.tree.Root}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		mf := new(memFile)
+		perm := plan9.Perm(info.Mode().Perm())
+		if d.IsDir() {
+			perm |= plan9.DMDIR
+			if perm&0777 == 0 {
+				perm |= 0777
+			}
+		} else {
+			if perm&0777 == 0 {
+				perm |= 0666
+			}
+			if mf.data, err = fs.ReadFile(fsys, name); err != nil {
+				return err
+			}
+		}
+		f, err := dirs[path.Dir(name)].Create(d.Name(), m.uid, perm, mf)
+		if err != nil {
+			return &fs.PathError{Op: "create", Path: name, Err: err}
+		}
+		f.Stat.Length = uint64(len(mf.data))
+		f.Stat.Mtime = uint32(info.ModTime().Unix())
+		f.Stat.Atime = f.Stat.Mtime
+		if d.IsDir() {
+			dirs[name] = f
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Serve serves m on the single 9P conversation read from in
+// and written to out. It returns when reading from in fails.
+func (m *MemFS) Serve(in io.ReadCloser, out io.WriteCloser) {
+	m.Server().Serve(in, out)
+}
+
+// Server returns a new [srv9p.Server] serving m,
+// for callers that want to set its Msize or Trace.
+func (m *MemFS) Server() *srv9p.Server {
+	return &srv9p.Server{
+		Tree:   m.tree,
+		Open:   m.open,
+		Create: m.create,
+		Read:   m.read,
+		Write:  m.write,
+		Wstat:  m.wstat,
+		Clunk:  m.clunk,
+	}
+}
+
+func (m *MemFS) open(ctx context.Context, fid *srv9p.Fid, mode uint8) error {
+	file := fid.File()
+	mf := file.Aux.(*memFile)
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	file.LockStat()
+	defer file.UnlockStat()
+
+	if file.Stat.Mode&plan9.DMEXCL != 0 && mf.nopen > 0 {
+		return errExclusive
+	}
+	if mode&plan9.OTRUNC != 0 {
+		mf.data = nil
+		file.Stat.Length = 0
+		file.Stat.Qid.Vers++
+		m.touch(file)
+	}
+	mf.nopen++
+	fid.SetQid(file.Stat.Qid)
+	fid.SetAux(&openFid{file, mode})
+	return nil
+}
+
+func (m *MemFS) create(ctx context.Context, fid *srv9p.Fid, name string, perm plan9.Perm, mode uint8) (plan9.Qid, error) {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return plan9.Qid{}, errBadName
+	}
+	dir := fid.File()
+	// As on Plan 9, the directory's permissions limit the new file's.
+	if perm&plan9.DMDIR != 0 {
+		if mode&^plan9.ORCLOSE != plan9.OREAD {
+			return plan9.Qid{}, errors.New("is a directory")
+		}
+		perm &= ^plan9.Perm(0777) | stat(dir).Mode&0777
+	} else {
+		perm &= ^plan9.Perm(0666) | stat(dir).Mode&0666
+	}
+	mf := &memFile{nopen: 1}
+	f, err := dir.Create(name, m.uid, perm, mf)
+	if err != nil {
+		return plan9.Qid{}, err
+	}
+	dm := dir.Aux.(*memFile)
+	dm.mu.Lock()
+	dir.LockStat()
+	m.touch(dir)
+	dir.UnlockStat()
+	dm.mu.Unlock()
+
+	fid.SetFile(f)
+	fid.SetAux(&openFid{f, mode})
+	return stat(f).Qid, nil
+}
+
+func (m *MemFS) read(ctx context.Context, fid *srv9p.Fid, data []byte, offset int64) (int, error) {
+	file := fid.File()
+	if stat(file).Mode&plan9.DMDIR != 0 {
+		// srv9p reads directories opened with Topen itself,
+		// so this is a directory made by Tcreate, which starts out empty.
+		return 0, nil
+	}
+	mf := file.Aux.(*memFile)
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	return fid.ReadBytes(data, offset, mf.data)
+}
+
+func (m *MemFS) write(ctx context.Context, fid *srv9p.Fid, data []byte, offset int64) (int, error) {
+	file := fid.File()
+	mf := file.Aux.(*memFile)
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	file.LockStat()
+	defer file.UnlockStat()
+
+	if file.Stat.Mode&plan9.DMAPPEND != 0 {
+		offset = int64(len(mf.data))
+	}
+	if offset < 0 || offset > maxLength-int64(len(data)) {
+		return 0, srv9p.ErrBadOffset
+	}
+	end := int(offset) + len(data)
+	if len(mf.data) < end {
+		mf.data = slices.Grow(mf.data, end-len(mf.data))
+		mf.data = mf.data[:end]
+	}
+	copy(mf.data[offset:], data)
+	file.Stat.Length = uint64(len(mf.data))
+	m.touch(file)
+	return len(data), nil
+}
+
+func (m *MemFS) wstat(ctx context.Context, fid *srv9p.Fid, d *plan9.Dir) error {
+	file := fid.File()
+	mf := file.Aux.(*memFile)
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	// Check everything before changing anything,
+	// so that a failed wstat leaves the file as it was.
+	old := stat(file)
+	isDir := old.Mode&plan9.DMDIR != 0
+	if d.Uid != "" && d.Uid != old.Uid {
+		return errWstatUid
+	}
+	if ^d.Length != 0 && isDir && d.Length != 0 {
+		return errDirLength
+	}
+	if ^d.Length != 0 && d.Length > maxLength {
+		return srv9p.ErrBadOffset
+	}
+	if d.Name != "" && d.Name != old.Name {
+		if d.Name == "." || d.Name == ".." || strings.Contains(d.Name, "/") {
+			return errBadName
+		}
+		if err := file.Rename(d.Name); err != nil {
+			return err
+		}
+	}
+
+	file.LockStat()
+	defer file.UnlockStat()
+
+	if ^d.Length != 0 && !isDir && d.Length != file.Stat.Length {
+		if d.Length < uint64(len(mf.data)) {
+			mf.data = mf.data[:d.Length]
+		} else {
+			mf.data = append(mf.data, make([]byte, d.Length-uint64(len(mf.data)))...)
+		}
+		file.Stat.Length = d.Length
+		file.Stat.Qid.Vers++
+	}
+	if ^d.Mode != 0 {
+		file.Stat.Mode = d.Mode
+		file.Stat.Qid.Type &^= plan9.QTAPPEND | plan9.QTEXCL
+		if d.Mode&plan9.DMAPPEND != 0 {
+			file.Stat.Qid.Type |= plan9.QTAPPEND
+		}
+		if d.Mode&plan9.DMEXCL != 0 {
+			file.Stat.Qid.Type |= plan9.QTEXCL
+		}
+	}
+	if ^d.Mtime != 0 {
+		file.Stat.Mtime = d.Mtime
+	}
+	if d.Gid != "" {
+		file.Stat.Gid = d.Gid
+	}
+	return nil
+}
+
+func (m *MemFS) clunk(fid *srv9p.Fid) {
+	o, ok := fid.Aux().(*openFid)
+	if !ok {
+		return
+	}
+	mf := o.file.Aux.(*memFile)
+	mf.mu.Lock()
+	mf.nopen--
+	mf.mu.Unlock()
+
+	// A Tremove has already removed the file and cleared fid's File.
+	if o.mode&plan9.ORCLOSE != 0 && fid.File() != nil {
+		o.file.Remove()
+	}
+}
+
+// touch records a change to file's contents.
+// file's memFile and Stat must be locked.
+func (m *MemFS) touch(file *srv9p.File) {
+	file.Stat.Mtime = uint32(time.Now().Unix())
+	file.Stat.Muid = m.uid
+}
+
+// stat returns a copy of file's Stat.
+func stat(file *srv9p.File) plan9.Dir {
+	file.LockStat()
+	defer file.UnlockStat()
+	return file.Stat
+}
@@ -0,0 +1,207 @@
+package server_test
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+	"9fans.net/go/plan9/server"
+)
+
+// mount serves m on a pipe and returns a client attached to it.
+func mount(t *testing.T, m *server.MemFS) *client.Fsys {
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c1.Close(); c2.Close() })
+	go m.Serve(c2, c2)
+	conn, err := client.NewConn(c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fsys
+}
+
+func TestMemFSFromFS(t *testing.T) {
+	m, err := server.FromFS(fstest.MapFS{
+		"a/b.txt": {Data: []byte("hello")},
+		"c":       {Data: []byte("world"), Mode: 0444},
+	}, "glenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := mount(t, m)
+
+	data, err := fsys.ReadFileRange("a/b.txt", 0, 100)
+	if string(data) != "hello" || err != nil {
+		t.Errorf("read a/b.txt = %q, %v, want %q, nil", data, err, "hello")
+	}
+	d, err := fsys.Stat("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Mode != 0444 || d.Length != 5 || d.Uid != "glenda" {
+		t.Errorf("stat c = mode %v length %d uid %s, want 0444 5 glenda", d.Mode, d.Length, d.Uid)
+	}
+	if _, err := fsys.Open("c", plan9.OWRITE); err == nil {
+		t.Errorf("open read-only c for write succeeded")
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	fsys := mount(t, server.New("glenda"))
+
+	// Writes increment the qid version.
+	fid, err := fsys.Create("f", plan9.ORDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := fid.Qid().Vers
+	if _, err := fid.WriteAt([]byte("abc"), 0); err != nil {
+		t.Fatal(err)
+	}
+	d, err := fid.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Qid.Vers != v+1 || d.Length != 3 {
+		t.Errorf("after write, vers %d length %d, want %d 3", d.Qid.Vers, d.Length, v+1)
+	}
+	fid.Close()
+
+	// An empty directory reads as empty.
+	fid, err = fsys.Create("dir", plan9.OREAD, plan9.DMDIR|0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid.Close()
+	dirs, err := fsys.ReadDir("dir")
+	if len(dirs) != 0 || err != nil {
+		t.Errorf("ReadDir(dir) = %v, %v, want none", dirs, err)
+	}
+
+	// Append-only files are written at their end.
+	fid, err = fsys.Create("log", plan9.OWRITE, plan9.DMAPPEND|0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid.WriteAt([]byte("one "), 0)
+	fid.WriteAt([]byte("two"), 0)
+	fid.Close()
+	data, err := fsys.ReadFileRange("log", 0, 100)
+	if string(data) != "one two" || err != nil {
+		t.Errorf("read log = %q, %v, want %q, nil", data, err, "one two")
+	}
+
+	// Exclusive-use files can be open only once.
+	fid, err = fsys.OpenExcl("lock", 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fid2, err := fsys.Open("lock", plan9.OREAD); err == nil {
+		fid2.Close()
+		t.Errorf("second open of exclusive-use file succeeded")
+	}
+	fid.Close()
+	fid, err = fsys.Open("lock", plan9.OREAD)
+	if err != nil {
+		t.Fatalf("open of exclusive-use file after close: %v", err)
+	}
+	fid.Close()
+
+	// ORCLOSE files are removed at clunk.
+	fid, err = fsys.Create("tmp", plan9.OWRITE|plan9.ORCLOSE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Stat("tmp"); err != nil {
+		t.Fatal(err)
+	}
+	fid.Close()
+	if _, err := fsys.Stat("tmp"); err == nil {
+		t.Errorf("ORCLOSE file still exists after clunk")
+	}
+
+	// Wstat renames and truncates.
+	d = new(plan9.Dir)
+	d.Null()
+	d.Name = "g"
+	d.Length = 1
+	if err := fsys.Wstat("f", d); err != nil {
+		t.Fatal(err)
+	}
+	data, err = fsys.ReadFileRange("g", 0, 100)
+	if string(data) != "a" || err != nil {
+		t.Errorf("read g = %q, %v, want %q, nil", data, err, "a")
+	}
+	d.Name = "log"
+	d.Length = ^uint64(0)
+	if err := fsys.Wstat("g", d); err == nil {
+		t.Errorf("rename onto existing file succeeded")
+	}
+}
+
+func TestMemFSLimits(t *testing.T) {
+	fsys := mount(t, server.New("glenda"))
+	fid, err := fsys.Create("f", plan9.ORDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid.Close()
+	if _, err := fid.WriteAt([]byte("x"), 1<<40); err == nil {
+		t.Error("write at offset 1<<40 succeeded")
+	}
+	d := new(plan9.Dir)
+	d.Null()
+	d.Length = 1 << 62
+	if err := fid.Wstat(d); err == nil {
+		t.Error("wstat of length 1<<62 succeeded")
+	}
+	if d, err := fid.Stat(); err != nil || d.Length != 0 {
+		t.Errorf("stat after failed write and wstat = %v, %v, want length 0", d, err)
+	}
+}
+
+// TestMemFSConcurrent has several connections use the same files
+// at once, for the race detector to check.
+func TestMemFSConcurrent(t *testing.T) {
+	m := server.New("glenda")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		fsys := mount(t, m)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				fid, err := fsys.Create("f", plan9.ORDWR, 0666)
+				if err != nil {
+					// Another connection created it first.
+					if fid, err = fsys.Open("f", plan9.ORDWR); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+				fid.WriteAt([]byte("hello"), int64(j))
+				fid.ReadAt(make([]byte, 10), 0)
+				fid.Stat()
+				d := new(plan9.Dir)
+				d.Null()
+				d.Length = uint64(j)
+				d.Mode = 0666
+				fid.Wstat(d)
+				fid.Close()
+				fsys.ReadDir("/")
+				if f, err := fsys.Create(fmt.Sprintf("tmp%d", j), plan9.OREAD|plan9.ORCLOSE, 0666); err == nil {
+					f.Close()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -19,9 +19,10 @@ import (
  */
 type File struct {
 	Aux  any
-	Stat plan9.Dir
+	Stat plan9.Dir // see LockStat
 
-	tree *Tree
+	tree   *Tree
+	statmu sync.RWMutex // protects Stat; held after mu, never before
 
 	ref     atomic.Int32
 	readers atomic.Int32
@@ -79,8 +80,27 @@ func NewTree(uid, gid string, mode plan9.Perm, cleanup func(*File)) *Tree {
 	return t
 }
 
+// LockStat locks f.Stat for changing. The server reads f.Stat while
+// answering requests and changes it itself only to increment its
+// qid version after each Write, holding the lock to do either,
+// so a handler that changes f.Stat while the server is running
+// must hold the lock too. The lock must not be held while calling
+// the other methods of f, which may take it themselves.
+func (f *File) LockStat() { f.statmu.Lock() }
+
+// UnlockStat unlocks f.Stat.
+func (f *File) UnlockStat() { f.statmu.Unlock() }
+
+// stat returns a copy of f.Stat.
+func (f *File) stat() plan9.Dir {
+	f.statmu.RLock()
+	defer f.statmu.RUnlock()
+	return f.Stat
+}
+
 func (f *File) Create(name, uid string, perm plan9.Perm, aux any) (*File, error) {
-	if f.Stat.Qid.Type&plan9.QTDIR == 0 {
+	fstat := f.stat()
+	if fstat.Qid.Type&plan9.QTDIR == 0 {
 		return nil, fmt.Errorf("create in non-directory")
 	}
 
@@ -122,7 +142,7 @@ func (f *File) Create(name, uid string, perm plan9.Perm, aux any) (*File, error)
 			Name:  name,
 			Qid:   qid,
 			Uid:   uid,
-			Gid:   f.Stat.Gid,
+			Gid:   fstat.Gid,
 			Muid:  uid,
 			Mode:  perm,
 			Mtime: now,
@@ -205,6 +225,36 @@ func (f *File) remove() (err error) {
 	return nil
 }
 
+// Remove removes f from the tree. It fails if f is the root
+// or is a non-empty directory.
+func (f *File) Remove() error {
+	return f.remove()
+}
+
+// Rename changes the name of f within its directory.
+// It fails if the directory already has a different file of that name.
+func (f *File) Rename(name string) error {
+	fp := f.parent
+	if fp == nil {
+		return errors.New("no parent")
+	}
+	if fp == f {
+		return errors.New("cannot rename root")
+	}
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	for _, c := range fp.child {
+		if c != nil && c != f && c.Stat.Name == name {
+			return fmt.Errorf("file already exists")
+		}
+	}
+	f.statmu.Lock()
+	f.Stat.Name = name
+	f.statmu.Unlock()
+	return nil
+}
+
 func (f *File) trimChildList() {
 	/*
 	 * can't delete filelist structures while there
@@ -239,7 +289,7 @@ func (f *File) openDir() (*dirReader, error) {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 
-	if f.Stat.Mode&plan9.DMDIR == 0 {
+	if f.stat().Mode&plan9.DMDIR == 0 {
 		return nil, errNotDir
 	}
 
@@ -263,7 +313,8 @@ func (r *dirReader) Read(b []byte) (int, error) {
 		if c == nil {
 			continue
 		}
-		stat, err := c.Stat.Bytes()
+		d := c.stat()
+		stat, err := d.Bytes()
 		if err != nil {
 			continue
 		}
@@ -300,11 +351,11 @@ func (f *File) Walk(r *request, fid, newfid *Fid, names []string) ([]plan9.Qid,
 		if f == nil {
 			break
 		}
-		qids = append(qids, f.Stat.Qid)
+		qids = append(qids, f.stat().Qid)
 	}
 	if f != nil {
 		newfid.SetFile(f)
-		newfid.SetQid(f.Stat.Qid)
+		newfid.SetQid(f.stat().Qid)
 	}
 	return qids, nil
 }
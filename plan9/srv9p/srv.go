@@ -490,7 +490,7 @@ func (c *conn) attach(r *request) {
 	qid := plan9.Qid{Type: plan9.QTDIR}
 	if c.srv.Tree != nil {
 		fid.SetFile(c.srv.Tree.Root)
-		qid = fid.File().Stat.Qid
+		qid = fid.File().stat().Qid
 		fid.SetQid(qid)
 	}
 	if c.srv.Attach != nil {
@@ -673,7 +673,7 @@ func (c *conn) open(r *request) {
 			r.err = errPerm
 			return
 		}
-		r.ofcall.Qid = file.Stat.Qid
+		r.ofcall.Qid = file.stat().Qid
 		if r.ofcall.Qid.Type&plan9.QTDIR != 0 {
 			var dr *dirReader
 			dr, r.err = file.openDir()
@@ -791,7 +791,9 @@ func (c *conn) write(r *request) {
 	}
 	r.ofcall.Count = uint32(n)
 	if file := fid.File(); file != nil {
+		file.LockStat()
 		file.Stat.Qid.Vers++
+		file.UnlockStat()
 	}
 }
 
@@ -836,8 +838,8 @@ func (c *conn) stat(r *request) {
 	if c.srv.Stat != nil {
 		d, r.err = c.srv.Stat(r.ctx, fid)
 	} else if file := fid.File(); file != nil {
-		// TODO lock and copy?
-		d = &file.Stat
+		stat := file.stat()
+		d = &stat
 	} else {
 		r.err = errNoStat
 	}
@@ -3,19 +3,20 @@ package srv9p
 // hasPerm does simplistic permission checking.
 // It assumes that each user is the leader of her own group.
 func hasPerm(f *File, uid string, perm int) bool {
-	m := int(f.Stat.Mode) // other
+	stat := f.stat()
+	m := int(stat.Mode) // other
 	if perm&m == perm {
 		return true
 	}
 
-	if f.Stat.Uid == uid {
+	if stat.Uid == uid {
 		m |= m >> 6
 		if perm&m == perm {
 			return true
 		}
 	}
 
-	if f.Stat.Gid == uid {
+	if stat.Gid == uid {
 		m |= m >> 3
 		if perm&m == perm {
 			return true
@@ -0,0 +1,26 @@
+package plan9
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// NewTracer returns a function that writes each Fcall it is given
+// to w, one per line in the form of Fcall.String, such as
+//
+//	Tread tag 3 fid 5 offset 0 count 8192
+//
+// It may be called from more than one goroutine at once.
+// The lines can be read back with ParseFcall, except that data
+// longer than 64 bytes is cut short. Errors writing to w are ignored.
+// The result suits client.WithTracer, for tracing a connection;
+// client.WithSanitize keeps credentials and data out of the trace.
+func NewTracer(w io.Writer) func(*Fcall) {
+	var mu sync.Mutex
+	return func(f *Fcall) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, "%v\n", f)
+	}
+}
@@ -0,0 +1,91 @@
+//go:build !plan9
+// +build !plan9
+
+package plan9_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+	"9fans.net/go/plan9/srv9p"
+)
+
+// transcriptMessages decodes the messages of a NewRecorder transcript
+// that were sent in direction dir ("->" or "<-").
+func transcriptMessages(t *testing.T, transcript []byte, dir string) []*plan9.Fcall {
+	t.Helper()
+	var msgs []*plan9.Fcall
+	s := bufio.NewScanner(bytes.NewReader(transcript))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) < 3 || f[1] != dir {
+			continue
+		}
+		b, err := hex.DecodeString(f[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := plan9.UnmarshalFcall(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// TestWalkClone checks that walking a Fid to "/" sends a Twalk with
+// no names, which clones the fid on the server, and that the clone
+// refers to the same file as the original.
+func TestWalkClone(t *testing.T) {
+	srv := &srv9p.Server{
+		Tree: srv9p.NewTree("glenda", "glenda", plan9.DMDIR|0777, nil),
+	}
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go srv.Serve(c2, c2)
+
+	var transcript bytes.Buffer
+	conn, err := client.NewConn(plan9.NewRecorder(c1, &transcript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone, err := fs.Open("/", plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clone.Close()
+
+	var attach plan9.Qid
+	for _, m := range transcriptMessages(t, transcript.Bytes(), "<-") {
+		if m.Type == plan9.Rattach {
+			attach = m.Qid
+		}
+	}
+	var walks []*plan9.Fcall
+	for _, m := range transcriptMessages(t, transcript.Bytes(), "->") {
+		if m.Type == plan9.Twalk {
+			walks = append(walks, m)
+		}
+	}
+	if len(walks) != 1 {
+		t.Fatalf("client sent %d Twalks, want 1", len(walks))
+	}
+	if w := walks[0]; len(w.Wname) != 0 || w.Newfid == w.Fid {
+		t.Errorf("client sent %v, want a clone with no names", w)
+	}
+	if clone.Qid() != attach {
+		t.Errorf("clone has qid %v, want root qid %v", clone.Qid(), attach)
+	}
+}